@@ -4,16 +4,21 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"telescope/config"
 	"telescope/ui"
 
 	"telescope/util/side_channel"
 
+	"telescope/util/buffer"
 	"telescope/util/file_util"
+
+	"golang.org/x/exp/mmap"
 )
 
 func printHelp() {
@@ -26,9 +31,10 @@ func printVersion() {
 }
 
 type programArgs struct {
-	option         string
-	firstFilename  string
-	secondFilename string
+	option          string
+	firstFilename   string
+	secondFilename  string
+	concatFilenames []string // --concat - input files after the first one
 }
 
 func main() {
@@ -63,12 +69,28 @@ func main() {
 		if !promptDeleteLogFile(args) {
 			return
 		}
-		err := ui.RunEditor(args.firstFilename, args.secondFilename, false)
+		err := ui.RunEditor(args.firstFilename, args.secondFilename, ui.Options{})
 		if err != nil {
 			side_channel.Panic(err)
 		}
 	case "--unsafe":
-		err := ui.RunEditor(args.firstFilename, "", true)
+		err := ui.RunEditor(args.firstFilename, "", ui.Options{MultiMode: true})
+		if err != nil {
+			side_channel.Panic(err)
+		}
+	case "-f", "--follow":
+		if !promptDeleteLogFile(args) {
+			return
+		}
+		err := ui.RunEditor(args.firstFilename, args.secondFilename, ui.Options{MultiMode: true, Follow: true})
+		if err != nil {
+			side_channel.Panic(err)
+		}
+	case "--concat":
+		if !promptDeleteLogFile(args) {
+			return
+		}
+		err := ui.RunEditor(args.firstFilename, args.secondFilename, ui.Options{MultiMode: true, Concat: args.concatFilenames})
 		if err != nil {
 			side_channel.Panic(err)
 		}
@@ -77,7 +99,7 @@ func main() {
 		if !promptDeleteLogFile(args) {
 			return
 		}
-		err := ui.RunEditor(args.firstFilename, args.secondFilename, true)
+		err := ui.RunEditor(args.firstFilename, args.secondFilename, ui.Options{MultiMode: true})
 		if err != nil {
 			side_channel.Panic(err)
 		}
@@ -113,6 +135,10 @@ func promptYesNo(prompt string, defaultOption bool) bool {
 }
 
 func promptDeleteLogFile(args programArgs) bool {
+	if args.firstFilename == "-" {
+		// stdin is the input, the log file is overwritten without prompt
+		return true
+	}
 	if file_util.NonEmpty(args.secondFilename) {
 		ok := promptYesNo(fmt.Sprintf("log_writer file exists (%s), delete it?", args.secondFilename), false)
 		if !ok {
@@ -128,7 +154,17 @@ func promptDeleteLogFile(args programArgs) bool {
 }
 
 func getDefaultLogFilename(inputFilename string) (firstFilename string, secondFilename string) {
-	if file_util.NonEmpty(inputFilename) {
+	if inputFilename == "-" {
+		firstFilename = "-"
+		secondFilename = filepath.Join(config.Load().LOG_DIR, "stdin")
+	} else if buffer.IsHTTPURL(inputFilename) {
+		firstFilename = inputFilename
+		secondFilename = filepath.Join(config.Load().LOG_DIR, "remote", remoteLogName(inputFilename))
+	} else if archive, member, ok := file_util.SplitArchivePath(inputFilename); ok {
+		archive, _ = filepath.Abs(archive)
+		firstFilename = archive + ":" + member
+		secondFilename = filepath.Join(config.Load().LOG_DIR, archive+".members", filepath.Clean("/"+member))
+	} else if file_util.Exists(inputFilename) {
 		firstFilename, _ = filepath.Abs(inputFilename)
 		secondFilename = filepath.Join(config.Load().LOG_DIR, firstFilename)
 	} else {
@@ -144,6 +180,59 @@ func getDefaultLogFilename(inputFilename string) (firstFilename string, secondFi
 	return firstFilename, secondFilename
 }
 
+// pickArchiveMember - if filename is a tar or zip archive, prompt for the member to open
+func pickArchiveMember(filename string) string {
+	if !file_util.NonEmpty(filename) {
+		return filename
+	}
+	reader, err := mmap.Open(filename)
+	if err != nil {
+		return filename
+	}
+	defer reader.Close()
+	if !buffer.IsZip(reader) && !buffer.IsTar(reader) {
+		return filename
+	}
+	members, err := buffer.ListArchive(reader)
+	if err != nil || len(members) == 0 {
+		return filename
+	}
+	for i, member := range members {
+		fmt.Printf("%4d  %s (%d bytes)\n", i+1, member.Name, member.Size)
+	}
+	stdin := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("open member [1-%d], empty to open the archive as is: ", len(members))
+		input, err := stdin.ReadString('\n')
+		if err != nil {
+			return filename
+		}
+		input = strings.TrimSpace(input)
+		if len(input) == 0 {
+			return filename
+		}
+		i, err := strconv.Atoi(input)
+		if err != nil || i < 1 || i > len(members) {
+			fmt.Println("invalid member", input)
+			continue
+		}
+		return filename + ":" + members[i-1].Name
+	}
+}
+
+// remoteLogName - host and path of url, query is dropped
+func remoteLogName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Host) == 0 {
+		return "invalid_url"
+	}
+	path := filepath.Clean("/" + u.Path)
+	if path == "/" {
+		path = "/index"
+	}
+	return filepath.Join(u.Host, path)
+}
+
 func consume(args []string) ([]string, string) {
 	if len(args) == 0 {
 		return []string{}, ""
@@ -160,11 +249,29 @@ func getProgramArgs() programArgs {
 	args := os.Args[1:]
 	pargs := programArgs{}
 
-	if head := peek(args); len(head) > 0 && head[0] == '-' {
+	if head := peek(args); len(head) > 1 && head[0] == '-' { // "-" alone is stdin
 		args, pargs.option = consume(args)
 	}
+	if pargs.option == "--concat" {
+		// all files are input files, the log file is named after the first one
+		args, pargs.firstFilename = consume(args)
+		for len(args) > 0 {
+			var filename string
+			args, filename = consume(args)
+			filename, _ = filepath.Abs(filename)
+			pargs.concatFilenames = append(pargs.concatFilenames, filename)
+		}
+		pargs.firstFilename, pargs.secondFilename = getDefaultLogFilename(pargs.firstFilename)
+		pargs.secondFilename += ".concat"
+		return pargs
+	}
 	args, pargs.firstFilename = consume(args)
 	args, pargs.secondFilename = consume(args)
+	switch pargs.option {
+	case "-l", "--log_writer", "-r", "--replay":
+	default:
+		pargs.firstFilename = pickArchiveMember(pargs.firstFilename)
+	}
 	if len(pargs.secondFilename) == 0 {
 		pargs.firstFilename, pargs.secondFilename = getDefaultLogFilename(pargs.firstFilename)
 	} else {
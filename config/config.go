@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
@@ -14,12 +16,20 @@ const VERSION = "0.1.8c"
 
 const HELP = `
 Usage: "telescope [option] file [logfile]"
+  use "-" as file to read from stdin, e.g. "cmd | telescope -"
+  gzip and bzip2 compressed files are opened read-only, seeking back in a single-member file decompresses it
+  again from the start unless COMPRESSED_SPILL_MB=<n> lets up to n MiB of it be spilled decompressed to disk
+  http and https urls are read with range requests and opened read-only, e.g. "telescope https://host/big.log"
+  tar and zip members are opened read-only with "archive:member", e.g. "telescope bundle.zip:logs/app.log",
+  opening the archive alone lists its members to pick from
 Options:
   -h --help           show help
   -v --version        get version
   -r --replay         replay the edited file 
   -l --log_writer            print the human readable log_writer format
   -i --insert         open with INSERT mode
+  -f --follow         keep loading the file as it grows (tail -f)
+     --concat         open files concatenated into one read-only buffer, "telescope --concat file1 file2 ..."
   -c --command        open with NORMAL/COMMAND/VISUAL/INSERT mode
      --unsafe         open with UNSAFE mode
 
@@ -33,26 +43,114 @@ NORMAL/COMMAND/VISUAL/INSERT mode:
   in NORMAL mode:
     i                 enter INSERT mode
     :                 enter COMMAND mode
-    V                 enter VISUAL mode
-    p                 paste from clipboard
+    / ?               enter COMMAND mode to search forward or backward
+    V                 enter VISUAL mode, select lines
+    v                 enter VISUAL mode, select characters
+    Ctrl+V            enter VISUAL mode, select a block
+    p                 paste from clipboard, lines above the cursor, characters and blocks at the cursor
+    "<reg>            before an operator or p, use register a-z or 0-9, A-Z appends to a-z, e.g. "add "ap
+    u r               undo, redo, an edit after an undo starts a new branch of the undo tree
+    g- g+             go to the previous or the next change in time, whatever its branch
+    .                 repeat the last change at the cursor, an insert session, a deletion or a paste
+    q<reg> ... q      record keys into register a-z or 0-9, macros are kept in the config directory
+    [count]@<reg>     play a macro, @@ plays the last one again, playback stops at the first failing command
+    m<a-z>            set a mark, marks follow inserted and deleted lines and are kept per file
+    '<a-z>            go to the line of a mark, backquote<a-z> goes to its position
+                      marks are also motions, e.g. "d'a", and addresses, e.g. ":'a,'bd"
+    Ctrl+O Tab        go back and forth in the jump list, positions before searches, gotos, G, gg and marks
+    [count]           repeat the motion or command, e.g. "5j", "3dd", "2p"
+    h j k l           move by character or line
+    w W b B e E       move by word or WORD
+    0 ^ $             go to the beginning, the first non-blank or the end of line
+    f F t T <char>    go to the next or previous <char>, ; and , repeat it forward or backward
+    n N               go to the next match of the last search in its direction or in the other direction
+    gg G [count]G     go to the first, the last or the count-th line
+    d c y <motion>    cut, change or copy, e.g. "dw", "c$", "y2j", dd cc yy act on lines
+    d c y i/a <obj>   act on a text object, w W " ' p, e.g. "ciw", "di'", "dap"
+    x D C Y           shorthands for dl d$ c$ yy
   in COMMAND mode:
     ENTER             execute command
-    ESCAPE            delete command buffer and enter NORMAL mode
+    ESCAPE            delete command buffer and enter NORMAL mode, a search in progress is cancelled
+    Up Down           go through the previous commands or searches starting like the command typed
   in INSERT mode:
     ESCAPE            enter NORMAL mode
   in VISUAL mode:
-    up,dn,pgup,pgdn   move cursor and selector
+    arrows,pgup,pgdn  move cursor and selector
+    V v Ctrl+V        change the shape of the selection, the same key again enters NORMAL mode
+    :                 enter COMMAND mode with the range '<,'> of the selected lines
+    motions           move the end of the selection, with counts
     d                 cut into clipboard
     y                 copy into clipboard
     ESCAPE            enter NORMAL mode
+  in HEX mode:
+    arrows,pgup,pgdn  move by byte and by row of 16 bytes
+    0-9 a-f           overwrite the byte under the cursor, high nibble first
+    g G               go to the beginning or the end of file
+    :                 enter COMMAND mode
+    ESCAPE            enter NORMAL mode
+  in GREP mode:
+    j k arrows        select the previous or the next matching line
+    g G pgup,pgdn     select the first or the last line, move by page
+    ENTER             go to the match in the selected line and enter NORMAL mode
+    q                 close the panel
+    :                 enter COMMAND mode
+    ESCAPE            enter NORMAL mode, the panel stays open
 
 Commands:
   :i :insert        enter INSERT mode
-  / :s :search                search
-  :regex         search with regex
-  : :g :goto          goto line
-  :w :write         write into file
+  /pattern[/swi] :s :search
+                    search forward, the cursor goes to the match, matches are highlighted
+                    the match is previewed while typing, ESC goes back to where the search started
+                    s smartcase, w whole word, i ignore case, e.g. "/foo/w"
+  ?pattern[?swi]    search backward, / or ? without pattern repeats the last search
+                    large files are searched in parallel in the background, any key cancels the search
+  :regex <regexp>   search with regex
+  :noh              stop highlighting matches until the next search
+  :grep [pattern[/swi]]
+                    list the matching lines in a panel below the text and enter GREP mode
+                    without pattern, list the matches of the last search, the panel fills up while the file is scanned
+  :copen :cclose    show or hide the panel of the last :grep
+  :filter pattern[/swi]
+                    show only the lines matching pattern, with their line numbers, filters stack
+  :filter! pattern[/swi]
+                    hide the lines matching pattern
+                    j k G gg and arrows move over the lines shown, operators act on every line of the text
+                    lines inserted next to a line shown are shown
+  :unfilter         drop the last filter
+  :nofilter         drop every filter
+  :[range]s/pattern/replacement/[gci]
+                    replace regexp matches, \1 to \9 are groups and & is the whole match
+                    g every match of a line, i ignore case, c confirm with y/n/a/q/l, undone at once
+                    range is %, a line number, ., $, '<,'> (the selection) or two of them, e.g. ":1,$-1s/a/b/g"
+                    large ranges are replaced in the background, ESC cancels
+  :[range]d [x]     cut the lines into the clipboard and register x, e.g. ":10,200d"
+  :[range]y [x]     copy the lines into the clipboard and register x, e.g. ":.,$y a"
+  :[range]norm keys type keys in NORMAL mode at the beginning of every line
+  :[range]g/pattern/cmd
+                    run cmd on every line matching the regexp pattern, the whole file by default
+                    cmd is d [x], y [x] or normal keys, e.g. ":g/DEBUG/d" or ":g/^#/normal ix"
+                    large files are scanned in the background, :g/pattern/d is one undo step and one journal entry
+  :[range]v/pattern/cmd :g!/pattern/cmd
+                    run cmd on every line not matching pattern
+  :[range]!cmd      replace the lines by the output of cmd run with them on its standard input, e.g. ":%!sort"
+                    cmd runs in the background, ESC kills it, the journal keeps the output so replay does not run it
+  :[line]r !cmd     insert the output of cmd below the line, ":r file" inserts a file
+  :!cmd             run cmd in the terminal, the screen comes back after ENTER
+  :earlier [count]  go back count changes in time, or a duration, e.g. ":earlier 5m", "s m h d" units
+  :later [count]    go forward count changes in time, or a duration, e.g. ":later 30s"
+  :undolist :undol  list the last change of the newest branches of the undo tree
+  : :g :goto          goto line, ":10" or ":$", a range goes to its last line
+  :w :write         write into file, without argument, write into the input file
+  :[range]w file    write the lines into file, e.g. ":'<,'>w part.txt"
+                    files named *.gz are written gzip compressed, other files uncompressed
+  :wq :x            write into the input file and quit
   :q :quit          quit
+  :reload           load the input file again after it was modified externally, ":reload!" discards edits
+  :snapshot         keep reading from a private copy of the input file, not once it was rewritten or truncated in place
+  :hex [offset]     enter HEX mode at the cursor or at a byte offset of the input file, e.g. ":hex 0x1f00"
+  :source [n]       go to the beginning of the next or the n-th file with --concat
+  :readonly :ro     disable editing
+  :readwrite :rw    enable editing
 `
 
 const (
@@ -70,12 +168,34 @@ type Config struct {
 	INITIAL_SERIALIZER_VERSION uint64
 	MAXSIZE_HISTORY_STACK      int
 	VIEW_CHANNEL_SIZE          int
+	LOG_CHANNEL_SIZE           int // log entries waiting for the subscribers before edits wait
 	MAX_SEACH_TIME             time.Duration
+	SEARCH_BLOCKING_TIME       time.Duration // searches taking longer continue in the background
 	TAB_SIZE                   int
 	LOG_DIR                    string
 	TMP_DIR                    string
+	CONFIG_DIR                 string // state kept across sessions, e.g. macros
 	SCROLL_SPEED               int
 	LOAD_ESCAPE_INTERVAL       time.Duration
+	INPUT_CHECK_INTERVAL       time.Duration
+	FOLLOW_INTERVAL            time.Duration
+	STREAM_POLL_INTERVAL       time.Duration
+	SPILL_BLOCK_SIZE           int
+	COMPRESSED_BLOCK_SIZE      int
+	COMPRESSED_CACHE_SIZE      int
+	COMPRESSED_SPILL_SIZE      int // decompressed bytes spilled into TMP_DIR for seeking single-member input, 0 disables
+	HTTP_BLOCK_SIZE            int
+	HTTP_CACHE_SIZE            int
+	HTTP_PREFETCH              int
+	HTTP_TIMEOUT               time.Duration // a request is retried after it times out, reads block meanwhile
+	LINE_CHUNK_THRESHOLD       int
+	LINE_CHUNK_SIZE            int
+	BACKGROUND_ROWS            int // ex commands over more rows run in the background
+	SEARCH_BLOCK_ROWS          int // rows searched at once by a search worker
+	SEARCH_WORKERS             int
+	GREP_PANEL_HEIGHT          int // rows of the :grep panel including its title
+	FILE_STATE_MAX_FILES       int // files whose cursor, marks and histories are remembered
+	COMMAND_HISTORY_SIZE       int // commands and searches remembered per file
 }
 
 func (c Config) String() string {
@@ -90,7 +210,12 @@ func loadDefaultConfig() *Config {
 	tempDir := os.TempDir()
 	defaultLogDir := filepath.Join(tempDir, "telescope", "log")
 	defaultTmpDir := filepath.Join(tempDir, "telescope", "tmp")
+	defaultConfigDir := filepath.Join(tempDir, "telescope", "config")
+	if userConfigDir, err := os.UserConfigDir(); err == nil {
+		defaultConfigDir = filepath.Join(userConfigDir, "telescope")
+	}
 	debug := len(os.Getenv("DEBUG")) > 0
+	compressedSpillMB, _ := strconv.Atoi(os.Getenv("COMPRESSED_SPILL_MB"))
 	// TODO - export these into environment variables
 	config := &Config{
 		DEBUG:                      debug,
@@ -102,12 +227,34 @@ func loadDefaultConfig() *Config {
 		INITIAL_SERIALIZER_VERSION: HUMAN_READABLE_SERIALIZER,
 		MAXSIZE_HISTORY_STACK:      1024,
 		VIEW_CHANNEL_SIZE:          64,
+		LOG_CHANNEL_SIZE:           1024,
 		MAX_SEACH_TIME:             5 * time.Second,
+		SEARCH_BLOCKING_TIME:       50 * time.Millisecond,
 		TAB_SIZE:                   2,
 		LOG_DIR:                    defaultLogDir,
 		TMP_DIR:                    defaultTmpDir,
+		CONFIG_DIR:                 defaultConfigDir,
 		SCROLL_SPEED:               3,
 		LOAD_ESCAPE_INTERVAL:       100 * time.Millisecond,
+		INPUT_CHECK_INTERVAL:       2 * time.Second,
+		FOLLOW_INTERVAL:            500 * time.Millisecond,
+		STREAM_POLL_INTERVAL:       10 * time.Millisecond,
+		SPILL_BLOCK_SIZE:           64 * 1024 * 1024,
+		COMPRESSED_BLOCK_SIZE:      1024 * 1024,
+		COMPRESSED_CACHE_SIZE:      64,
+		COMPRESSED_SPILL_SIZE:      max(0, compressedSpillMB) * 1024 * 1024,
+		HTTP_BLOCK_SIZE:            1024 * 1024,
+		HTTP_CACHE_SIZE:            64,
+		HTTP_PREFETCH:              8,
+		HTTP_TIMEOUT:               10 * time.Second,
+		LINE_CHUNK_THRESHOLD:       1024 * 1024,
+		LINE_CHUNK_SIZE:            64 * 1024,
+		BACKGROUND_ROWS:            100000,
+		SEARCH_BLOCK_ROWS:          16384,
+		SEARCH_WORKERS:             runtime.NumCPU(),
+		GREP_PANEL_HEIGHT:          10,
+		FILE_STATE_MAX_FILES:       200,
+		COMMAND_HISTORY_SIZE:       100,
 	}
 	side_channel.WriteLn("config:", config.String())
 	return config
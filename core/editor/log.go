@@ -3,15 +3,22 @@ package editor
 type Command string
 
 const (
-	CommandSetVersion Command = "set_version" // set version of serializer
-	CommandType       Command = "type"
-	CommandEnter      Command = "enter"
-	CommandBackspace  Command = "backspace"
-	CommandDelete     Command = "delete"
-	CommandUndo       Command = "undo"
-	CommandRedo       Command = "redo"
-	CommandInsertLine Command = "insert_line"
-	CommandDeleteLine Command = "delete_line"
+	CommandSetVersion   Command = "set_version" // set version of serializer
+	CommandType         Command = "type"
+	CommandEnter        Command = "enter"
+	CommandBackspace    Command = "backspace"
+	CommandDelete       Command = "delete"
+	CommandUndo         Command = "undo"
+	CommandRedo         Command = "redo"
+	CommandUndoGoto     Command = "undo_goto" // go to change count of the undo tree, see hist.Hist.Goto
+	CommandInsertLine   Command = "insert_line"
+	CommandDeleteLine   Command = "delete_line"
+	CommandSetByte      Command = "set_byte"      // overwrite a byte, col is a byte offset in the line
+	CommandDeleteRange  Command = "delete_range"  // delete from (row, col) to (end_row, end_col), or a block of columns
+	CommandInsertText   Command = "insert_text"   // insert text at (row, col), or a block of columns
+	CommandReplace      Command = "replace"       // replace text[0] by text[1] in rows row to end_row, see insert_editor.Replacement
+	CommandDeleteGlobal Command = "delete_global" // delete rows row to end_row matching text[0], see insert_editor.Global
+	CommandSetLines     Command = "set_lines"     // replace count rows from row by text
 )
 
 type LogEntry struct {
@@ -24,4 +31,9 @@ type LogEntry struct {
 	Count   uint64   `json:"count,omitempty"`
 	Beg     uint64   `json:"beg,omitempty"`
 	End     uint64   `json:"end,omitempty"`
+	Byte    uint8    `json:"byte,omitempty"`
+	EndRow  uint64   `json:"end_row,omitempty"`
+	EndCol  uint64   `json:"end_col,omitempty"`
+	Block   bool     `json:"block,omitempty"`
+	Flags   string   `json:"flags,omitempty"`
 }
@@ -1,7 +1,7 @@
 package insert_editor
 
 import (
-	"slices"
+	"strings"
 	"telescope/core/editor"
 	"telescope/core/util/text"
 
@@ -24,10 +24,8 @@ func (e *Editor) Type(ch rune) {
 				t = t.Ins(0, []rune{ch})
 				return t
 			}
-			// t.Get(row) always well-defined
-			line := slices.Clone(t.Get(row))
-			line = insertToSlice(line, col, ch)
-			t = t.Set(row, line)
+			// only the chunk under the cursor is touched for gigantic lines
+			t = t.InsBytesAt(row, t.ByteCol(row, col), []byte(string(ch)))
 			return t
 		}
 
@@ -57,16 +55,13 @@ func (e *Editor) Backspace() {
 			// first line do nothing
 			case col == 0 && row != 0:
 				// merge 2 lines
-				line1 := t.Get(row - 1)
-				line2 := t.Get(row)
-
-				t = t.Set(row-1, concatSlices(line1, line2)).Del(row)
-				moveRow, moveCol = -1, len(line1) // move up and to the end of last line
+				n := t.LineLen(row - 1)
+				t = t.JoinLine(row - 1)
+				moveRow, moveCol = -1, n // move up and to the end of last line
 			case col != 0:
 				// t.Get(row) always well-defined
-				line := slices.Clone(t.Get(row))
-				line = deleteFromSlice(line, col-1)
-				t = t.Set(row, line)
+				beg, end := t.ByteCol(row, col-1), t.ByteCol(row, col)
+				t = t.DelBytesAt(row, beg, end-beg)
 				moveRow, moveCol = 0, -1 // move left
 			default:
 				side_channel.Panic("unreachable")
@@ -94,18 +89,16 @@ func (e *Editor) Delete() {
 				return t
 			}
 			// t.Get(row) always well-defined
-			line1 := t.Get(row)
+			n := t.LineLen(row)
 			switch {
-			case col == len(line1) && row == t.Len()-1:
+			case col == n && row == t.Len()-1:
 			// last line, do nothing
-			case col == len(line1) && row < t.Len()-1:
+			case col == n && row < t.Len()-1:
 				// merge 2 lines
-				line2 := t.Get(row + 1)
-				t = t.Set(row, concatSlices(line1, line2)).Del(row + 1)
-			case col != len(line1):
-				line := slices.Clone(line1)
-				line = deleteFromSlice(line, col)
-				t = t.Set(row, line)
+				t = t.JoinLine(row)
+			case col != n:
+				beg, end := t.ByteCol(row, col), t.ByteCol(row, col+1)
+				t = t.DelBytesAt(row, beg, end-beg)
 			default:
 				side_channel.Panic("unreachable")
 			}
@@ -132,18 +125,15 @@ func (e *Editor) Enter() {
 				return t
 			}
 			// t.Get(row) always well-defined
-			line := t.Get(row)
+			n := t.LineLen(row)
 			switch {
-			case col == len(line):
+			case col == n:
 				// add new line
 				t = t.Ins(row+1, nil)
 				return t
-			case col < len(line):
+			case col < n:
 				// split a line
-				line1 := slices.Clone(line[:col])
-				line2 := slices.Clone(line[col:])
-				t = t.Set(row, line1)
-				t = t.Ins(row+1, line2)
+				t = t.SplitLine(row, t.ByteCol(row, col))
 				return t
 			default:
 				side_channel.Panic("unreachable")
@@ -162,7 +152,11 @@ func (e *Editor) Undo() {
 		e.writeLogWithoutLock(editor.LogEntry{
 			Command: editor.CommandUndo,
 		})
-		e.text.Undo()
+		if !e.text.Undo() {
+			e.setMessageWithoutLock("already at oldest change")
+			return
+		}
+		e.catchUpWithoutLock()
 		e.moveRelativeAndFixWithoutLock(0, 0)
 		e.setMessageWithoutLock("undo")
 	})
@@ -173,7 +167,11 @@ func (e *Editor) Redo() {
 		e.writeLogWithoutLock(editor.LogEntry{
 			Command: editor.CommandRedo,
 		})
-		e.text.Redo()
+		if !e.text.Redo() {
+			e.setMessageWithoutLock("already at newest change")
+			return
+		}
+		e.catchUpWithoutLock()
 		e.moveRelativeAndFixWithoutLock(0, 0)
 		e.setMessageWithoutLock("redo")
 	})
@@ -197,12 +195,31 @@ func (e *Editor) Apply(entry editor.LogEntry) {
 		e.Undo()
 	case editor.CommandRedo:
 		e.Redo()
+	case editor.CommandUndoGoto:
+		e.UndoGoto(int(entry.Count))
 	case editor.CommandInsertLine:
 		e.Goto(int(entry.Row), 0)
 		e.InsertLine(text.MakeTextFromLine(entry.Text))
 	case editor.CommandDeleteLine:
 		e.Goto(int(entry.Row), 0)
 		e.DeleteLine(int(entry.Count))
+	case editor.CommandSetByte:
+		e.SetByte(int(entry.Row), int(entry.Col), entry.Byte)
+	case editor.CommandDeleteRange:
+		e.DeleteRange(
+			editor.Cursor{Row: int(entry.Row), Col: int(entry.Col)},
+			editor.Cursor{Row: int(entry.EndRow), Col: int(entry.EndCol)},
+			entry.Block,
+		)
+	case editor.CommandInsertText:
+		e.Goto(int(entry.Row), int(entry.Col))
+		e.InsertText(text.MakeTextFromLine(entry.Text), entry.Block)
+	case editor.CommandReplace:
+		e.Replace(replacementFromEntry(entry), nil)
+	case editor.CommandDeleteGlobal:
+		e.DeleteGlobal(globalFromEntry(entry), nil)
+	case editor.CommandSetLines:
+		e.SetLines(int(entry.Row), int(entry.Count), text.MakeTextFromLine(entry.Text))
 	default:
 		side_channel.Panic("command not found")
 	}
@@ -229,6 +246,30 @@ func (e *Editor) InsertLine(t2 text.Text) {
 	})
 }
 
+// SetLines - replace count lines from row by the lines of t2 as a single journal entry and a single undo step
+func (e *Editor) SetLines(row int, count int, t2 text.Text) {
+	e.lockRender(func() {
+		e.writeLogWithoutLock(editor.LogEntry{
+			Command: editor.CommandSetLines,
+			Row:     uint64(row),
+			Count:   uint64(count),
+			Text:    t2.Repr(),
+		})
+		update := func(t text.Text) text.Text {
+			beg := min(row, t.Len())
+			end := min(beg+count, t.Len())
+			return text.Merge(
+				text.Slice(t, 0, beg),
+				t2,
+				text.Slice(t, end, t.Len()),
+			)
+		}
+		e.text.Update(update)
+		e.gotoAndFixWithoutLock(row, 0)
+		e.setMessageWithoutLock("set lines")
+	})
+}
+
 func (e *Editor) DeleteLine(count int) {
 	e.lockRender(func() {
 		row := e.cursor.Row
@@ -247,3 +288,186 @@ func (e *Editor) DeleteLine(count int) {
 		e.setMessageWithoutLock("delete lines")
 	})
 }
+
+// SetByte - overwrite byte col of line row, col is a byte offset and col == len(line) is the line break
+// writing a line break splits the line, overwriting the line break joins the line with the next one
+// col == len(line) of the last line is the end of the file if the text has no final line break
+func (e *Editor) SetByte(row int, col int, b byte) {
+	e.lockRender(func() {
+		t := e.text.Get()
+		// NOTE - handle empty file as a single empty line
+		empty := t.Len() == 0 && row == 0 && col == 0
+		if !empty && (row < 0 || row >= t.Len() || col < 0 || col > t.LineBytes(row)) {
+			e.setMessageWithoutLock("set byte out of range")
+			return
+		}
+		e.writeLogWithoutLock(editor.LogEntry{
+			Command: editor.CommandSetByte,
+			Row:     uint64(row),
+			Col:     uint64(col),
+			Byte:    b,
+		})
+
+		updateText := func(t text.Text) text.Text {
+			if t.Len() == 0 {
+				t = t.InsBytes(0, nil)
+			}
+			n := t.LineBytes(row)
+			switch {
+			case col < n && b == '\n':
+				// split a line
+				t = t.DelBytesAt(row, col, 1).SplitLine(row, col)
+			case col < n:
+				t = t.DelBytesAt(row, col, 1).InsBytesAt(row, col, []byte{b})
+			case row < t.Len()-1 && b == '\n':
+			// line break is unchanged
+			case row < t.Len()-1:
+				// merge 2 lines
+				t = t.InsBytesAt(row, col, []byte{b}).JoinLine(row)
+			case b == '\n':
+				// last line, the final line break is set or added at the end of the file
+				t = t.SetNoFinalNewline(false)
+			default:
+				// last line, the final line break is overwritten or the byte is added at the end of the file
+				t = t.InsBytesAt(row, col, []byte{b}).SetNoFinalNewline(true)
+			}
+			return t
+		}
+		e.text.Update(updateText)
+		e.moveRelativeAndFixWithoutLock(0, 0)
+		e.setMessageWithoutLock("set byte %02x", b)
+	})
+}
+
+// DeleteRange - delete from beg to end, end is exclusive and (row+1, 0) includes the line break of row
+// if block, columns [beg.Col, end.Col) of rows beg.Row to end.Row are deleted
+func (e *Editor) DeleteRange(beg editor.Cursor, end editor.Cursor, block bool) {
+	e.lockRender(func() {
+		e.writeLogWithoutLock(editor.LogEntry{
+			Command: editor.CommandDeleteRange,
+			Row:     uint64(beg.Row),
+			Col:     uint64(beg.Col),
+			EndRow:  uint64(end.Row),
+			EndCol:  uint64(end.Col),
+			Block:   block,
+		})
+		updateText := func(t text.Text) text.Text {
+			// NOTE - handle empty file
+			if t.Len() == 0 {
+				return t
+			}
+			if block {
+				return deleteBlock(t, beg, end)
+			}
+			return deleteRange(t, beg, end)
+		}
+		e.text.Update(updateText)
+		e.gotoAndFixWithoutLock(beg.Row, beg.Col)
+		e.setMessageWithoutLock("delete range")
+	})
+}
+
+// InsertText - insert t2 at the cursor, line breaks of t2 split the line
+// if block, line i of t2 is inserted at the cursor column of row cursor+i, short lines are padded with spaces
+func (e *Editor) InsertText(t2 text.Text, block bool) {
+	e.lockRender(func() {
+		e.writeLogWithoutLock(editor.LogEntry{
+			Command: editor.CommandInsertText,
+			Row:     uint64(e.cursor.Row),
+			Col:     uint64(e.cursor.Col),
+			Text:    t2.Repr(),
+			Block:   block,
+		})
+		row, col := e.cursor.Row, e.cursor.Col
+		updateText := func(t text.Text) text.Text {
+			// NOTE - handle empty file
+			if t.Len() == 0 {
+				t = t.Ins(0, nil)
+			}
+			if block {
+				return insertBlock(t, row, col, t2)
+			}
+			return insertText(t, row, col, t2)
+		}
+		e.text.Update(updateText)
+		e.gotoAndFixWithoutLock(row, col)
+		e.setMessageWithoutLock("insert text")
+	})
+}
+
+// clampPosition - position inside t, col == len(line) is the line break
+func clampPosition(t text.Text, p editor.Cursor) editor.Cursor {
+	p.Row = max(0, min(p.Row, t.Len()-1))
+	p.Col = max(0, min(p.Col, t.LineLen(p.Row)))
+	return p
+}
+
+func deleteRange(t text.Text, beg editor.Cursor, end editor.Cursor) text.Text {
+	if end.Row >= t.Len() {
+		// delete until the end of the last line
+		end = editor.Cursor{Row: t.Len() - 1, Col: t.LineLen(t.Len() - 1)}
+	}
+	beg, end = clampPosition(t, beg), clampPosition(t, end)
+	if end.Row < beg.Row || (end.Row == beg.Row && end.Col <= beg.Col) {
+		return t
+	}
+	if beg.Row == end.Row {
+		b := t.ByteCol(beg.Row, beg.Col)
+		return t.DelBytesAt(beg.Row, b, t.ByteCol(end.Row, end.Col)-b)
+	}
+	t = t.DelBytesAt(end.Row, 0, t.ByteCol(end.Row, end.Col))
+	b := t.ByteCol(beg.Row, beg.Col)
+	t = t.DelBytesAt(beg.Row, b, t.LineBytes(beg.Row)-b)
+	t = text.Merge(
+		text.Slice(t, 0, beg.Row+1),
+		text.Slice(t, end.Row, t.Len()),
+	)
+	return t.JoinLine(beg.Row)
+}
+
+func deleteBlock(t text.Text, beg editor.Cursor, end editor.Cursor) text.Text {
+	for row := max(0, beg.Row); row <= end.Row && row < t.Len(); row++ {
+		n := t.LineLen(row)
+		b, e := t.ByteCol(row, min(beg.Col, n)), t.ByteCol(row, min(end.Col, n))
+		if b < e {
+			t = t.DelBytesAt(row, b, e-b)
+		}
+	}
+	return t
+}
+
+func insertText(t text.Text, row int, col int, t2 text.Text) text.Text {
+	if t2.Len() == 0 {
+		return t
+	}
+	p := clampPosition(t, editor.Cursor{Row: row, Col: col})
+	b := t.ByteCol(p.Row, p.Col)
+	if t2.Len() == 1 {
+		return t.InsBytesAt(p.Row, b, t2.GetBytes(0))
+	}
+	last := t2.Len() - 1
+	t = t.SplitLine(p.Row, b)
+	t = t.InsBytesAt(p.Row, b, t2.GetBytes(0))
+	t = t.InsBytesAt(p.Row+1, 0, t2.GetBytes(last))
+	return text.Merge(
+		text.Slice(t, 0, p.Row+1),
+		text.Slice(t2, 1, last),
+		text.Slice(t, p.Row+1, t.Len()),
+	)
+}
+
+func insertBlock(t text.Text, row int, col int, t2 text.Text) text.Text {
+	row = max(0, min(row, t.Len()-1))
+	for i := 0; i < t2.Len(); i++ {
+		r := row + i
+		if r >= t.Len() {
+			t = t.Ins(t.Len(), nil)
+		}
+		n := t.LineLen(r)
+		if n < col {
+			t = t.InsBytesAt(r, t.LineBytes(r), []byte(strings.Repeat(" ", col-n)))
+		}
+		t = t.InsBytesAt(r, t.ByteCol(r, col), t2.GetBytes(i))
+	}
+	return t
+}
@@ -0,0 +1,102 @@
+package insert_editor
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+)
+
+// Global - lines of rows Row to EndRow matching Pattern, or not matching if Invert, as selected by :g and :v
+type Global struct {
+	Row     int
+	EndRow  int
+	Pattern string
+	Invert  bool
+}
+
+// GlobalRows - rows selected by g in increasing order, progress is called with the number of rows done so far
+func GlobalRows(ctx context.Context, t text.Text, g Global, progress func(done int)) ([]int, error) {
+	re, err := regexp.Compile(g.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	beg, end := max(0, g.Row), min(g.EndRow+1, t.Len())
+	var rows []int
+	next := beg // rows before next are decided
+	err = t.SearchRows(ctx, re, beg, end, false, func(hits []text.Hit, done int) bool {
+		for _, h := range hits {
+			if h.Row < next {
+				continue // another match in the same row
+			}
+			if g.Invert {
+				for ; next < h.Row; next++ {
+					rows = append(rows, next)
+				}
+			} else {
+				rows = append(rows, h.Row)
+			}
+			next = h.Row + 1
+		}
+		if progress != nil {
+			progress(done)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for ; g.Invert && next < end; next++ {
+		rows = append(rows, next)
+	}
+	return rows, nil
+}
+
+// DeleteGlobal - delete the rows computed by GlobalRows as a single journal entry and a single undo step
+// rows are computed here if nil, e.g. when replaying the journal
+func (e *Editor) DeleteGlobal(g Global, rows []int) {
+	e.lockRender(func() {
+		if rows == nil {
+			var err error
+			rows, err = GlobalRows(context.Background(), e.text.Get(), g, nil)
+			if err != nil {
+				e.setMessageWithoutLock("global error %s", err.Error())
+				return
+			}
+		}
+		if len(rows) == 0 {
+			e.setMessageWithoutLock("pattern not found")
+			return
+		}
+		flags := ""
+		if g.Invert {
+			flags = "v"
+		}
+		e.writeLogWithoutLock(editor.LogEntry{
+			Command: editor.CommandDeleteGlobal,
+			Row:     uint64(g.Row),
+			EndRow:  uint64(g.EndRow),
+			Text:    [][]rune{[]rune(g.Pattern)},
+			Flags:   flags,
+		})
+		e.text.Update(func(t text.Text) text.Text {
+			return t.DelLines(rows)
+		})
+		e.gotoAndFixWithoutLock(rows[0], 0)
+		e.setMessageWithoutLock("delete lines")
+	})
+}
+
+// globalFromEntry - inverse of the journal entry written by DeleteGlobal
+func globalFromEntry(entry editor.LogEntry) Global {
+	g := Global{
+		Row:    int(entry.Row),
+		EndRow: int(entry.EndRow),
+		Invert: strings.Contains(entry.Flags, "v"),
+	}
+	if len(entry.Text) == 1 {
+		g.Pattern = string(entry.Text[0])
+	}
+	return g
+}
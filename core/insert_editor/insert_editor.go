@@ -18,6 +18,7 @@ import (
 
 type Editor struct {
 	renderCh chan editor.View
+	logCh    chan func() // log entries are passed to the subscribers in order by a single goroutine
 
 	mu     sync.Mutex // the fields below are protected by mu
 	text   *hist.Hist[text.Text]
@@ -25,14 +26,27 @@ type Editor struct {
 	window editor.Window
 	status editor.Status
 	pool   *subsciber_pool.Pool[func(editor.LogEntry)]
+	record func(editor.LogEntry, text.Text) // called synchronously in order, unlike subscribers
+	batch  int                              // views are not sent while batch > 0
+
+	loadCtx    context.Context // done when loading finishes, in follow mode once the reader as opened is loaded
+	loadExit   context.Context // done when the loading goroutine exits, after following stops
+	cancelLoad func()          // cancel loading
+	follow     bool            // keep loading as the reader grows
+	loaded     text.Text       // lines loaded since every version was last caught up, the current version has them all
+	loadedBase int             // number of lines loaded before loaded, the stamp of a version is the number of lines it has
 }
 
+// loadedFlushLines - the other versions are caught up after this many loaded lines so that loaded stays small
+const loadedFlushLines = 1 << 16
+
 func New(
-	height int, width int,
+	height int, width int, follow bool,
 ) (*Editor, error) {
 	e := &Editor{
 		// buffered iterator is necessary  for preventing deadlock
 		renderCh: make(chan editor.View, config.Load().VIEW_CHANNEL_SIZE),
+		logCh:    make(chan func(), config.Load().LOG_CHANNEL_SIZE),
 
 		mu:   sync.Mutex{},
 		text: nil, // awaiting Load
@@ -50,11 +64,19 @@ func New(
 			Background: "",
 			Other:      nil,
 		},
-		pool: subsciber_pool.New[func(editor.LogEntry)](),
+		pool:   subsciber_pool.New[func(editor.LogEntry)](),
+		follow: follow,
 	}
+	go e.consumeLog()
 	return e, nil
 }
 
+func (e *Editor) consumeLog() {
+	for consume := range e.logCh {
+		consume()
+	}
+}
+
 func (e *Editor) lock(f func()) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -75,11 +97,24 @@ func (e *Editor) setMessageWithoutLock(format string, a ...any) {
 }
 
 func (e *Editor) writeLogWithoutLock(entry editor.LogEntry) {
-	go func() {
+	if e.record != nil {
+		e.record(entry, e.text.Get())
+	}
+	// subscribers must not call the editor, the channel is full only if they fall behind
+	e.logCh <- func() {
 		for _, consume := range e.pool.Iter {
 			consume(entry)
 		}
-	}()
+	}
+}
+
+// SyncLog - wait until the subscribers consumed every entry logged so far
+func (e *Editor) SyncLog() {
+	done := make(chan struct{})
+	e.logCh <- func() {
+		close(done)
+	}
+	<-done
 }
 
 func (e *Editor) Subscribe(consume func(editor.LogEntry)) uint64 {
@@ -88,6 +123,14 @@ func (e *Editor) Subscribe(consume func(editor.LogEntry)) uint64 {
 	return e.pool.Subscribe(consume)
 }
 
+// Record - record is called with every log entry and the text before the edit, nil to stop recording
+// record must not call the editor
+func (e *Editor) Record(record func(entry editor.LogEntry, t text.Text)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record = record
+}
+
 func (e *Editor) Unsubscribe(key uint64) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -111,8 +154,9 @@ func (e *Editor) Status(update func(status editor.Status) editor.Status) {
 	})
 }
 
-func (e *Editor) load(ctx context.Context, reader buffer.Reader, loadDone func()) {
+func (e *Editor) load(ctx context.Context, reader buffer.Reader, loadDone func(), exitDone func()) {
 	t0 := time.Now()
+	defer exitDone()
 	defer loadDone()
 	defer e.lockRender(func() {
 		totalTime := time.Since(t0)
@@ -134,22 +178,179 @@ func (e *Editor) load(ctx context.Context, reader buffer.Reader, loadDone func()
 		return // nothing to load
 	}
 
-	loader := newLoader(reader.Len())
+	indexer := text.NewIndexer()
+	stream, isStream := reader.(buffer.Stream)
+	if !isStream {
+		if !e.index(ctx, reader, indexer, newLoader(reader.Len())) {
+			return
+		}
+		if !e.follow {
+			e.chunkLastLine()
+			return
+		}
+	}
 
+	// stream or follow mode - keep indexing the reader as it grows
+	// in follow mode, the cursor is pinned to the bottom unless it was moved away from the last line
+	var streamDone <-chan struct{} = nil // stream is done, nil channel blocks forever
+	if isStream {
+		streamDone = stream.Done()
+	}
+	if e.follow {
+		e.lockRender(func() {
+			e.finalNewlineWithoutLock(reader)
+			e.gotoAndFixWithoutLock(e.text.Get().Len()-1, 0)
+			e.status.Background = "following"
+			e.setMessageWithoutLock("loaded for %d seconds", int(time.Since(t0).Seconds()))
+		})
+		if !isStream {
+			// the file as opened is loaded, following goes on until loading is cancelled
+			loadDone()
+		}
+	}
+	pollInterval := config.Load().STREAM_POLL_INTERVAL
+	if e.follow {
+		pollInterval = config.Load().FOLLOW_INTERVAL
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		done := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-streamDone:
+			done = true
+		default:
+			if reader.Len() <= indexer.Pos() {
+				// wait for the reader to grow
+				select {
+				case <-ctx.Done():
+					return
+				case <-streamDone:
+					done = true
+				case <-ticker.C:
+				}
+			}
+		}
+		if reader.Len() > indexer.Pos() {
+			pinned := false
+			e.lock(func() {
+				pinned = e.follow && e.cursor.Row >= e.text.Get().Len()-1
+			})
+			if !e.index(ctx, reader, indexer, nil) {
+				return
+			}
+			e.lockRender(func() {
+				// loaded lines are in every version and so is the end of the reader
+				e.finalNewlineWithoutLock(reader)
+				if pinned {
+					e.gotoAndFixWithoutLock(e.text.Get().Len()-1, 0)
+				}
+				if isStream && !e.follow {
+					e.status.Background = fmt.Sprintf("streaming %d", reader.Len())
+				}
+			})
+		}
+		if done {
+			e.chunkLastLine()
+			return
+		}
+	}
+}
+
+// finalNewlineWithoutLock - every version ends with a line break if reader does, it is kept on write
+func (e *Editor) finalNewlineWithoutLock(reader buffer.Reader) {
+	noFinalNewline := reader.Len() > 0 && reader.At(reader.Len()-1) != '\n'
+	e.text.Map(func(t text.Text) text.Text {
+		return t.SetNoFinalNewline(noFinalNewline)
+	})
+}
+
+// chunkLastLine - the last line might be incomplete while indexing, it is chunked once the reader stops growing
+// the chunks are made once outside the lock and shared by every version the line is the last line of
+func (e *Editor) chunkLastLine() {
+	var t text.Text
+	e.lock(func() {
+		t = e.text.Get()
+	})
+	if t.Len() == 0 {
+		return
+	}
+	line, ok := t.ChunkLine(t.Len() - 1)
+	if !ok {
+		return
+	}
+	e.lock(func() {
+		e.text.Map(func(t text.Text) text.Text {
+			return t.SetChunkedLine(line)
+		})
+	})
+}
+
+// appendLoadedWithoutLock - append a loaded line to the current version only, the other versions get it
+// once they become current or when the loaded lines are flushed
+func (e *Editor) appendLoadedWithoutLock(line text.Line) {
+	e.loaded = e.loaded.Append(line)
+	n := e.loadedBase + e.loaded.Len()
+	e.text.Restamp(func(t text.Text, stamp int) (text.Text, int) {
+		return t.Append(line), n
+	})
+	if e.loaded.Len() >= loadedFlushLines {
+		e.flushLoadedWithoutLock()
+	}
+}
+
+// catchUp - append the loaded lines a version with stamp lacks
+func (e *Editor) catchUp(t text.Text, stamp int) (text.Text, int) {
+	n := e.loadedBase + e.loaded.Len()
+	if stamp < n {
+		missing := text.Slice(e.loaded, stamp-e.loadedBase, e.loaded.Len())
+		t = text.Merge(t, missing).SetNoFinalNewline(t.NoFinalNewline())
+	}
+	return t, n
+}
+
+// catchUpWithoutLock - called once another version becomes current
+func (e *Editor) catchUpWithoutLock() {
+	e.text.Restamp(e.catchUp)
+}
+
+// flushLoadedWithoutLock - append the loaded lines to every version lacking them
+func (e *Editor) flushLoadedWithoutLock() {
+	e.text.MapStamped(e.catchUp)
+	e.loadedBase += e.loaded.Len()
+	e.loaded = text.Slice(e.loaded, 0, 0)
+}
+
+// resetLoadedWithoutLock - start over with t as the only version
+func (e *Editor) resetLoadedWithoutLock(t text.Text) {
+	e.text = hist.New(t)
+	e.loaded = text.Slice(t, 0, 0)
+	e.loadedBase = 0
+}
+
+// index - append lines from the last indexed byte to the end of reader, return false if ctx is done
+func (e *Editor) index(ctx context.Context, reader buffer.Reader, indexer *text.Indexer, loader *loader) bool {
+	// loaded lines are in every version, loading is not a change that can be undone
+	defer e.lock(e.flushLoadedWithoutLock)
 	lastPoll := time.Now()
-	for offset := range text.IndexFile(reader) {
+	for offset := range indexer.Index(reader) {
 		now := time.Now()
 		if now.Sub(lastPoll) >= config.Load().LOAD_ESCAPE_INTERVAL {
 			lastPoll = now
 			if !pollCtx(ctx) {
-				return
+				return false
 			}
 		}
+		line := text.MakeLineFromOffset(offset)
+		if end := indexer.Pos(); end < reader.Len() && reader.At(end) == '\n' {
+			// complete line, gigantic lines are chunked
+			line = text.MakeLineFromReader(reader, offset, end)
+		}
 		e.lock(func() {
-			e.text.Update(func(t text.Text) text.Text {
-				return t.Append(text.MakeLineFromOffset(offset))
-			})
-			if loader.set(offset) {
+			e.appendLoadedWithoutLock(line)
+			if loader != nil && loader.set(offset) {
 				e.status.Background = fmt.Sprintf(
 					"loading %d/%d (%d%%)",
 					loader.loadedSize, loader.totalSize, loader.lastRenderPercentage,
@@ -158,27 +359,102 @@ func (e *Editor) load(ctx context.Context, reader buffer.Reader, loadDone func()
 			}
 		})
 	}
-
+	return pollCtx(ctx)
 }
 
-func (e *Editor) Load(ctx context.Context, reader buffer.Reader) (context.Context, error) {
+func (e *Editor) loadWithoutLock(ctx context.Context, reader buffer.Reader) context.Context {
 	loadCtx, loadDone := context.WithCancel(context.Background())
-	var err error = nil
+	loadExit, exitDone := context.WithCancel(context.Background())
+	ctx, cancelLoad := context.WithCancel(ctx)
+	e.loadCtx, e.loadExit, e.cancelLoad = loadCtx, loadExit, cancelLoad
+	e.resetLoadedWithoutLock(text.New(reader))
+	if _, isStream := reader.(buffer.Stream); reader != nil && !isStream && !e.follow {
+		// the end of the file is known already, edits made while loading keep it
+		e.finalNewlineWithoutLock(reader)
+	}
+	// load file asynchronously
+	go e.load(ctx, reader, loadDone, exitDone)
+	e.status.Background = "loading started"
+	return loadCtx
+}
+
+func (e *Editor) Load(ctx context.Context, reader buffer.Reader) (loadCtx context.Context, err error) {
 	e.lockRender(func() {
 		if e.text != nil {
+			var loadDone func()
+			loadCtx, loadDone = context.WithCancel(context.Background())
 			loadDone()
 			err = errors.New("load twice")
 			return
 		}
-		e.text = hist.New(text.New(reader))
-		// load file asynchronously
-		go e.load(ctx, reader, loadDone)
-		e.status.Background = "loading started"
+		loadCtx = e.loadWithoutLock(ctx, reader)
 	})
 
 	return loadCtx, err
 }
 
+// StopLoad - cancel loading or following and wait until it stops, the lines loaded so far are kept
+func (e *Editor) StopLoad() {
+	var loadExit context.Context
+	var cancelLoad func()
+	e.lock(func() {
+		loadExit, cancelLoad = e.loadExit, e.cancelLoad
+	})
+	if cancelLoad != nil {
+		cancelLoad()
+		<-loadExit.Done()
+	}
+}
+
+// Reload - cancel the current loading then load again from reader, history is dropped
+func (e *Editor) Reload(ctx context.Context, reader buffer.Reader) (loadCtx context.Context) {
+	e.StopLoad()
+	e.lockRender(func() {
+		loadCtx = e.loadWithoutLock(ctx, reader)
+		e.moveRelativeAndFixWithoutLock(0, 0)
+		e.setMessageWithoutLock("reload")
+	})
+	return loadCtx
+}
+
+// Rebase - replace the current text by t which has the same content, history is dropped
+// since older versions may refer to a reader that is no longer valid
+func (e *Editor) Rebase(t text.Text) {
+	e.lockRender(func() {
+		e.resetLoadedWithoutLock(t)
+		e.moveRelativeAndFixWithoutLock(0, 0)
+		e.setMessageWithoutLock("rebase")
+	})
+}
+
+// RebaseReader - read every version of the text from another reader with identical content
+func (e *Editor) RebaseReader(reader buffer.Reader) {
+	e.lockRender(func() {
+		e.text.Map(func(t text.Text) text.Text {
+			return t.Rebase(reader)
+		})
+		e.loaded = e.loaded.Rebase(reader)
+		e.setMessageWithoutLock("rebase")
+	})
+}
+
+// Preview - change the current text without a journal entry or an undo step, e.g. matches replaced while
+// :s///c asks for confirmation, the number of lines must not change
+func (e *Editor) Preview(update func(t text.Text) text.Text) {
+	e.lockRender(func() {
+		e.text.Restamp(func(t text.Text, stamp int) (text.Text, int) {
+			return update(t), stamp
+		})
+	})
+}
+
 func (e *Editor) Action(key string, vals ...any) {
-	// nothing
+	switch key {
+	case "input_modified":
+		e.lockRender(func() {
+			e.setMessageWithoutLock("%v", vals[0])
+		})
+	default:
+		// nothing
+	}
 }
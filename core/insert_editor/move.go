@@ -20,9 +20,8 @@ func (e *Editor) gotoAndFixWithoutLock(row int, col int) {
 			if curCol < 0 {
 				curCol = 0
 			}
-			line := t.Get(curRow)
-			if curCol > len(line) {
-				curCol = len(line) // col can be 1 character outside of text
+			if n := t.LineLen(curRow); curCol > n {
+				curCol = n // col can be 1 character outside of text
 			}
 		}
 		// fix window according to cursor
@@ -99,8 +98,7 @@ func (e *Editor) MoveEnd() {
 	e.lockRender(func() {
 		t := e.text.Get()
 		if e.cursor.Row < t.Len() {
-			line := t.Get(e.cursor.Row)
-			e.moveRelativeAndFixWithoutLock(0, len(line)-e.cursor.Col)
+			e.moveRelativeAndFixWithoutLock(0, t.LineLen(e.cursor.Row)-e.cursor.Col)
 		}
 		e.setMessageWithoutLock("move end")
 	})
@@ -118,6 +116,15 @@ func (e *Editor) MovePageDown() {
 	})
 }
 
+// Scroll - put the top left corner of the window at (tlRow, tlCol), the window moves back if the cursor is
+// left outside
+func (e *Editor) Scroll(tlRow int, tlCol int) {
+	e.lockRender(func() {
+		e.window.TlRow, e.window.TlCol = max(0, tlRow), max(0, tlCol)
+		e.moveRelativeAndFixWithoutLock(0, 0)
+	})
+}
+
 func (e *Editor) Goto(row int, col int) {
 	e.lockRender(func() {
 		e.gotoAndFixWithoutLock(row, col)
@@ -5,6 +5,9 @@ import (
 )
 
 func (e *Editor) renderWithoutLock() {
+	if e.batch > 0 {
+		return
+	}
 	e.renderCh <- e.makeView()
 }
 
@@ -30,6 +33,17 @@ func (e *Editor) Render() (view editor.View) {
 	return view
 }
 
+// Batch - no view is sent while f runs, a single view is sent at the end
+func (e *Editor) Batch(f func()) {
+	e.lock(func() {
+		e.batch++
+	})
+	defer e.lockRender(func() {
+		e.batch--
+	})
+	f()
+}
+
 func (e *Editor) Update() <-chan editor.View {
 	return e.renderCh
 }
@@ -0,0 +1,200 @@
+package insert_editor
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+)
+
+// Replacement - :s/Pattern/Replacement/ over rows Row to EndRow, matches on the first row start at rune
+// column Col or after, at most Count matches are replaced if Count > 0
+// Replacement refers to groups as \1 to \9 and to the whole match as &
+type Replacement struct {
+	Row         int
+	EndRow      int
+	Col         int
+	Count       int
+	Pattern     string
+	Replacement string
+	Global      bool // every match of a line, otherwise only the first one
+	IgnoreCase  bool
+}
+
+// ReplacedLine - new content of a row
+type ReplacedLine struct {
+	Row  int
+	Line []byte
+}
+
+// Compile - regexp of the pattern and the replacement as a regexp template
+func (r Replacement) Compile() (*regexp.Regexp, string, error) {
+	pattern := r.Pattern
+	if r.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	return re, replacementTemplate(r.Replacement), nil
+}
+
+// replacementTemplate - vim replacement to regexp template, \1 is ${1}, & is ${0}, \& and \\ are literal
+func replacementTemplate(s string) string {
+	var b strings.Builder
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		switch ch := rs[i]; {
+		case ch == '\\' && i+1 < len(rs):
+			i++
+			switch next := rs[i]; {
+			case '0' <= next && next <= '9':
+				b.WriteString("${" + string(next) + "}")
+			case next == 't':
+				b.WriteString("\t")
+			case next == '$':
+				b.WriteString("$$")
+			default:
+				b.WriteRune(next)
+			}
+		case ch == '&':
+			b.WriteString("${0}")
+		case ch == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteRune(ch)
+		}
+	}
+	return b.String()
+}
+
+// replaceLine - replace matches starting at byte offset from or after, at most limit matches if limit > 0
+// the number of replaced matches is returned, line is not modified
+func replaceLine(line []byte, re *regexp.Regexp, template string, from int, global bool, limit int) ([]byte, int) {
+	var out []byte
+	n, last := 0, 0
+	for _, m := range re.FindAllSubmatchIndex(line, -1) {
+		if m[0] < from {
+			continue
+		}
+		out = append(out, line[last:m[0]]...)
+		out = re.Expand(out, []byte(template), line, m)
+		last = m[1]
+		n++
+		if !global || (limit > 0 && n >= limit) {
+			break
+		}
+	}
+	if n == 0 {
+		return line, 0
+	}
+	return append(out, line[last:]...), n
+}
+
+// ReplaceLines - new content of the rows changed by r, progress is called with the number of rows done so far
+func ReplaceLines(ctx context.Context, t text.Text, r Replacement, progress func(done int)) ([]ReplacedLine, int, error) {
+	re, template, err := r.Compile()
+	if err != nil {
+		return nil, 0, err
+	}
+	var lines []ReplacedLine
+	matches := 0
+	beg, end := max(0, r.Row), min(r.EndRow+1, t.Len())
+	for row := beg; row < end; row++ {
+		if (row-beg)%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, 0, err
+			}
+			if progress != nil {
+				progress(row - beg)
+			}
+		}
+		from := 0
+		if row == r.Row {
+			from = t.ByteCol(row, r.Col)
+		}
+		limit := 0
+		if r.Count > 0 {
+			limit = r.Count - matches
+		}
+		line, n := replaceLine(t.GetBytes(row), re, template, from, r.Global, limit)
+		if n == 0 {
+			continue
+		}
+		lines = append(lines, ReplacedLine{Row: row, Line: line})
+		matches += n
+		if r.Count > 0 && matches >= r.Count {
+			break
+		}
+	}
+	return lines, matches, nil
+}
+
+// Replace - write lines computed by ReplaceLines as a single journal entry and a single undo step
+// lines are computed here if nil, e.g. when replaying the journal
+func (e *Editor) Replace(r Replacement, lines []ReplacedLine) {
+	e.lockRender(func() {
+		if lines == nil {
+			var err error
+			lines, _, err = ReplaceLines(context.Background(), e.text.Get(), r, nil)
+			if err != nil {
+				e.setMessageWithoutLock("replace error %s", err.Error())
+				return
+			}
+		}
+		if len(lines) == 0 {
+			// nothing is journaled, the text is unchanged
+			e.setMessageWithoutLock("pattern not found")
+			return
+		}
+		flags := ""
+		if r.Global {
+			flags += "g"
+		}
+		if r.IgnoreCase {
+			flags += "i"
+		}
+		e.writeLogWithoutLock(editor.LogEntry{
+			Command: editor.CommandReplace,
+			Row:     uint64(r.Row),
+			EndRow:  uint64(r.EndRow),
+			Col:     uint64(r.Col),
+			Count:   uint64(r.Count),
+			Text:    [][]rune{[]rune(r.Pattern), []rune(r.Replacement)},
+			Flags:   flags,
+		})
+		e.text.Update(func(t text.Text) text.Text {
+			return setReplacedLines(t, lines)
+		})
+		e.gotoAndFixWithoutLock(lines[len(lines)-1].Row, 0)
+		e.setMessageWithoutLock("replace")
+	})
+}
+
+func setReplacedLines(t text.Text, lines []ReplacedLine) text.Text {
+	rows, vals := make([]int, 0, len(lines)), make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		if line.Row < t.Len() {
+			rows, vals = append(rows, line.Row), append(vals, line.Line)
+		}
+	}
+	return t.SetLines(rows, vals)
+}
+
+// replacementFromEntry - inverse of the journal entry written by Replace
+func replacementFromEntry(entry editor.LogEntry) Replacement {
+	r := Replacement{
+		Row:        int(entry.Row),
+		EndRow:     int(entry.EndRow),
+		Col:        int(entry.Col),
+		Count:      int(entry.Count),
+		Global:     strings.Contains(entry.Flags, "g"),
+		IgnoreCase: strings.Contains(entry.Flags, "i"),
+	}
+	if len(entry.Text) == 2 {
+		r.Pattern, r.Replacement = string(entry.Text[0]), string(entry.Text[1])
+	}
+	return r
+}
@@ -0,0 +1,41 @@
+package insert_editor
+
+import (
+	"telescope/core/editor"
+	"telescope/core/util/hist"
+)
+
+// UndoGoto - go to change seq in any branch of the undo tree, the change is journaled by number so that
+// replaying builds the same tree and goes to the same change whatever the clock
+func (e *Editor) UndoGoto(seq int) {
+	e.lockRender(func() {
+		if !e.text.Has(seq) {
+			e.setMessageWithoutLock("change %d not found", seq)
+			return
+		}
+		e.writeLogWithoutLock(editor.LogEntry{
+			Command: editor.CommandUndoGoto,
+			Count:   uint64(seq),
+		})
+		e.text.Goto(seq)
+		e.catchUpWithoutLock()
+		e.moveRelativeAndFixWithoutLock(0, 0)
+		e.setMessageWithoutLock("change %d", seq)
+	})
+}
+
+// UndoTree - every change of the undo tree in the order they were made and the number of the current change
+func (e *Editor) UndoTree() (changes []hist.Change, seq int) {
+	e.lock(func() {
+		changes, seq = e.text.Changes(), e.text.Seq()
+	})
+	return changes, seq
+}
+
+// Modified - the text was edited since it was loaded or written back, undoing every edit makes it unmodified
+func (e *Editor) Modified() (modified bool) {
+	e.lock(func() {
+		modified = e.text != nil && e.text.Seq() != 0
+	})
+	return modified
+}
@@ -5,40 +5,8 @@ import (
 	"iter"
 	"telescope/config"
 	"time"
-
-	"telescope/util/side_channel"
 )
 
-func insertToSlice[T any](l []T, i int, v T) []T {
-	if i < 0 || i > len(l) {
-		side_channel.Panic("invalid index", i, l)
-		return nil
-	}
-	if i == len(l) {
-		return append(l, v)
-	}
-	l = append(l, v)
-	copy(l[i+1:], l[i:])
-	l[i] = v
-	return l
-}
-
-func deleteFromSlice[T any](l []T, i int) []T {
-	if i < 0 || i >= len(l) {
-		side_channel.Panic("invalid index", i, l)
-		return nil
-	}
-	copy(l[i:], l[i+1:])
-	return l[:len(l)-1]
-}
-func concatSlices[T any](ls ...[]T) []T {
-	c := make([]T, 0)
-	for _, l := range ls {
-		c = append(c, l...)
-	}
-	return c
-}
-
 func toIndexedIterator[T any](i iter.Seq[T]) iter.Seq2[int, T] {
 	return func(yield func(int, T) bool) {
 		index := 0
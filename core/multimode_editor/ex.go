@@ -0,0 +1,335 @@
+package multimode_editor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"telescope/config"
+	"telescope/core/editor"
+	"telescope/core/insert_editor"
+	"telescope/core/util/text"
+	"telescope/util/file_util"
+	"unicode"
+	"unicode/utf8"
+)
+
+// exRegister - optional register given as the argument of :d and :y
+func exRegister(args []string) (rune, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	reg, n := utf8.DecodeRuneInString(args[0])
+	if len(args) > 1 || n != len(args[0]) || !validRegister(unicode.ToLower(reg)) {
+		return 0, fmt.Errorf("invalid register %s", strings.Join(args, " "))
+	}
+	return reg, nil
+}
+
+// exLinesWithoutLock - rows of the range and the register of :d and :y
+func (c *Editor) exLinesWithoutLock(ex exCommand) (beg int, end int, reg rune, err error) {
+	beg, end, err = c.parseRangeWithoutLock(ex.rng)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if c.e.Render().Text.Len() == 0 {
+		return 0, 0, 0, fmt.Errorf("empty text")
+	}
+	reg, err = exRegister(ex.fields())
+	return beg, end, reg, err
+}
+
+// exDeleteWithoutLock - :[range]d [x], the lines go into the clipboard and register x
+func (c *Editor) exDeleteWithoutLock(ex exCommand) {
+	c.enterNormalModeWithoutLock()
+	beg, end, reg, err := c.exLinesWithoutLock(ex)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	if !c.editableWithoutLock() {
+		return
+	}
+	t := c.e.Render().Text
+	c.state.clipboard = clipboard{kind: SelectLine, text: text.Slice(t, beg, end+1)}
+	c.setRegisterWithoutLock(reg, c.state.clipboard)
+	c.e.Goto(beg, 0)
+	c.e.DeleteLine(end - beg + 1)
+	c.writeWithoutLock(fmt.Sprintf("cut %d lines", end-beg+1))
+}
+
+// exYankWithoutLock - :[range]y [x], the lines go into the clipboard and register x
+func (c *Editor) exYankWithoutLock(ex exCommand) {
+	c.enterNormalModeWithoutLock()
+	beg, end, reg, err := c.exLinesWithoutLock(ex)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	t := c.e.Render().Text
+	c.state.clipboard = clipboard{kind: SelectLine, text: text.Slice(t, beg, end+1)}
+	c.setRegisterWithoutLock(reg, c.state.clipboard)
+	c.writeWithoutLock(fmt.Sprintf("copied %d lines", end-beg+1))
+}
+
+// exWriteRangeWithoutLock - :[range]w file, the input file cannot be overwritten by a part of itself
+func (c *Editor) exWriteRangeWithoutLock(ex exCommand) {
+	c.enterNormalModeWithoutLock()
+	beg, end, err := c.parseRangeWithoutLock(ex.rng)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	args := ex.fields()
+	if len(args) != 1 {
+		c.failWithoutLock("write a range into one file")
+		return
+	}
+	filename := args[0]
+	if absFilename, _ := filepath.Abs(filename); absFilename == c.defaultOutputFile {
+		c.failWithoutLock("cannot write a range into the input file")
+		return
+	}
+	t := c.e.Render().Text
+	part := text.Slice(t, beg, min(end+1, t.Len()))
+	if err := file_util.SafeWriteFileWith(filename, part.IterBytes, c.writeOptionsWithoutLock(filename, part)); err != nil {
+		c.failWithoutLock("error write file " + err.Error())
+		return
+	}
+	c.writeWithoutLock(fmt.Sprintf("%d lines written into %s", part.Len(), filename))
+}
+
+// exNormalWithoutLock - :[range]norm keys
+func (c *Editor) exNormalWithoutLock(ex exCommand) {
+	c.enterNormalModeWithoutLock()
+	beg, end, err := c.parseRangeWithoutLock(ex.rng)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	rows := make([]int, 0, end-beg+1)
+	for row := beg; row <= end; row++ {
+		rows = append(rows, row)
+	}
+	c.normalRowsWithoutLock(rows, ex.text())
+}
+
+// pendingRows - increasing rows left to visit by :normal, they follow the edits, off is added to rows[next:]
+type pendingRows struct {
+	rows []int
+	next int
+	off  int
+}
+
+func (p *pendingRows) pop() (int, bool) {
+	if p.next == len(p.rows) {
+		return 0, false
+	}
+	row := p.rows[p.next] + p.off
+	p.next++
+	return row, true
+}
+
+// shift - edits before the next row are a single offset, others move the rows one by one
+func (p *pendingRows) shift(s rowShift) {
+	if p.next == len(p.rows) {
+		return
+	}
+	if first := p.rows[p.next] + p.off; s.row < first && (s.delta > 0 || s.row-s.delta < first) {
+		p.off += s.delta
+		return
+	}
+	rows := make([]int, 0, len(p.rows)-p.next)
+	for _, row := range p.rows[p.next:] {
+		if row, ok := s.apply(row + p.off); ok {
+			rows = append(rows, row)
+		}
+	}
+	p.rows, p.next, p.off = rows, 0, 0
+}
+
+// remapPendingWithoutLock - called for every edit with the text before the edit, rows of removed lines are
+// skipped, the rows left are dropped after an edit that cannot be followed
+func (c *Editor) remapPendingWithoutLock(entry editor.LogEntry, t text.Text) {
+	p := c.state.visit
+	if p == nil {
+		return
+	}
+	switch entry.Command {
+	case editor.CommandUndo, editor.CommandRedo, editor.CommandUndoGoto, editor.CommandDeleteGlobal:
+		p.next = len(p.rows)
+		return
+	}
+	if s, _, ok := lineShift(entry, t); ok {
+		p.shift(s)
+	}
+}
+
+// normalRowsWithoutLock - type keys in normal mode at the beginning of every row, as a single view update
+func (c *Editor) normalRowsWithoutLock(rows []int, keys string) {
+	if len(keys) == 0 {
+		c.failWithoutLock("empty keys")
+		return
+	}
+	if c.state.visit != nil {
+		c.failWithoutLock("recursive :normal")
+		return
+	}
+	c.state.visit = &pendingRows{rows: rows}
+	c.state.playing = append(c.state.playing, ':')
+	defer func() {
+		c.state.visit = nil
+		c.state.playing = c.state.playing[:len(c.state.playing)-1]
+	}()
+	done, failed := 0, 0
+	run := func() {
+		for {
+			row, ok := c.state.visit.pop()
+			if !ok || row >= c.e.Render().Text.Len() {
+				return
+			}
+			c.enterNormalModeWithoutLock()
+			c.e.Goto(row, 0)
+			c.state.failed = false
+			for _, r := range keys {
+				c.keyWithoutLock(macroKey{Name: "type", Rune: r})
+				if c.state.failed {
+					break
+				}
+			}
+			if c.state.failed {
+				failed++
+			}
+			c.keyWithoutLock(macroKey{Name: "key_escape"})
+			done++
+		}
+	}
+	if len(c.state.playing) == 1 {
+		c.e.Batch(run)
+	} else {
+		run()
+	}
+	c.enterNormalModeWithoutLock()
+	if failed > 0 {
+		c.failWithoutLock(fmt.Sprintf("normal on %d lines, %d failed", done, failed))
+		return
+	}
+	c.writeWithoutLock(fmt.Sprintf("normal on %d lines", done))
+}
+
+// parseGlobal - "/pattern/command", the delimiter is any character but a letter, a digit or a blank, it is
+// escaped in the pattern as \/
+func parseGlobal(s string) (pattern string, cmd string, ok bool) {
+	delim, n := utf8.DecodeRuneInString(s)
+	if n == 0 || unicode.IsLetter(delim) || unicode.IsDigit(delim) || unicode.IsSpace(delim) || delim == '\\' {
+		return "", "", false
+	}
+	var b strings.Builder
+	rs := []rune(s[n:])
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case rs[i] == '\\' && i+1 < len(rs) && rs[i+1] == delim:
+			b.WriteRune(delim)
+			i++
+		case rs[i] == delim:
+			return b.String(), string(rs[i+1:]), b.Len() > 0
+		default:
+			b.WriteRune(rs[i])
+		}
+	}
+	return b.String(), "", b.Len() > 0
+}
+
+// globalWithoutLock - :[range]g/pattern/cmd or :[range]v/pattern/cmd, cmd is d [x], y [x] or normal keys
+// run on every line matching the regexp, or not matching for :v, the whole text by default
+// the lines are found in the background if there are many, d is a single journal entry
+func (c *Editor) globalWithoutLock(ex exCommand, invert bool) {
+	c.enterNormalModeWithoutLock()
+	spec := ex.rng
+	if len(spec) == 0 {
+		spec = "%"
+	}
+	beg, end, err := c.parseRangeWithoutLock(spec)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	pattern, s, ok := parseGlobal(ex.arg)
+	if !ok {
+		c.failWithoutLock("invalid global " + ex.arg)
+		return
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		c.failWithoutLock(fmt.Sprintf("regexp compile error %s", err.Error()))
+		return
+	}
+	g := insert_editor.Global{Row: beg, EndRow: end, Pattern: pattern, Invert: invert}
+	cmd, sub := parseCommand(":" + strings.TrimLeft(s, " "))
+	if len(sub.rng) > 0 {
+		c.failWithoutLock("no range allowed in global command " + s)
+		return
+	}
+	var apply func(rows []int)
+	switch cmd {
+	case commandDelete:
+		reg, err := exRegister(sub.fields())
+		if err != nil {
+			c.failWithoutLock(err.Error())
+			return
+		}
+		if !c.editableWithoutLock() {
+			return
+		}
+		apply = func(rows []int) {
+			t := c.e.Render().Text
+			c.state.clipboard = clipboard{kind: SelectLine, text: t.PickLines(rows)}
+			c.setRegisterWithoutLock(reg, c.state.clipboard)
+			c.e.DeleteGlobal(g, rows)
+			c.writeWithoutLock(fmt.Sprintf("cut %d lines", len(rows)))
+		}
+	case commandYank:
+		reg, err := exRegister(sub.fields())
+		if err != nil {
+			c.failWithoutLock(err.Error())
+			return
+		}
+		apply = func(rows []int) {
+			t := c.e.Render().Text
+			c.state.clipboard = clipboard{kind: SelectLine, text: t.PickLines(rows)}
+			c.setRegisterWithoutLock(reg, c.state.clipboard)
+			c.writeWithoutLock(fmt.Sprintf("copied %d lines", len(rows)))
+		}
+	case commandNormal:
+		keys := sub.text()
+		apply = func(rows []int) {
+			c.normalRowsWithoutLock(rows, keys)
+		}
+	default:
+		c.failWithoutLock("unsupported global command " + s)
+		return
+	}
+	found := func(rows []int) {
+		if len(rows) == 0 {
+			c.failWithoutLock("pattern not found " + pattern)
+			return
+		}
+		apply(rows)
+	}
+
+	t := c.e.Render().Text
+	if end-beg < config.Load().BACKGROUND_ROWS || len(c.state.playing) > 0 {
+		rows, _ := insert_editor.GlobalRows(context.Background(), t, g, nil)
+		found(rows)
+		return
+	}
+	c.runTaskWithoutLock("global", end-beg+1, func(ctx context.Context, progress func(done int)) func() {
+		rows, err := insert_editor.GlobalRows(ctx, t, g, progress)
+		if err != nil {
+			return nil
+		}
+		return func() {
+			found(rows)
+		}
+	})
+}
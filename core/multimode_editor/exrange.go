@@ -0,0 +1,99 @@
+package multimode_editor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseRangeWithoutLock - first and last rows of "%" or of one or two addresses separated by ","
+// an address is a line number, ".", "$", "'<", "'>" or a mark "'a" optionally followed by +n or -n, the current line
+// is used if spec is empty
+func (c *Editor) parseRangeWithoutLock(spec string) (beg int, end int, err error) {
+	view := c.e.Render()
+	if spec == "%" {
+		return 0, max(view.Text.Len()-1, 0), nil
+	}
+	if len(spec) == 0 {
+		return view.Cursor.Row, view.Cursor.Row, nil
+	}
+	parts := strings.Split(spec, ",")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("invalid range %s", spec)
+	}
+	rows := make([]int, len(parts))
+	for i, part := range parts {
+		rows[i], err = c.parseAddressWithoutLock(part)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	beg, end = rows[0], rows[len(rows)-1]
+	if beg > end {
+		beg, end = end, beg
+	}
+	if beg < 0 || end >= max(view.Text.Len(), 1) {
+		return 0, 0, fmt.Errorf("range out of file %s", spec)
+	}
+	return beg, end, nil
+}
+
+func (c *Editor) parseAddressWithoutLock(s string) (int, error) {
+	view := c.e.Render()
+	row := view.Cursor.Row
+	switch {
+	case strings.HasPrefix(s, "."):
+		s = s[1:]
+	case strings.HasPrefix(s, "$"):
+		row, s = view.Text.Len()-1, s[1:]
+	case strings.HasPrefix(s, "'<"), strings.HasPrefix(s, "'>"):
+		if c.state.lastSelection == nil {
+			return 0, errors.New("no selection")
+		}
+		beg, end := c.state.lastSelection.Interval()
+		row = beg
+		if s[1] == '>' {
+			row = end
+		}
+		s = s[2:]
+	case strings.HasPrefix(s, "'"):
+		name, n := utf8.DecodeRuneInString(s[1:])
+		pos, ok := c.markWithoutLock(name)
+		if !ok {
+			return 0, fmt.Errorf("mark %s not set", s[:1+n])
+		}
+		row, s = pos.Row, s[1+n:]
+	default:
+		i := 0
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+		if i > 0 {
+			n, _ := strconv.Atoi(s[:i])
+			row, s = n-1, s[i:]
+		}
+	}
+	// offsets
+	for len(s) > 0 {
+		sign := 1
+		switch s[0] {
+		case '+':
+		case '-':
+			sign = -1
+		default:
+			return 0, fmt.Errorf("invalid address %s", s)
+		}
+		i := 1
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+		n := 1
+		if i > 1 {
+			n, _ = strconv.Atoi(s[1:i])
+		}
+		row, s = row+sign*n, s[i:]
+	}
+	return row, nil
+}
@@ -0,0 +1,164 @@
+package multimode_editor
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"telescope/config"
+	"telescope/core/editor"
+	"telescope/util/file_util"
+	"telescope/util/persistent/ordered_map"
+	"telescope/util/side_channel"
+	"time"
+)
+
+// fileState - what is remembered of a file between sessions, positions only apply to the same content
+type fileState struct {
+	Fingerprint string                   `json:"fingerprint"`
+	Cursor      editor.Cursor            `json:"cursor"`
+	Window      editor.Cursor            `json:"window"` // top left corner of the window
+	Marks       map[string]editor.Cursor `json:"marks,omitempty"`
+	Searches    []string                 `json:"searches,omitempty"`
+	Commands    []string                 `json:"commands,omitempty"`
+	Saved       time.Time                `json:"saved"` // the files saved first are forgotten first
+}
+
+// fingerprintBytes - bytes hashed at both ends of a file
+const fingerprintBytes = 4096
+
+// fingerprint - size, modification time and a hash of both ends of the file, empty if it cannot be read
+func fingerprint(filename string) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	h := fnv.New64a()
+	_, _ = io.Copy(h, io.NewSectionReader(f, 0, fingerprintBytes))
+	_, _ = io.Copy(h, io.NewSectionReader(f, max(0, info.Size()-fingerprintBytes), fingerprintBytes))
+	return fmt.Sprintf("%d-%d-%x", info.Size(), info.ModTime().UnixNano(), h.Sum64())
+}
+
+func fileStatesFilename() string {
+	return filepath.Join(config.Load().CONFIG_DIR, "files.json")
+}
+
+// loadFileStates - state of every file saved by previous sessions, by absolute path
+func loadFileStates() map[string]fileState {
+	states := make(map[string]fileState)
+	b, err := os.ReadFile(fileStatesFilename())
+	if err != nil {
+		return states
+	}
+	if err := json.Unmarshal(b, &states); err != nil {
+		side_channel.WriteLn("error load file states ", err)
+	}
+	return states
+}
+
+// loadFileState - state saved for filename, positions are dropped if the file changed since
+func loadFileState(filename string) fileState {
+	st, ok := loadFileStates()[filename]
+	if !ok {
+		return fileState{}
+	}
+	if fp := fingerprint(filename); len(fp) == 0 || fp != st.Fingerprint {
+		st.Cursor, st.Window, st.Marks = editor.Cursor{}, editor.Cursor{}, nil
+	}
+	return st
+}
+
+// restoreStateWithoutLock - marks and histories of the last session, the cursor is restored once the file is
+// loaded
+func (c *Editor) restoreStateWithoutLock(st fileState) {
+	c.state.searches, c.state.commands = st.Searches, st.Commands
+	c.state.marks, c.state.lineRefs = ordered_map.EmptyComparableMap[mark](), nil
+	for name, pos := range st.Marks {
+		if rs := []rune(name); len(rs) == 1 && validMark(rs[0]) {
+			c.state.marks = c.state.marks.Set(mark{name: rs[0], pos: pos})
+		}
+	}
+	if st.Cursor != (editor.Cursor{}) {
+		c.state.restore = &st
+	}
+}
+
+// restoreCursorWithoutLock - go to the cursor of the last session unless the cursor was moved meanwhile
+func (c *Editor) restoreCursorWithoutLock() {
+	st := c.state.restore
+	c.state.restore = nil
+	if st == nil || c.e.Render().Cursor != (editor.Cursor{}) {
+		return
+	}
+	c.e.Goto(st.Cursor.Row, st.Cursor.Col)
+	c.e.Scroll(st.Window.Row, st.Window.Col)
+	c.refreshFilterWithoutLock(0)
+	c.writeWithoutLock(fmt.Sprintf("restored line %d", c.e.Render().Cursor.Row+1))
+}
+
+// SaveState - remember the state of the input file, called on every exit
+func (c *Editor) SaveState() {
+	c.lock(func() {
+		c.saveStateWithoutLock()
+	})
+}
+
+// saveStateWithoutLock - remember the state of the input file, states of other files are read again so that
+// concurrent sessions do not overwrite each other, nothing is saved for input that is not a file
+// positions are saved only if the text is the content of the file, the histories are saved anyway
+func (c *Editor) saveStateWithoutLock() {
+	fp := fingerprint(c.defaultOutputFile)
+	if len(fp) == 0 {
+		return
+	}
+	st := fileState{
+		Searches: c.state.searches,
+		Commands: c.state.commands,
+		Saved:    time.Now(),
+	}
+	if !c.e.Modified() {
+		c.resolveMarksWithoutLock(c.e.Render().Text)
+		view := c.e.Render()
+		st.Fingerprint = fp
+		st.Cursor = view.Cursor
+		st.Window = editor.Cursor{Row: view.Window.TlRow, Col: view.Window.TlCol}
+		st.Marks = make(map[string]editor.Cursor)
+		for m := range c.state.marks.Iter {
+			st.Marks[string(m.name)] = m.pos
+		}
+	}
+	states := loadFileStates()
+	states[c.defaultOutputFile] = st
+	if n := len(states) - config.Load().FILE_STATE_MAX_FILES; n > 0 {
+		names := make([]string, 0, len(states))
+		for name := range states {
+			names = append(names, name)
+		}
+		slices.SortFunc(names, func(a string, b string) int {
+			return states[a].Saved.Compare(states[b].Saved)
+		})
+		for _, name := range names[:n] {
+			delete(states, name)
+		}
+	}
+	b, err := json.Marshal(states)
+	if err == nil {
+		err = os.MkdirAll(config.Load().CONFIG_DIR, 0o700)
+	}
+	if err == nil {
+		err = file_util.SafeWriteFile(fileStatesFilename(), func(f func(i int, val []byte) bool) {
+			f(0, b)
+		})
+	}
+	if err != nil {
+		side_channel.WriteLn("error save file state ", err)
+	}
+}
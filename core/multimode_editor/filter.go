@@ -0,0 +1,286 @@
+package multimode_editor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"telescope/config"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+)
+
+// FilterView - rows of the text shown from the top of the window while lines are filtered
+type FilterView struct {
+	Patterns []string // one per stacked filter, excluded patterns start with !
+	Rows     []int    // text rows shown from the top of the window
+	Count    int      // number of lines shown
+	Cursor   int      // screen row of the cursor, -1 if the cursor is on a hidden line
+	Gutter   int      // width of the line numbers
+}
+
+// filter - lines of the filter below matching re, or not matching if invert
+type filter struct {
+	pattern string
+	re      *regexp.Regexp
+	invert  bool
+	rows    rowSet // lines of the filter below matching re, they are the lines hidden if invert
+}
+
+func (f *filter) name() string {
+	if f.invert {
+		return "!" + f.pattern
+	}
+	return f.pattern
+}
+
+// shownRows - rows shown by filters stacked over a text of n lines, the row sets are copied so that the rows
+// can be read in the background while the filters follow the edits
+func shownRows(filters []*filter, n int) rowList {
+	var rows rowList = allRows{n: n}
+	for _, f := range filters {
+		rows = stackRows(rows, f.rows, f.invert)
+	}
+	return rows
+}
+
+// stackRows - rows shown by a filter over parent with the rows matched, invert hides them
+func stackRows(parent rowList, matched rowSet, invert bool) rowList {
+	if invert {
+		return &exceptRows{parent: parent, excluded: matched}
+	}
+	return &matched
+}
+
+// filterRows - rows of parent matching re, only the lines of parent are searched
+func filterRows(ctx context.Context, t text.Text, re *regexp.Regexp, parent rowList, progress func(done int)) ([]int, error) {
+	var rows []int // rows of the lines searched, nil if they are every line of t
+	n := t.Len()
+	if _, all := parent.(allRows); !all {
+		rows = parent.slice()
+		t = t.PickLines(rows)
+	}
+	var hits []int
+	err := t.SearchRows(ctx, re, 0, t.Len(), false, func(hs []text.Hit, done int) bool {
+		for _, h := range hs {
+			row := h.Row
+			if rows != nil {
+				row = rows[row]
+			}
+			if len(hits) == 0 || hits[len(hits)-1] != row {
+				hits = append(hits, row)
+			}
+		}
+		if progress != nil {
+			progress(done * n / max(t.Len(), 1))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+// filterTaskWithoutLock - compute the rows matched by filters one after the other from parent, apply is called
+// with them, large texts are filtered in the background
+func (c *Editor) filterTaskWithoutLock(filters []*filter, parent rowList, apply func(rows []rowSet)) {
+	t := c.e.Render().Text
+	run := func(ctx context.Context, progress func(done int)) ([]rowSet, error) {
+		var rows []rowSet
+		for i, f := range filters {
+			level := func(done int) {
+				progress((i*t.Len() + done) / len(filters))
+			}
+			if progress == nil {
+				level = nil
+			}
+			matched, err := filterRows(ctx, t, f.re, parent, level)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, makeRowSet(matched))
+			parent = stackRows(parent, rows[i], f.invert)
+		}
+		return rows, nil
+	}
+	if t.Len() < config.Load().BACKGROUND_ROWS || len(c.state.playing) > 0 {
+		rows, _ := run(context.Background(), nil)
+		apply(rows)
+		return
+	}
+	c.runTaskWithoutLock("filter", t.Len(), func(ctx context.Context, progress func(done int)) func() {
+		rows, err := run(ctx, progress)
+		if err != nil {
+			return nil
+		}
+		return func() {
+			apply(rows)
+		}
+	})
+}
+
+// startFilterWithoutLock - :filter pattern or :filter! pattern, the filter applies to the lines shown
+func (c *Editor) startFilterWithoutLock(arg string, invert bool) {
+	c.enterNormalModeWithoutLock()
+	pattern, flags := splitSearch(arg, '/')
+	if len(pattern) == 0 {
+		c.failWithoutLock("empty pattern")
+		return
+	}
+	re, err := compileSearch(pattern, false, flags)
+	if err != nil {
+		c.failWithoutLock(fmt.Sprintf("regexp compile error %s", err.Error()))
+		return
+	}
+	f := &filter{pattern: pattern, re: re, invert: invert}
+	parent := c.shownRowsWithoutLock()
+	c.filterTaskWithoutLock([]*filter{f}, parent, func(rows []rowSet) {
+		f.rows = rows[0]
+		c.state.filters = append(c.state.filters, f)
+		c.resizeWithoutLock()
+		c.refreshFilterWithoutLock(0)
+		c.writeWithoutLock(fmt.Sprintf("filter %s: %d lines", f.name(), c.shownRowsWithoutLock().len()))
+	})
+}
+
+// unfilterWithoutLock - drop the last filter or every filter
+func (c *Editor) unfilterWithoutLock(all bool) {
+	c.enterNormalModeWithoutLock()
+	n := len(c.state.filters)
+	if n == 0 {
+		c.failWithoutLock("no filter")
+		return
+	}
+	c.state.filters = c.state.filters[:n-1]
+	if all {
+		c.state.filters = nil
+	}
+	c.state.filterStale = false
+	c.resizeWithoutLock()
+	c.refreshFilterWithoutLock(0)
+	c.writeWithoutLock("")
+}
+
+// remapFilterWithoutLock - called for every edit with the text before the edit, lines inserted or joined
+// next to a line shown are shown
+func (c *Editor) remapFilterWithoutLock(entry editor.LogEntry, t text.Text) {
+	if len(c.state.filters) == 0 {
+		return
+	}
+	switch entry.Command {
+	case editor.CommandUndo, editor.CommandRedo, editor.CommandUndoGoto, editor.CommandDeleteGlobal:
+		c.state.filterStale = true
+		return
+	}
+	s, join, ok := lineShift(entry, t)
+	if !ok {
+		return
+	}
+	for _, f := range c.state.filters {
+		// lines hidden by an inverted filter are never added, inserted lines are shown
+		f.rows.shift(s, join && !f.invert, !f.invert)
+		f.rows.compact()
+	}
+}
+
+// refilterWithoutLock - filter again after lines changed in an unknown way
+func (c *Editor) refilterWithoutLock() {
+	if !c.state.filterStale || c.state.task != nil {
+		return
+	}
+	c.state.filterStale = false
+	filters := c.state.filters
+	c.filterTaskWithoutLock(filters, allRows{n: c.e.Render().Text.Len()}, func(rows []rowSet) {
+		for i, f := range filters {
+			f.rows = rows[i]
+		}
+		c.refreshFilterWithoutLock(0)
+		c.writeWithoutLock("")
+	})
+}
+
+// filterGutterWithoutLock - width of the line numbers, 0 without filter
+func (c *Editor) filterGutterWithoutLock() int {
+	if len(c.state.filters) == 0 {
+		return 0
+	}
+	return len(fmt.Sprint(c.e.Render().Text.Len())) + 1
+}
+
+// shownRowsWithoutLock - rows shown by the filters, every row without filter
+func (c *Editor) shownRowsWithoutLock() rowList {
+	return shownRows(c.state.filters, c.e.Render().Text.Len())
+}
+
+// filterStepWithoutLock - row n lines shown away from row, false if there is none
+func (c *Editor) filterStepWithoutLock(row int, n int) (int, bool) {
+	rows := c.shownRowsWithoutLock()
+	if rows.len() == 0 {
+		return row, false
+	}
+	i := rows.index(row)
+	if n > 0 && (i == rows.len() || rows.get(i) != row) {
+		n-- // row is hidden, the line shown at i is the next one
+	}
+	target := rows.get(max(0, min(rows.len()-1, i+n)))
+	return target, target != row
+}
+
+// filterMoveWithoutLock - move the cursor by n lines shown
+func (c *Editor) filterMoveWithoutLock(n int) {
+	cur := c.e.Render().Cursor
+	if row, ok := c.filterStepWithoutLock(cur.Row, n); ok {
+		c.e.Goto(row, cur.Col)
+	}
+}
+
+// refreshFilterWithoutLock - move the cursor off hidden lines, backward if it moved up from prevRow, and
+// scroll the filtered view to the cursor
+func (c *Editor) refreshFilterWithoutLock(prevRow int) {
+	if n := len(c.state.filters); n > 0 {
+		rows := c.shownRowsWithoutLock()
+		cur := c.e.Render().Cursor
+		if i := rows.index(cur.Row); rows.len() > 0 && (i == rows.len() || rows.get(i) != cur.Row) {
+			if (cur.Row < prevRow && i > 0) || i == rows.len() {
+				i--
+			}
+			c.e.Goto(rows.get(i), 0)
+		}
+	}
+	v := c.filterViewWithoutLock()
+	c.e.Status(func(status editor.Status) editor.Status {
+		if status.Other == nil {
+			status.Other = make(map[string]any)
+		}
+		if v != nil {
+			status.Other["filter"] = v
+		} else {
+			delete(status.Other, "filter")
+		}
+		return status
+	})
+}
+
+// filterViewWithoutLock - rows shown in the window, the window scrolls to keep the cursor visible
+func (c *Editor) filterViewWithoutLock() *FilterView {
+	if len(c.state.filters) == 0 {
+		return nil
+	}
+	rows := c.shownRowsWithoutLock()
+	view := c.e.Render()
+	height := view.Window.Height
+	v := &FilterView{Count: rows.len(), Cursor: -1, Gutter: c.filterGutterWithoutLock()}
+	for _, f := range c.state.filters {
+		v.Patterns = append(v.Patterns, f.name())
+	}
+	top := max(0, min(c.state.filterTop, rows.len()-height))
+	if i := rows.index(view.Cursor.Row); i < rows.len() && rows.get(i) == view.Cursor.Row {
+		top = max(min(top, i), i-height+1)
+		v.Cursor = i - top
+	}
+	c.state.filterTop = top
+	for j := top; j < rows.len() && j < top+height; j++ {
+		v.Rows = append(v.Rows, rows.get(j))
+	}
+	return v
+}
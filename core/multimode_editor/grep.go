@@ -0,0 +1,267 @@
+package multimode_editor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"telescope/config"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+	"time"
+)
+
+// GrepPanel - results of :grep shown below the text, Rows are the rows of the visible hits from Top
+type GrepPanel struct {
+	Pattern  string
+	Count    int
+	Progress string // empty once the scan is done
+	Top      int
+	Selected int
+	Rows     []int
+	Height   int // rows of the panel including its title
+}
+
+// grep - rows of the lines matching :grep
+type grep struct {
+	pattern  string
+	re       *regexp.Regexp
+	hits     rowSet
+	scanned  int // rows scanned so far
+	total    int
+	cancel   func() // cancel the scan, nil once the scan is done
+	stale    bool   // lines changed in an unknown way, e.g. undo, they are scanned again
+	open     bool   // the panel is shown
+	top      int
+	selected int
+}
+
+// remapGrepWithoutLock - called for every edit with the text before the edit
+func (c *Editor) remapGrepWithoutLock(entry editor.LogEntry, t text.Text) {
+	g := c.state.grep
+	if g == nil {
+		return
+	}
+	switch entry.Command {
+	case editor.CommandUndo, editor.CommandRedo, editor.CommandUndoGoto, editor.CommandDeleteGlobal:
+		g.stale = true
+		return
+	}
+	s, join, ok := lineShift(entry, t)
+	if !ok {
+		return
+	}
+	// rows still to be scanned are not added before the scan reaches them
+	g.hits.shift(s, join && g.cancel == nil, false)
+	if g.cancel == nil {
+		g.hits.compact()
+	}
+	g.selected = max(0, min(g.selected, g.hits.len()-1))
+}
+
+// startGrepWithoutLock - :grep pattern, the last search if pattern is empty
+func (c *Editor) startGrepWithoutLock(arg string) {
+	c.enterNormalModeWithoutLock()
+	g := &grep{open: true}
+	pattern, flags := splitSearch(arg, '/')
+	if len(pattern) == 0 {
+		if c.state.search == nil {
+			c.failWithoutLock("empty pattern")
+			return
+		}
+		g.pattern, g.re = c.state.search.pattern, c.state.search.re
+	} else {
+		re, err := compileSearch(pattern, false, flags)
+		if err != nil {
+			c.failWithoutLock(fmt.Sprintf("regexp compile error %s", err.Error()))
+			return
+		}
+		g.pattern, g.re = pattern, re
+	}
+	c.stopGrepWithoutLock()
+	c.state.grep = g
+	c.state.mode = ModeGrep
+	c.resizeWithoutLock()
+	c.scanGrepWithoutLock()
+	c.writeWithoutLock("grep " + g.pattern)
+}
+
+// scanGrepWithoutLock - collect the matching rows in the background, the panel is updated as rows come in
+func (c *Editor) scanGrepWithoutLock() {
+	g := c.state.grep
+	if g.cancel != nil {
+		g.cancel()
+	}
+	t := c.e.Render().Text
+	ctx, cancel := context.WithCancel(context.Background())
+	g.hits, g.scanned, g.total, g.cancel, g.stale = rowSet{}, 0, t.Len(), cancel, false
+	g.top, g.selected = 0, 0
+	go func() {
+		defer cancel()
+		last := time.Now()
+		err := t.SearchRows(ctx, g.re, 0, t.Len(), false, func(hits []text.Hit, done int) bool {
+			var rows []int
+			for _, h := range hits {
+				if len(rows) == 0 || rows[len(rows)-1] != h.Row {
+					rows = append(rows, h.Row)
+				}
+			}
+			ok := true
+			c.lock(func() {
+				if ctx.Err() != nil || c.state.grep != g {
+					ok = false
+					return
+				}
+				g.hits.append(rows)
+				g.scanned = done
+				if time.Since(last) >= config.Load().LOADING_PROGRESS_INTERVAL {
+					last = time.Now()
+					c.writeWithoutLock(c.e.Render().Status.Message)
+				}
+			})
+			return ok
+		})
+		c.lock(func() {
+			if err != nil || ctx.Err() != nil || c.state.grep != g {
+				return
+			}
+			g.cancel = nil
+			c.writeWithoutLock(fmt.Sprintf("grep %s: %d lines", g.pattern, g.hits.len()))
+		})
+	}()
+}
+
+// closeGrepWithoutLock - hide the panel, the results are kept for :copen
+func (c *Editor) closeGrepWithoutLock() {
+	if c.state.grep != nil {
+		c.state.grep.open = false
+		c.resizeWithoutLock()
+	}
+}
+
+// stopGrepWithoutLock - cancel the scan and drop the results
+func (c *Editor) stopGrepWithoutLock() {
+	g := c.state.grep
+	if g == nil {
+		return
+	}
+	if g.cancel != nil {
+		g.cancel()
+	}
+	c.state.grep = nil
+	c.resizeWithoutLock()
+}
+
+// refreshGrepWithoutLock - scan again after lines changed in an unknown way
+func (c *Editor) refreshGrepWithoutLock() {
+	if c.state.grep != nil && c.state.grep.stale {
+		c.scanGrepWithoutLock()
+	}
+}
+
+// grepHeightWithoutLock - rows of the panel, 0 if it is closed
+func (c *Editor) grepHeightWithoutLock() int {
+	if c.state.grep == nil || !c.state.grep.open {
+		return 0
+	}
+	return min(config.Load().GREP_PANEL_HEIGHT, c.state.height/2)
+}
+
+// resizeWithoutLock - the text takes the screen but the grep panel and the line numbers of filtered lines
+func (c *Editor) resizeWithoutLock() {
+	c.e.Resize(c.state.height-c.grepHeightWithoutLock(), c.state.width-c.filterGutterWithoutLock())
+}
+
+func (c *Editor) grepPanelWithoutLock() *GrepPanel {
+	g := c.state.grep
+	height := c.grepHeightWithoutLock()
+	if height == 0 {
+		return nil
+	}
+	p := &GrepPanel{
+		Pattern:  g.pattern,
+		Count:    g.hits.len(),
+		Top:      g.top,
+		Selected: g.selected,
+		Height:   height,
+	}
+	if g.cancel != nil {
+		p.Progress = fmt.Sprintf("%d%%", 100*g.scanned/max(g.total, 1))
+	}
+	for i := g.top; i < g.hits.len() && i < g.top+height-1; i++ {
+		p.Rows = append(p.Rows, g.hits.get(i))
+	}
+	return p
+}
+
+// grepMoveWithoutLock - move the selection by n hits, the panel scrolls to keep it visible
+func (c *Editor) grepMoveWithoutLock(n int) {
+	g := c.state.grep
+	g.selected = max(0, min(g.selected+n, g.hits.len()-1))
+	visible := max(c.grepHeightWithoutLock()-1, 1)
+	if g.selected < g.top {
+		g.top = g.selected
+	}
+	if g.selected >= g.top+visible {
+		g.top = g.selected - visible + 1
+	}
+	c.writeWithoutLock("")
+}
+
+// grepJumpWithoutLock - go to the first match in the line of the selected hit
+func (c *Editor) grepJumpWithoutLock() {
+	g := c.state.grep
+	if g.hits.len() == 0 {
+		c.failWithoutLock("no match")
+		return
+	}
+	t := c.e.Render().Text
+	row := g.hits.get(g.selected)
+	if row >= t.Len() {
+		c.failWithoutLock("no match")
+		return
+	}
+	col := 0
+	if m := g.re.FindIndex(t.GetBytes(row)); m != nil {
+		col = t.RuneCol(row, m[0])
+	}
+	c.enterNormalModeWithoutLock()
+	c.pushJumpWithoutLock()
+	c.e.Goto(row, col)
+	c.writeWithoutLock(fmt.Sprintf("match %d of %d", g.selected+1, g.hits.len()))
+}
+
+// grepTypeWithoutLock - j k g G move the selection, q closes the panel
+func (c *Editor) grepTypeWithoutLock(ch rune) {
+	switch ch {
+	case 'j':
+		c.grepMoveWithoutLock(1)
+	case 'k':
+		c.grepMoveWithoutLock(-1)
+	case 'g':
+		c.grepMoveWithoutLock(-c.state.grep.hits.len())
+	case 'G':
+		c.grepMoveWithoutLock(c.state.grep.hits.len())
+	case 'q':
+		c.closeGrepWithoutLock()
+		c.enterNormalModeWithoutLock()
+		c.writeWithoutLock("")
+	case ':':
+		c.enterCommandModeWithoutLock(":")
+		c.writeWithoutLock("")
+	default:
+		c.writeWithoutLock("j k to select, ENTER to jump, q to close")
+	}
+}
+
+// openGrepWithoutLock - :copen, focus the panel of the last :grep
+func (c *Editor) openGrepWithoutLock() {
+	c.enterNormalModeWithoutLock()
+	if c.state.grep == nil {
+		c.failWithoutLock("no grep results")
+		return
+	}
+	c.state.grep.open = true
+	c.state.mode = ModeGrep
+	c.resizeWithoutLock()
+	c.grepMoveWithoutLock(0)
+}
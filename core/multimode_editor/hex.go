@@ -0,0 +1,248 @@
+package multimode_editor
+
+import (
+	"fmt"
+	"telescope/core/util/text"
+)
+
+// HexWidth - number of bytes in a hex row
+const HexWidth = 16
+
+// HexView - cursor and window of hex mode
+// every line is shown from a new hex row followed by its line break, col is a byte offset in the line
+// and col == len(line) is the line break, or the end of the file after a last line without line break
+type HexView struct {
+	Row     int
+	Col     int
+	TopRow  int // first line in the window
+	TopSub  int // first hex row of TopRow in the window
+	Pending int // high nibble typed, -1 if none
+}
+
+func hexLineLen(t text.Text, row int) int {
+	if row < 0 || row >= t.Len() {
+		return 0
+	}
+	return t.LineBytes(row)
+}
+
+// hexBack - move n hex rows backward from (row, sub)
+func hexBack(t text.Text, row int, sub int, n int) (int, int) {
+	for ; n > 0; n-- {
+		if sub > 0 {
+			sub--
+		} else if row > 0 {
+			row--
+			sub = hexLineLen(t, row) / HexWidth
+		} else {
+			break
+		}
+	}
+	return row, sub
+}
+
+func (c *Editor) enterHexModeWithoutLock(row int, col int) {
+	c.state.mode = ModeHex
+	c.state.command = ""
+	c.state.selector = nil
+	c.state.hex = HexView{
+		Row: row, Col: col,
+		TopRow: row, TopSub: col / HexWidth,
+		Pending: -1,
+	}
+	c.hexGotoWithoutLock(row, col)
+}
+
+// enterHexModeAtCursorWithoutLock - hex mode at the byte under the text cursor
+func (c *Editor) enterHexModeAtCursorWithoutLock() {
+	view := c.e.Render()
+	row, col := view.Cursor.Row, 0
+	if row < view.Text.Len() {
+		col = view.Text.ByteCol(row, view.Cursor.Col)
+	}
+	c.enterHexModeWithoutLock(row, col)
+}
+
+// enterHexModeAtOffsetWithoutLock - hex mode at offset of the input file, lines in memory are skipped
+func (c *Editor) enterHexModeAtOffsetWithoutLock(offset int64) {
+	t := c.e.Render().Text
+	row := t.Search(offset+1) - 1
+	for row >= 0 && t.Offset(row) < 0 {
+		row--
+	}
+	if row < 0 {
+		c.enterHexModeWithoutLock(0, 0)
+		return
+	}
+	c.enterHexModeWithoutLock(row, int(offset-t.Offset(row)))
+}
+
+// hexGotoWithoutLock - move the hex cursor, the text cursor follows it
+func (c *Editor) hexGotoWithoutLock(row int, col int) {
+	t := c.e.Render().Text
+	row = max(0, min(row, t.Len()-1))
+	col = max(0, min(col, hexLineLen(t, row)))
+	c.state.hex.Row, c.state.hex.Col, c.state.hex.Pending = row, col, -1
+	c.hexFixWindowWithoutLock(t)
+	if row < t.Len() {
+		c.e.Goto(row, t.RuneCol(row, col))
+	}
+}
+
+// hexFixWindowWithoutLock - scroll the window so that the cursor is visible
+func (c *Editor) hexFixWindowWithoutLock(t text.Text) {
+	h := &c.state.hex
+	height := max(1, c.e.Render().Window.Height)
+	row, sub := h.Row, h.Col/HexWidth
+	if row < h.TopRow || (row == h.TopRow && sub < h.TopSub) {
+		h.TopRow, h.TopSub = row, sub
+		return
+	}
+	// count hex rows from the top of the window to the cursor
+	topRow, topSub := h.TopRow, h.TopSub
+	for n := 0; n < height; n++ {
+		if topRow == row && topSub == sub {
+			return
+		}
+		if topSub < hexLineLen(t, topRow)/HexWidth {
+			topSub++
+		} else {
+			topRow, topSub = topRow+1, 0
+		}
+	}
+	h.TopRow, h.TopSub = hexBack(t, row, sub, height-1)
+}
+
+const (
+	hexLeft = iota
+	hexRight
+	hexUp
+	hexDown
+	hexHome
+	hexEnd
+	hexPageUp
+	hexPageDown
+)
+
+func (c *Editor) hexMoveWithoutLock(move int) {
+	t := c.e.Render().Text
+	h := c.state.hex
+	row, col := h.Row, h.Col
+	up := func() {
+		if col >= HexWidth {
+			col -= HexWidth
+		} else if row > 0 {
+			row--
+			n := hexLineLen(t, row)
+			col = min(n/HexWidth*HexWidth+col, n)
+		}
+	}
+	down := func() {
+		n := hexLineLen(t, row)
+		if col/HexWidth < n/HexWidth {
+			col = min(col+HexWidth, n)
+		} else if row < t.Len()-1 {
+			row++
+			col = min(col%HexWidth, hexLineLen(t, row))
+		}
+	}
+	height := max(1, c.e.Render().Window.Height)
+	switch move {
+	case hexLeft:
+		if col > 0 {
+			col--
+		} else if row > 0 {
+			row--
+			col = hexLineLen(t, row)
+		}
+	case hexRight:
+		if col < hexLineLen(t, row) {
+			col++
+		} else if row < t.Len()-1 {
+			row, col = row+1, 0
+		}
+	case hexUp:
+		up()
+	case hexDown:
+		down()
+	case hexHome:
+		col -= col % HexWidth
+	case hexEnd:
+		col = min(col-col%HexWidth+HexWidth-1, hexLineLen(t, row))
+	case hexPageUp:
+		for i := 0; i < height; i++ {
+			up()
+		}
+	case hexPageDown:
+		for i := 0; i < height; i++ {
+			down()
+		}
+	}
+	c.hexGotoWithoutLock(row, col)
+	c.writeWithoutLock(c.hexOffsetMessageWithoutLock())
+}
+
+// hexOffsetMessageWithoutLock - offset of the cursor in the input file
+func (c *Editor) hexOffsetMessageWithoutLock() string {
+	t := c.e.Render().Text
+	h := c.state.hex
+	if h.Row >= t.Len() || t.Offset(h.Row) < 0 {
+		return "modified line"
+	}
+	offset := t.Offset(h.Row) + int64(h.Col)
+	return fmt.Sprintf("offset %d (0x%x)", offset, offset)
+}
+
+// hexTypeWithoutLock - hex digits overwrite the byte under the cursor, high nibble first
+func (c *Editor) hexTypeWithoutLock(ch rune) {
+	switch ch {
+	case ':', '/':
+		c.enterCommandModeWithoutLock(string(ch))
+		c.writeWithoutLock("")
+		return
+	case 'g':
+		c.hexGotoWithoutLock(0, 0)
+		c.writeWithoutLock(c.hexOffsetMessageWithoutLock())
+		return
+	case 'G':
+		row := c.e.Render().Text.Len() - 1
+		c.hexGotoWithoutLock(row, 0)
+		c.writeWithoutLock(c.hexOffsetMessageWithoutLock())
+		return
+	}
+	var nibble int
+	switch {
+	case '0' <= ch && ch <= '9':
+		nibble = int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		nibble = int(ch-'a') + 10
+	case 'A' <= ch && ch <= 'F':
+		nibble = int(ch-'A') + 10
+	default:
+		c.writeWithoutLock(fmt.Sprintf("not a hex digit '%c'", ch))
+		return
+	}
+	if !c.editableWithoutLock() {
+		return
+	}
+	h := &c.state.hex
+	if h.Pending < 0 {
+		h.Pending = nibble
+		c.writeWithoutLock(fmt.Sprintf("%x_", nibble))
+		return
+	}
+	b := byte(h.Pending<<4 | nibble)
+	row, col := h.Row, h.Col
+	n := hexLineLen(c.e.Render().Text, row)
+	c.e.SetByte(row, col, b)
+	if b == '\n' && col < n {
+		row, col = row+1, 0 // the line was split
+	} else {
+		col++
+	}
+	if col > hexLineLen(c.e.Render().Text, row) && row < c.e.Render().Text.Len()-1 {
+		row, col = row+1, 0
+	}
+	c.hexGotoWithoutLock(row, col)
+	c.writeWithoutLock(fmt.Sprintf("set byte %02x", b))
+}
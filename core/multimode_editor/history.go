@@ -0,0 +1,73 @@
+package multimode_editor
+
+import (
+	"slices"
+	"strings"
+	"telescope/config"
+)
+
+// historyBrowse - Up and Down in COMMAND mode, prefix is the command typed before browsing
+type historyBrowse struct {
+	prefix string
+	index  int // index of the command shown, the length of the history for the command typed
+}
+
+// historyWithoutLock - ex commands and searches have separate histories
+func (c *Editor) historyWithoutLock(kind byte) *[]string {
+	if kind == '/' || kind == '?' {
+		return &c.state.searches
+	}
+	return &c.state.commands
+}
+
+// addHistoryWithoutLock - remember a command applied in COMMAND mode, an older identical command is dropped
+func (c *Editor) addHistoryWithoutLock(line string) {
+	if len(line) <= 1 || len(c.state.playing) > 0 {
+		return
+	}
+	h := c.historyWithoutLock(line[0])
+	list := slices.DeleteFunc(slices.Clone(*h), func(s string) bool {
+		return s == line
+	})
+	list = append(list, line)
+	if n := len(list) - config.Load().COMMAND_HISTORY_SIZE; n > 0 {
+		list = list[n:]
+	}
+	*h = list
+}
+
+// browseHistoryWithoutLock - Up (n = -1) and Down (n = 1) go through the commands starting like the command
+// typed, a search keeps the direction typed
+func (c *Editor) browseHistoryWithoutLock(n int) {
+	if c.state.browse == nil {
+		if len(c.state.command) == 0 {
+			return
+		}
+		c.state.browse = &historyBrowse{
+			prefix: c.state.command,
+			index:  len(*c.historyWithoutLock(c.state.command[0])),
+		}
+	}
+	b := c.state.browse
+	list := *c.historyWithoutLock(b.prefix[0])
+	i := b.index
+	for {
+		i += n
+		if i < 0 {
+			c.failWithoutLock("no older command")
+			return
+		}
+		if i >= len(list) {
+			b.index = len(list)
+			c.state.command = b.prefix
+			break
+		}
+		if strings.HasPrefix(list[i][1:], b.prefix[1:]) {
+			b.index = i
+			c.state.command = b.prefix[:1] + list[i][1:]
+			break
+		}
+	}
+	c.updateIncsearchWithoutLock()
+	c.writeWithoutLock("")
+}
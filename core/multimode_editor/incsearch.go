@@ -0,0 +1,78 @@
+package multimode_editor
+
+import (
+	"context"
+	"regexp"
+	"telescope/config"
+	"telescope/core/editor"
+)
+
+// incsearch - search as you type after / or ?, the cursor previews the match and goes back to origin on ESC
+type incsearch struct {
+	origin  editor.Cursor
+	command string         // command being previewed
+	re      *regexp.Regexp // matches are highlighted while typing
+	found   *editor.Cursor // match of command, nil while searching or if not found
+	message string
+	cancel  func()
+}
+
+// updateIncsearchWithoutLock - called whenever the command changes, the previous search is cancelled
+func (c *Editor) updateIncsearchWithoutLock() {
+	cmd := c.state.command
+	if len(c.state.playing) > 0 {
+		return // macros search when the command is entered
+	}
+	if c.state.mode != ModeCommand || len(cmd) == 0 || (cmd[0] != '/' && cmd[0] != '?') {
+		return
+	}
+	if c.state.incsearch == nil {
+		c.state.incsearch = &incsearch{origin: c.e.Render().Cursor}
+	}
+	inc := c.state.incsearch
+	if inc.cancel != nil {
+		inc.cancel()
+	}
+	inc.command, inc.re, inc.found, inc.cancel = cmd, nil, nil, nil
+
+	delim, backward := rune(cmd[0]), cmd[0] == '?'
+	pattern, flags := splitSearch(cmd[1:], delim)
+	re, err := compileSearch(pattern, false, flags)
+	if len(pattern) == 0 || err != nil {
+		c.e.Goto(inc.origin.Row, inc.origin.Col)
+		return
+	}
+	inc.re = re
+	t, origin := c.e.Render().Text, inc.origin
+	ctx, cancel := context.WithTimeout(context.Background(), config.Load().MAX_SEACH_TIME)
+	inc.cancel = cancel
+	go func() {
+		defer cancel()
+		pos, wrapped, err := find(ctx, t, re, origin, backward, 1, nil)
+		c.lock(func() {
+			if c.state.incsearch != inc || inc.command != cmd || ctx.Err() == context.Canceled {
+				return // the command changed
+			}
+			if err != nil {
+				pos = origin // not found, the preview goes back to origin
+			} else {
+				inc.found, inc.message = &pos, searchMessage(pattern, wrapped, nil)
+			}
+			c.e.Goto(pos.Row, pos.Col)
+			c.writeWithoutLock("")
+		})
+	}()
+}
+
+// stopIncsearchWithoutLock - cancel the preview, the cursor goes back to origin
+func (c *Editor) stopIncsearchWithoutLock() {
+	inc := c.state.incsearch
+	if inc == nil {
+		return
+	}
+	c.state.incsearch = nil
+	if inc.cancel != nil {
+		inc.cancel()
+	}
+	c.e.Goto(inc.origin.Row, inc.origin.Col)
+}
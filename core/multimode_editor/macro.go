@@ -0,0 +1,211 @@
+package multimode_editor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"telescope/config"
+	"telescope/core/editor"
+	"telescope/util/file_util"
+
+	"telescope/util/side_channel"
+)
+
+// macroKey - a keystroke or an action as dispatched by the ui, a macro is a sequence of keys
+type macroKey struct {
+	Name   string         `json:"name"` // method name, e.g. "type", "move_left", or the action key
+	Rune   rune           `json:"rune,omitempty"`
+	Cursor *editor.Cursor `json:"cursor,omitempty"` // mouse position of mouse actions
+}
+
+// keyWithoutLock - every key from the ui goes through here so that it can be recorded
+func (c *Editor) keyWithoutLock(k macroKey) {
+	prevRow := c.e.Render().Cursor.Row
+	if c.state.macroReg != 0 && len(c.state.playing) == 0 {
+		c.state.macroKeys = append(c.state.macroKeys, k)
+	}
+	if c.state.task != nil && c.taskKeyWithoutLock(k) {
+		return
+	}
+	if c.state.search != nil && c.state.search.searching && c.searchKeyWithoutLock(k) {
+		return
+	}
+	if c.state.confirm != nil && k.Name != "type" && k.Name != "key_escape" {
+		// other keys end the prompt, the lines replaced so far are recorded before they edit
+		c.endConfirmWithoutLock()
+	}
+	switch k.Name {
+	case "type":
+		c.typeWithoutLock(k.Rune)
+	case "enter":
+		c.enterWithoutLock()
+	case "backspace":
+		c.backspaceWithoutLock()
+	case "delete":
+		c.deleteWithoutLock()
+	case "move_left":
+		c.moveLeftWithoutLock()
+	case "move_right":
+		c.moveRightWithoutLock()
+	case "move_up":
+		c.moveUpWithoutLock()
+	case "move_down":
+		c.moveDownWithoutLock()
+	case "move_home":
+		c.moveHomeWithoutLock()
+	case "move_end":
+		c.moveEndWithoutLock()
+	case "move_page_up":
+		c.movePageUpWithoutLock()
+	case "move_page_down":
+		c.movePageDownWithoutLock()
+	case "undo":
+		c.undoWithoutLock()
+	case "redo":
+		c.redoWithoutLock()
+	default:
+		var vals []any
+		if k.Cursor != nil {
+			vals = append(vals, *k.Cursor)
+		}
+		c.actionWithoutLock(k.Name, vals...)
+	}
+	c.refreshGrepWithoutLock()
+	c.refilterWithoutLock()
+	c.refreshFilterWithoutLock(prevRow)
+}
+
+// failWithoutLock - write the message and stop the macro being played
+func (c *Editor) failWithoutLock(message string) {
+	c.state.failed = true
+	c.writeWithoutLock(message)
+}
+
+func validRegister(reg rune) bool {
+	return ('a' <= reg && reg <= 'z') || ('0' <= reg && reg <= '9')
+}
+
+// startRecordingWithoutLock - q{reg}
+func (c *Editor) startRecordingWithoutLock(reg rune) {
+	if !validRegister(reg) {
+		c.failWithoutLock(fmt.Sprintf("invalid register %c", reg))
+		return
+	}
+	c.state.macroReg = reg
+	c.state.macroKeys = nil
+	c.writeWithoutLock("")
+}
+
+// stopRecordingWithoutLock - q, the macro is saved into the config directory
+func (c *Editor) stopRecordingWithoutLock() {
+	reg := c.state.macroReg
+	keys := c.state.macroKeys[:max(0, len(c.state.macroKeys)-1)] // without the final q
+	c.state.macroReg = 0
+	c.state.macroKeys = nil
+	c.state.macros[reg] = keys
+	if err := saveMacro(reg, keys); err != nil {
+		c.writeWithoutLock(fmt.Sprintf("recorded @%c, error save macro %s", reg, err.Error()))
+		return
+	}
+	c.writeWithoutLock(fmt.Sprintf("recorded @%c, %d keys", reg, len(keys)))
+}
+
+// playMacroWithoutLock - @{reg} count times, @@ plays the last macro again
+// playback stops at the first failing command or after a run that neither edits nor moves the cursor,
+// e.g. j on the last line, and views are sent only at the end
+func (c *Editor) playMacroWithoutLock(reg rune, count int) {
+	if reg == '@' {
+		reg = c.state.lastMacro
+	}
+	keys, ok := c.state.macros[reg]
+	if !ok {
+		c.failWithoutLock(fmt.Sprintf("empty register @%c", reg))
+		return
+	}
+	if slices.Contains(c.state.playing, reg) {
+		c.failWithoutLock(fmt.Sprintf("recursive macro @%c", reg))
+		return
+	}
+	c.state.lastMacro = reg
+	c.state.playing = append(c.state.playing, reg)
+	defer func() {
+		c.state.playing = c.state.playing[:len(c.state.playing)-1]
+	}()
+
+	c.state.failed = false
+	runs, stalled := 0, false
+	play := func() {
+		for ; runs < count; runs++ {
+			cur, edits := c.e.Render().Cursor, c.state.edits
+			for _, k := range keys {
+				c.keyWithoutLock(k)
+				if c.state.failed {
+					return
+				}
+			}
+			if count > 1 && c.e.Render().Cursor == cur && c.state.edits == edits {
+				stalled = true
+				return
+			}
+		}
+	}
+	if len(c.state.playing) == 1 {
+		c.e.Batch(play)
+	} else {
+		play()
+	}
+	if c.state.failed {
+		c.writeWithoutLock(fmt.Sprintf("played @%c %d times, stopped at %s", reg, runs, c.e.Render().Status.Message))
+		return
+	}
+	if stalled {
+		c.writeWithoutLock(fmt.Sprintf("played @%c %d times, stopped as the cursor did not move", reg, runs))
+		return
+	}
+	c.writeWithoutLock(fmt.Sprintf("played @%c %d times", reg, runs))
+}
+
+func macroFilename() string {
+	return filepath.Join(config.Load().CONFIG_DIR, "macros.json")
+}
+
+// loadMacros - macros recorded in previous sessions
+func loadMacros() map[rune][]macroKey {
+	macros := make(map[rune][]macroKey)
+	b, err := os.ReadFile(macroFilename())
+	if err != nil {
+		return macros
+	}
+	var saved map[string][]macroKey
+	if err := json.Unmarshal(b, &saved); err != nil {
+		side_channel.WriteLn("error load macros ", err)
+		return macros
+	}
+	for reg, keys := range saved {
+		if rs := []rune(reg); len(rs) == 1 && validRegister(rs[0]) {
+			macros[rs[0]] = keys
+		}
+	}
+	return macros
+}
+
+// saveMacro - other registers are read again so that concurrent sessions do not overwrite each other
+func saveMacro(reg rune, keys []macroKey) error {
+	saved := make(map[string][]macroKey)
+	for r, ks := range loadMacros() {
+		saved[string(r)] = ks
+	}
+	saved[string(reg)] = keys
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.Load().CONFIG_DIR, 0o700); err != nil {
+		return err
+	}
+	return file_util.SafeWriteFile(macroFilename(), func(f func(i int, val []byte) bool) {
+		f(0, b)
+	})
+}
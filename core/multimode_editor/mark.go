@@ -0,0 +1,229 @@
+package multimode_editor
+
+import (
+	"fmt"
+	"sort"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+	"telescope/util/persistent/ordered_map"
+)
+
+// mark - position set by m{a-z}
+type mark struct {
+	name rune
+	pos  editor.Cursor
+}
+
+func (m mark) Cmp(other mark) int {
+	return int(m.name) - int(other.name)
+}
+
+// maxJumps - positions kept in the jump list
+const maxJumps = 100
+
+func validMark(name rune) bool {
+	return 'a' <= name && name <= 'z'
+}
+
+// lineRefs - marks and positions of the jump list by the offset of their line in the input, taken after an
+// edit the rows cannot follow, e.g. undo, they are found again in the text of the next use, -1 for lines in memory
+type lineRefs struct {
+	marks map[rune]int64
+	jumps []int64
+}
+
+// setMarkWithoutLock - m{a-z}, marks are saved with the state of the file on exit
+func (c *Editor) setMarkWithoutLock(name rune) {
+	if !validMark(name) {
+		c.failWithoutLock(fmt.Sprintf("invalid mark %c", name))
+		return
+	}
+	c.resolveMarksWithoutLock(c.e.Render().Text)
+	c.state.marks = c.state.marks.Set(mark{name: name, pos: c.e.Render().Cursor})
+	c.writeWithoutLock(fmt.Sprintf("mark %c", name))
+}
+
+// markWithoutLock - position of mark name, false if it is not set
+func (c *Editor) markWithoutLock(name rune) (editor.Cursor, bool) {
+	c.resolveMarksWithoutLock(c.e.Render().Text)
+	m, ok := c.state.marks.Get(mark{name: name})
+	if !ok {
+		return editor.Cursor{}, false
+	}
+	return m.(mark).pos, true
+}
+
+// pushJumpWithoutLock - remember the cursor before a jump, newer positions are dropped as in a browser history
+// and an older position on the same line is replaced
+func (c *Editor) pushJumpWithoutLock() {
+	c.resolveMarksWithoutLock(c.e.Render().Text)
+	cur := c.e.Render().Cursor
+	jumps := c.state.jumps[:c.state.jump]
+	jumps = append(jumps[:0:0], jumps...) // do not write into dropped positions
+	for i, p := range jumps {
+		if p.Row == cur.Row {
+			jumps = append(jumps[:i], jumps[i+1:]...)
+			break
+		}
+	}
+	jumps = append(jumps, cur)
+	if len(jumps) > maxJumps {
+		jumps = jumps[len(jumps)-maxJumps:]
+	}
+	c.state.jumps, c.state.jump = jumps, len(jumps)
+}
+
+// jumpWithoutLock - Ctrl+O goes n positions back in the jump list, Tab (Ctrl+I) goes forward if n < 0
+func (c *Editor) jumpWithoutLock(n int) {
+	c.resolveMarksWithoutLock(c.e.Render().Text)
+	if c.state.jump == len(c.state.jumps) && n > 0 {
+		// the cursor is remembered so that Ctrl+I comes back
+		c.pushJumpWithoutLock()
+		c.state.jump--
+	}
+	i := c.state.jump - n
+	if i < 0 || i >= len(c.state.jumps) {
+		c.failWithoutLock("end of jump list")
+		return
+	}
+	c.state.jump = i
+	p := c.state.jumps[i]
+	c.e.Goto(p.Row, p.Col)
+	c.writeWithoutLock(fmt.Sprintf("jump %d of %d", i+1, len(c.state.jumps)))
+}
+
+// remapMarksWithoutLock - called for every edit with the text before the edit, marks of removed lines are
+// deleted, positions of the jump list move to the line the removed lines were joined into
+// after undo, redo and :g/d replayed without its rows the lines are found again by their offset in the input,
+// marks of lines in memory are dropped then
+func (c *Editor) remapMarksWithoutLock(entry editor.LogEntry, t text.Text) {
+	c.resolveMarksWithoutLock(t)
+	if c.state.marks.Len() == 0 && len(c.state.jumps) == 0 {
+		return
+	}
+	switch entry.Command {
+	case editor.CommandDeleteGlobal:
+		if c.state.deleting != nil {
+			c.deleteMarkRowsWithoutLock(c.state.deleting)
+			return
+		}
+		c.state.lineRefs = takeLineRefs(t, c.state.marks, c.state.jumps)
+		return
+	case editor.CommandUndo, editor.CommandRedo, editor.CommandUndoGoto:
+		c.state.lineRefs = takeLineRefs(t, c.state.marks, c.state.jumps)
+		return
+	}
+	s, join, ok := lineShift(entry, t)
+	if !ok {
+		return
+	}
+	move := func(p editor.Cursor) (editor.Cursor, bool) {
+		row, ok := s.apply(p.Row)
+		switch {
+		case ok:
+			return editor.Cursor{Row: row, Col: p.Col}, true
+		case join:
+			// joined at the end of the line
+			col := t.LineLen(s.row)
+			for r := s.row + 1; r < p.Row; r++ {
+				col += t.LineLen(r)
+			}
+			return editor.Cursor{Row: row, Col: col + p.Col}, true
+		default:
+			return editor.Cursor{Row: row}, false
+		}
+	}
+	for m := range c.state.marks.Iter {
+		if pos, ok := move(m.pos); ok {
+			c.state.marks = c.state.marks.Set(mark{name: m.name, pos: pos})
+		} else {
+			c.state.marks = c.state.marks.Del(m)
+		}
+	}
+	for i, p := range c.state.jumps {
+		c.state.jumps[i], _ = move(p)
+	}
+}
+
+// deleteMarkRowsWithoutLock - marks of the increasing rows deleted by :g/d are deleted, positions of the jump
+// list move to the line before
+func (c *Editor) deleteMarkRowsWithoutLock(rows []int) {
+	move := func(p editor.Cursor) (editor.Cursor, bool) {
+		i := sort.SearchInts(rows, p.Row)
+		if i < len(rows) && rows[i] == p.Row {
+			return editor.Cursor{Row: max(0, p.Row-i-1)}, false
+		}
+		return editor.Cursor{Row: p.Row - i, Col: p.Col}, true
+	}
+	for m := range c.state.marks.Iter {
+		if pos, ok := move(m.pos); ok {
+			c.state.marks = c.state.marks.Set(mark{name: m.name, pos: pos})
+		} else {
+			c.state.marks = c.state.marks.Del(m)
+		}
+	}
+	for i, p := range c.state.jumps {
+		c.state.jumps[i], _ = move(p)
+	}
+}
+
+// takeLineRefs - offsets of the lines of marks and jumps in t
+func takeLineRefs(t text.Text, marks ordered_map.Map[mark], jumps []editor.Cursor) *lineRefs {
+	offset := func(row int) int64 {
+		if row < 0 || row >= t.Len() {
+			return -1
+		}
+		return t.Offset(row)
+	}
+	refs := &lineRefs{marks: make(map[rune]int64), jumps: make([]int64, len(jumps))}
+	for m := range marks.Iter {
+		refs.marks[m.name] = offset(m.pos.Row)
+	}
+	for i, p := range jumps {
+		refs.jumps[i] = offset(p.Row)
+	}
+	return refs
+}
+
+// resolveMarksWithoutLock - rows of marks and jumps taken as line offsets found in t, marks and jumps whose
+// line is not in t are dropped
+func (c *Editor) resolveMarksWithoutLock(t text.Text) {
+	refs := c.state.lineRefs
+	if refs == nil {
+		return
+	}
+	c.state.lineRefs = nil
+	for m := range c.state.marks.Iter {
+		offset, ok := refs.marks[m.name]
+		if row, found := findLine(t, offset); ok && found {
+			c.state.marks = c.state.marks.Set(mark{name: m.name, pos: editor.Cursor{Row: row, Col: m.pos.Col}})
+		} else {
+			c.state.marks = c.state.marks.Del(m)
+		}
+	}
+	jumps, jump := make([]editor.Cursor, 0, len(c.state.jumps)), c.state.jump
+	for i, p := range c.state.jumps {
+		row, ok := findLine(t, refs.jumps[i])
+		if !ok {
+			if i < c.state.jump {
+				jump--
+			}
+			continue
+		}
+		jumps = append(jumps, editor.Cursor{Row: row, Col: p.Col})
+	}
+	c.state.jumps, c.state.jump = jumps, jump
+}
+
+// findLine - row of the line at offset in the input, false if the line is not in t
+func findLine(t text.Text, offset int64) (int, bool) {
+	if offset < 0 {
+		return 0, false
+	}
+	for row := t.Search(offset); row < t.Len(); row++ {
+		if o := t.Offset(row); o >= 0 {
+			return row, o == offset
+		}
+	}
+	return 0, false
+}
@@ -1,14 +1,16 @@
 package multimode_editor
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
-	"telescope/config"
 	"telescope/core/util/text"
 	"telescope/util/file_util"
-	"time"
+	"unicode/utf8"
 
 	"telescope/util/side_channel"
 )
@@ -16,242 +18,500 @@ import (
 // all functions resulting in mode change
 
 func (c *Editor) keyEscapeWithoutLock() {
+	if c.state.confirm != nil {
+		c.endConfirmWithoutLock()
+		c.writeWithoutLock("replace stopped")
+		return
+	}
 	c.enterNormalModeWithoutLock()
 	c.writeWithoutLock("")
 }
 
 func (c *Editor) Enter() {
 	c.lock(func() {
-		switch c.state.mode {
-		case ModeNormal:
-			// do nothing
-		case ModeInsert:
-			c.e.Enter()
-		case ModeCommand:
-			c.applyCommandWithoutLock()
-		case ModeSelect:
-			// do nothing
-		default:
-			side_channel.Panic("unknown mode: ", c.state)
-		}
+		c.keyWithoutLock(macroKey{Name: "enter"})
 	})
 }
 
+func (c *Editor) enterWithoutLock() {
+	switch c.state.mode {
+	case ModeNormal:
+		// do nothing
+	case ModeInsert:
+		c.e.Enter()
+	case ModeCommand:
+		c.applyCommandWithoutLock()
+	case ModeSelect:
+		// do nothing
+	case ModeHex:
+		c.hexMoveWithoutLock(hexDown)
+	case ModeGrep:
+		c.grepJumpWithoutLock()
+	default:
+		side_channel.Panic("unknown mode: ", c.state)
+	}
+}
+
 func (c *Editor) maybeUpdateSelectorEndWithoutLock() {
 	if c.state.mode == ModeSelect {
-		row := c.e.Render().Cursor.Row
-		c.state.selector.end = row
+		c.state.selector.end = c.e.Render().Cursor
 		c.writeWithoutLock("select more")
 	}
 }
 
 func (c *Editor) Type(ch rune) {
 	c.lock(func() {
-		switch c.state.mode {
-		case ModeNormal:
-			switch ch {
-			case 'i':
-				c.enterInsertModeWithoutLock()
-				c.writeWithoutLock("")
-			case ':', '/':
-				c.enterCommandModeWithoutLock(string(ch))
-				c.writeWithoutLock("")
-			case 'V': // start selecting
-				row := c.e.Render().Cursor.Row
-				c.enterSelectModeWithoutLock(row)
-				c.writeWithoutLock("")
-
-			case 'p': // paste
-				if c.state.clipboard.Len() == 0 {
-					c.writeWithoutLock("clipboard is empty")
-					return
-				}
-				c.e.InsertLine(c.state.clipboard)
-				c.writeWithoutLock("pasted")
-			case 'u':
-				c.e.Undo()
-			case 'r':
-				c.e.Redo()
-
-			case 'b', 'g': // go to beg of file
-				c.e.Goto(0, 0)
-
-			case 'e', 'G': // go to end of file
-				row := c.e.Render().Text.Len() - 1
-				c.e.Goto(row, 0)
-			default:
-			}
-		case ModeInsert:
-			c.e.Type(ch)
-		case ModeCommand:
-			c.state.command += string(ch)
-			c.writeWithoutLock("")
-		case ModeSelect:
-			switch ch {
-			case 'd': // cut
-				beg, end := c.state.selector.Interval()
-				t := c.e.Render().Text
-				c.state.clipboard = text.Slice(t, beg, end+1)
-				// delete
-				c.e.Goto(beg, 0)
-				c.e.DeleteLine(c.state.clipboard.Len())
+		c.keyWithoutLock(macroKey{Name: "type", Rune: ch})
+	})
+}
 
+func (c *Editor) typeWithoutLock(ch rune) {
+	if c.state.confirm != nil {
+		c.confirmTypeWithoutLock(ch)
+		return
+	}
+	switch c.state.mode {
+	case ModeNormal:
+		c.normalTypeWithoutLock(ch)
+	case ModeInsert:
+		c.e.Type(ch)
+	case ModeCommand:
+		c.state.command += string(ch)
+		c.state.browse = nil
+		c.updateIncsearchWithoutLock()
+		c.writeWithoutLock("")
+	case ModeSelect:
+		if len(c.state.pending) > 0 {
+			c.normalTypeWithoutLock(ch)
+			return
+		}
+		switch ch {
+		case 'd': // cut
+			if !c.editableWithoutLock() {
 				c.enterNormalModeWithoutLock()
-				c.writeWithoutLock("cut")
+				return
+			}
+			c.beginChangeWithoutLock(c.selectionOriginWithoutLock())
+			c.cutSelectionWithoutLock()
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("cut")
 
-			case 'y': //copy
-				beg, end := c.state.selector.Interval()
-				t := c.e.Render().Text
-				c.state.clipboard = text.Slice(t, beg, end+1)
-				c.enterNormalModeWithoutLock()
-				c.writeWithoutLock("copied")
+		case 'y': //copy
+			c.copySelectionWithoutLock()
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("copied")
 
-			case 'b', 'g': // go to beg of file
-				c.e.Goto(0, 0)
-				c.maybeUpdateSelectorEndWithoutLock()
-			case 'e', 'G': // go to end of file
-				row := c.e.Render().Text.Len() - 1
-				c.e.Goto(row, 0)
-				c.maybeUpdateSelectorEndWithoutLock()
-			}
+		case 'V':
+			c.selectKeyWithoutLock(SelectLine)
+		case 'v':
+			c.selectKeyWithoutLock(SelectChar)
+		case ':': // ex command on the selected lines
+			selection := *c.state.selector
+			c.state.lastSelection = &selection
+			c.enterCommandModeWithoutLock(":'<,'>")
+			c.writeWithoutLock("")
 		default:
-			side_channel.Panic("unknown mode: ", c.state)
+			c.normalTypeWithoutLock(ch) // motions move the end of the selection
 		}
-	})
+	case ModeHex:
+		c.hexTypeWithoutLock(ch)
+	case ModeGrep:
+		c.grepTypeWithoutLock(ch)
+	default:
+		side_channel.Panic("unknown mode: ", c.state)
+	}
 }
 
 type command string
 
 const (
-	commandInsert    command = "i"
-	commandQuit      command = "q"
-	commandWriteQuit command = "wq"
-	commandSearch    command = "s"
-	commandRegex     command = "r"
-	commandGoto      command = "g"
-	commandWrite     command = "w"
-	commandUnknown   command = "u"
+	commandInsert      command = "i"
+	commandQuit        command = "q"
+	commandWriteBack   command = "wb"
+	commandWriteQuit   command = "wq"
+	commandSearch      command = "s"
+	commandBackward    command = "b"
+	commandRegex       command = "r"
+	commandNoHighlight command = "noh"
+	commandGoto        command = "g"
+	commandWrite       command = "w"
+	commandReload      command = "reload"
+	commandSnapshot    command = "snapshot"
+	commandReadonly    command = "ro"
+	commandReadwrite   command = "rw"
+	commandSource      command = "source"
+	commandHex         command = "hex"
+	commandSubstitute  command = "substitute"
+	commandGrep        command = "grep"
+	commandGrepOpen    command = "copen"
+	commandGrepClose   command = "cclose"
+	commandFilter      command = "filter"
+	commandFilterOut   command = "filter!"
+	commandUnfilter    command = "unfilter"
+	commandNoFilter    command = "nofilter"
+	commandGlobal      command = "global"
+	commandGlobalOut   command = "vglobal"
+	commandDelete      command = "delete"
+	commandYank        command = "yank"
+	commandNormal      command = "normal"
+	commandFilterLines command = "!"
+	commandShell       command = "shell"
+	commandRead        command = "read"
+	commandEarlier     command = "earlier"
+	commandLater       command = "later"
+	commandUndoList    command = "undolist"
+	commandUnknown     command = "u"
 )
 
-func parseCommand(cmd string) (command, []string) {
-	if cmd == ":i" || cmd == ":insert" {
-		return commandInsert, nil
+// exCommand - ":[range]name[!]arg", arg is the rest of the line as typed
+type exCommand struct {
+	rng  string
+	name string
+	bang bool
+	arg  string
+}
+
+// text - arg without the blank separating it from the name
+func (ex exCommand) text() string {
+	return strings.TrimPrefix(ex.arg, " ")
+}
+
+func (ex exCommand) fields() []string {
+	return strings.Fields(ex.arg)
+}
+
+// parseEx - split an ex command, the range is made of addresses, the name is made of letters
+func parseEx(cmd string) exCommand {
+	s := strings.TrimPrefix(cmd, ":")
+	i := 0
+	for i < len(s) {
+		switch {
+		case strings.IndexByte("0123456789.,$%+-", s[i]) >= 0:
+			i++
+			continue
+		case s[i] == '\'' && i+1 < len(s):
+			_, n := utf8.DecodeRuneInString(s[i+1:])
+			i += 1 + n
+			continue
+		}
+		break
 	}
-	if cmd == ":q" || cmd == ":quit" || cmd == ":q!" {
-		return commandQuit, nil
+	var ex exCommand
+	ex.rng, s = s[:i], s[i:]
+	i = 0
+	for i < len(s) && ('a' <= s[i] && s[i] <= 'z' || 'A' <= s[i] && s[i] <= 'Z') {
+		i++
 	}
-	if cmd == ":w" || cmd == ":write" || cmd == ":wq" {
-		return commandWriteQuit, nil
+	ex.name, s = s[:i], s[i:]
+	if strings.HasPrefix(s, "!") {
+		ex.bang, s = true, s[1:]
 	}
+	ex.arg = s
+	return ex
+}
 
-	for _, prefix := range []string{"/", ":s ", ":search "} {
-		if strings.HasPrefix(cmd, prefix) {
-			cmd = strings.TrimPrefix(cmd, prefix)
-			return commandSearch, strings.Fields(cmd)
-		}
-	}
+// exNames - names of ex commands, s, g and w depend on their argument
+var exNames = map[string]command{
+	"i": commandInsert, "insert": commandInsert,
+	"q": commandQuit, "quit": commandQuit,
+	"w": commandWrite, "write": commandWrite,
+	"wq": commandWriteQuit, "x": commandWriteQuit,
+	"reload":   commandReload,
+	"snapshot": commandSnapshot,
+	"ro":       commandReadonly, "readonly": commandReadonly,
+	"rw": commandReadwrite, "readwrite": commandReadwrite,
+	"hex":    commandHex,
+	"source": commandSource,
+	"noh":    commandNoHighlight, "nohlsearch": commandNoHighlight,
+	"grep":     commandGrep,
+	"copen":    commandGrepOpen,
+	"cclose":   commandGrepClose,
+	"filter":   commandFilter,
+	"unfilter": commandUnfilter,
+	"nofilter": commandNoFilter,
+	"regex":    commandRegex,
+	"s":        commandSearch, "search": commandSearch, "substitute": commandSubstitute,
+	"g": commandGoto, "goto": commandGoto, "global": commandGlobal,
+	"v": commandGlobalOut, "vglobal": commandGlobalOut,
+	"d": commandDelete, "delete": commandDelete,
+	"y": commandYank, "yank": commandYank,
+	"norm": commandNormal, "normal": commandNormal,
+	"r": commandRead, "read": commandRead,
+	"earlier": commandEarlier, "later": commandLater,
+	"undol": commandUndoList, "undolist": commandUndoList,
+}
 
-	for _, prefix := range []string{":regex "} {
-		if strings.HasPrefix(cmd, prefix) {
-			cmd = strings.TrimPrefix(cmd, prefix)
-			return commandRegex, strings.Fields(cmd)
-		}
-	}
+// rangeCommands - commands taking a range, the others refuse it
+var rangeCommands = []command{
+	commandGoto, commandWrite, commandSubstitute, commandGlobal, commandGlobalOut, commandDelete, commandYank,
+	commandNormal, commandFilterLines, commandRead,
+}
 
-	for _, prefix := range []string{":g ", ":goto "} {
-		if strings.HasPrefix(cmd, prefix) {
-			cmd = strings.TrimPrefix(cmd, prefix)
-			return commandGoto, strings.Fields(cmd)
-		}
+func parseCommand(cmd string) (command, exCommand) {
+	switch {
+	case strings.HasPrefix(cmd, "/"):
+		// the pattern is the rest of the command, spaces included
+		return commandSearch, exCommand{arg: " " + cmd[1:]}
+	case strings.HasPrefix(cmd, "?"):
+		return commandBackward, exCommand{arg: " " + cmd[1:]}
+	case !strings.HasPrefix(cmd, ":"):
+		return commandUnknown, exCommand{}
 	}
-	for _, prefix := range []string{":w ", ":write "} {
-		if strings.HasPrefix(cmd, prefix) {
-			cmd = strings.TrimPrefix(cmd, prefix)
-			return commandWrite, strings.Fields(cmd)
-		}
+	ex := parseEx(cmd)
+	switch {
+	case len(ex.name) == 0 && ex.bang && len(ex.rng) == 0:
+		return commandShell, ex // ":!cmd"
+	case len(ex.name) == 0 && ex.bang:
+		return commandFilterLines, ex // ":.,$!sort"
+	case len(ex.name) == 0:
+		return commandGoto, ex // ":10" or ":$"
 	}
-
-	// default to goto
-	for _, prefix := range []string{":"} {
-		if strings.HasPrefix(cmd, prefix) {
-			cmd = strings.TrimPrefix(cmd, prefix)
-			return commandGoto, strings.Fields(cmd)
+	name, ok := exNames[ex.name]
+	if !ok {
+		return commandUnknown, ex
+	}
+	// a delimiter right after the name starts a pattern
+	delimited := len(ex.arg) > 0 && !ex.bang && strings.IndexByte(" \t\"|", ex.arg[0]) < 0
+	switch {
+	case name == commandSearch && ex.name == "s" && strings.HasPrefix(ex.arg, "/"):
+		name = commandSubstitute
+	case name == commandGoto && ex.name == "g" && (delimited || ex.bang):
+		name = commandGlobal
+		if ex.bang {
+			name = commandGlobalOut
 		}
+	case name == commandWrite && len(ex.rng) == 0 && len(ex.fields()) == 0:
+		name = commandWriteBack
+	case name == commandFilter && ex.bang:
+		name = commandFilterOut
+	}
+	if len(ex.rng) > 0 && !slices.Contains(rangeCommands, name) {
+		return commandUnknown, ex
 	}
-	return commandUnknown, nil
+	return name, ex
 }
 
 func (c *Editor) applyCommandWithoutLock() {
-	cmd, args := parseCommand(c.state.command)
+	line := c.state.command
+	c.addHistoryWithoutLock(line)
+	cmd, ex := parseCommand(line)
+	args := ex.fields()
 	switch cmd {
 	case commandInsert:
+		if !c.editableWithoutLock() {
+			c.enterNormalModeWithoutLock()
+			return
+		}
 		c.enterInsertModeWithoutLock()
 		c.writeWithoutLock("")
 		return
 	case commandQuit:
 		c.stop()
 		return
-	case commandWriteQuit:
-		filename := c.defaultOutputFile
-
-		// write file
-		err := file_util.SafeWriteFile(filename, c.e.Render().Text.Iter)
+	case commandWriteBack, commandWriteQuit:
+		err := c.writeBackWithoutLock()
 		if err != nil {
 			c.enterNormalModeWithoutLock()
 			c.writeWithoutLock("error write file " + err.Error())
 			return
 		}
 		c.enterNormalModeWithoutLock()
-		c.writeWithoutLock("file written into " + filename)
+		c.writeWithoutLock("file written into " + c.defaultOutputFile + ", undo history was dropped")
 
-		// exit
-		c.stop()
+		if cmd == commandWriteQuit {
+			c.stop()
+		}
+		return
+	case commandReload:
+		if c.input == nil {
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("reload is not supported")
+			return
+		}
+		if c.e.Modified() && !ex.bang {
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("the text was edited, use :reload! to discard the edits")
+			return
+		}
+		c.detachClipboardWithoutLock()
+		err := c.input.Reload()
+		if err != nil {
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("error reload file " + err.Error())
+			return
+		}
+		// rows of the old file are meaningless
+		c.stopGrepWithoutLock()
+		c.state.filters = nil
+		c.resizeWithoutLock()
+		c.state.warning = ""
+		c.enterNormalModeWithoutLock()
+		c.writeWithoutLock("reloading " + c.defaultOutputFile)
 		return
-	case commandSearch, commandRegex:
+	case commandSnapshot:
+		if c.input == nil {
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("snapshot is not supported")
+			return
+		}
+		c.detachClipboardWithoutLock()
+		filename, err := c.input.Snapshot()
+		if err != nil {
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("error snapshot file " + err.Error())
+			return
+		}
+		c.state.warning = ""
+		c.enterNormalModeWithoutLock()
+		c.writeWithoutLock("reading from snapshot " + filename)
+		return
+	case commandReadonly:
+		c.state.readonly = true
+		c.state.warning = ""
+		c.enterNormalModeWithoutLock()
+		c.writeWithoutLock("read-only")
+		return
+	case commandReadwrite:
+		c.state.readonly = false
+		c.enterNormalModeWithoutLock()
+		c.writeWithoutLock("read-write")
+		return
+	case commandHex:
 		if len(args) == 0 {
+			c.enterHexModeAtCursorWithoutLock()
+			c.writeWithoutLock(c.hexOffsetMessageWithoutLock())
+			return
+		}
+		offset, err := strconv.ParseInt(args[0], 0, 64)
+		if err != nil || offset < 0 {
 			c.enterNormalModeWithoutLock()
-			c.writeWithoutLock("empty args")
+			c.writeWithoutLock("invalid offset " + args[0])
 			return
 		}
-		pattern := args[0]
-		var match func(line string) bool
-		if cmd == commandRegex {
-			re, err := regexp.Compile(pattern)
-			if err != nil {
-				c.enterNormalModeWithoutLock()
-				c.writeWithoutLock(fmt.Sprintf("regexp compile error %s", err.Error()))
-				return
-			}
-			match = re.MatchString
-		} else {
-			match = func(line string) bool {
-				return strings.Contains(line, pattern)
-			}
+		c.enterHexModeAtOffsetWithoutLock(offset)
+		c.writeWithoutLock(c.hexOffsetMessageWithoutLock())
+		return
+	case commandSubstitute:
+		c.substituteWithoutLock(ex.rng, ex.arg)
+		return
+	case commandSource:
+		var names []string
+		var offsets []int64
+		if c.input != nil {
+			names, offsets = c.input.Sources()
+		}
+		if len(names) == 0 {
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("input is not a concatenation")
+			return
 		}
-
 		view := c.e.Render()
-		row, t := view.Cursor.Row, view.Text
-		t = text.Slice(t, row+1, t.Len())
-
-		t0 := time.Now()
-		for i, line := range t.Iter {
-			if match(string(line)) {
-				c.e.Goto(row+1+i, 0)
-				c.writeWithoutLock("found substring " + pattern)
+		var i int
+		if len(args) == 0 {
+			// next file after the cursor
+			i = len(offsets)
+			if offset := view.Text.Offset(view.Cursor.Row); offset >= 0 {
+				i = sort.Search(len(offsets), func(j int) bool {
+					return offsets[j] > offset
+				})
+			}
+			if i >= len(offsets) {
+				c.enterNormalModeWithoutLock()
+				c.writeWithoutLock("last file")
 				return
 			}
-			t1 := time.Now()
-			if t1.Sub(t0) > config.Load().MAX_SEACH_TIME {
-				c.e.Goto(row+1+i, 0)
-				c.writeWithoutLock(fmt.Sprintf("search timeout after %d seconds and %d entries", config.Load().MAX_SEACH_TIME/time.Second, i+1))
+		} else {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 || n > len(names) {
+				c.enterNormalModeWithoutLock()
+				c.writeWithoutLock("invalid file number " + args[0])
 				return
 			}
+			i = n - 1
 		}
-		c.e.Goto(t.Len()-1, 0)
+		c.pushJumpWithoutLock()
+		c.e.Goto(view.Text.Search(offsets[i]), 0)
+		c.enterNormalModeWithoutLock()
+		c.writeWithoutLock(fmt.Sprintf("file %d/%d %s", i+1, len(names), names[i]))
+		return
+	case commandSearch:
+		c.startSearchWithoutLock(ex.text(), '/', false, false)
+		return
+	case commandBackward:
+		c.startSearchWithoutLock(ex.text(), '?', false, true)
+		return
+	case commandRegex:
+		c.startSearchWithoutLock(ex.text(), '/', true, false)
+		return
+	case commandNoHighlight:
+		if c.state.search != nil {
+			c.state.search.highlight = false
+		}
+		c.enterNormalModeWithoutLock()
+		c.writeWithoutLock("")
+		return
+	case commandGrep:
+		c.startGrepWithoutLock(ex.text())
+		return
+	case commandGrepOpen:
+		c.openGrepWithoutLock()
+		return
+	case commandGrepClose:
+		c.closeGrepWithoutLock()
 		c.enterNormalModeWithoutLock()
-		c.writeWithoutLock("end of file")
+		c.writeWithoutLock("")
+		return
+	case commandFilter, commandFilterOut:
+		c.startFilterWithoutLock(ex.text(), cmd == commandFilterOut)
+		return
+	case commandUnfilter, commandNoFilter:
+		c.unfilterWithoutLock(cmd == commandNoFilter)
+		return
+	case commandGlobal, commandGlobalOut:
+		c.globalWithoutLock(ex, cmd == commandGlobalOut)
+		return
+	case commandDelete:
+		c.exDeleteWithoutLock(ex)
+		return
+	case commandYank:
+		c.exYankWithoutLock(ex)
+		return
+	case commandNormal:
+		c.exNormalWithoutLock(ex)
+		return
+	case commandFilterLines:
+		c.filterLinesWithoutLock(ex)
+		return
+	case commandRead:
+		c.readWithoutLock(ex)
+		return
+	case commandShell:
+		c.shellWithoutLock(ex)
+		return
+	case commandEarlier, commandLater:
+		sign := 1
+		if cmd == commandEarlier {
+			sign = -1
+		}
+		c.earlierWithoutLock(ex, sign)
+		return
+	case commandUndoList:
+		c.undoListWithoutLock()
 		return
 	case commandGoto:
+		if len(ex.rng) > 0 {
+			// the last line of the range
+			_, end, err := c.parseRangeWithoutLock(ex.rng)
+			c.enterNormalModeWithoutLock()
+			if err != nil {
+				c.failWithoutLock(err.Error())
+				return
+			}
+			c.pushJumpWithoutLock()
+			c.e.Goto(end, 0)
+			c.writeWithoutLock(fmt.Sprintf("goto line %d", end+1))
+			return
+		}
 		if len(args) == 0 {
 			c.enterNormalModeWithoutLock()
 			c.writeWithoutLock("empty args")
@@ -264,19 +524,37 @@ func (c *Editor) applyCommandWithoutLock() {
 			c.writeWithoutLock("invalid line number " + lineStr)
 			return
 		}
+		c.pushJumpWithoutLock()
 		c.e.Goto(lineNum-1, 0)
 		c.enterNormalModeWithoutLock()
 		c.writeWithoutLock("goto line " + lineStr)
 		return
 	case commandWrite:
+		if len(ex.rng) > 0 {
+			c.exWriteRangeWithoutLock(ex)
+			return
+		}
 		if len(args) == 0 {
 			c.enterNormalModeWithoutLock()
 			c.writeWithoutLock("empty args")
 			return
 		}
 		filename := args[0]
+		if absFilename, _ := filepath.Abs(filename); absFilename == c.defaultOutputFile {
+			// writing into the input file
+			err := c.writeBackWithoutLock()
+			if err != nil {
+				c.enterNormalModeWithoutLock()
+				c.writeWithoutLock("error write file " + err.Error())
+				return
+			}
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("file written into " + filename + ", undo history was dropped")
+			return
+		}
 		// write file
-		err := file_util.SafeWriteFile(filename, c.e.Render().Text.Iter)
+		t := c.e.Render().Text
+		err := file_util.SafeWriteFileWith(filename, t.IterBytes, c.writeOptionsWithoutLock(filename, t))
 		if err != nil {
 			c.enterNormalModeWithoutLock()
 			c.writeWithoutLock("error write file " + err.Error())
@@ -287,48 +565,93 @@ func (c *Editor) applyCommandWithoutLock() {
 
 	default:
 		c.enterNormalModeWithoutLock()
-		c.writeWithoutLock("unknown command: " + c.state.command)
+		c.writeWithoutLock("unknown command: " + line)
+	}
+}
+
+// detachClipboardWithoutLock - copy the clipboard and the registers into memory, they must not refer to the old
+// file after rebase
+func (c *Editor) detachClipboardWithoutLock() {
+	c.state.clipboard.text = text.MakeTextFromLine(c.state.clipboard.text.Repr())
+	for reg, cb := range c.state.registers {
+		c.state.registers[reg] = clipboard{kind: cb.kind, text: text.MakeTextFromLine(cb.text.Repr())}
 	}
 }
+
+// writeBackWithoutLock - write into the input file, the editor is rebased onto the new file
+func (c *Editor) writeBackWithoutLock() error {
+	if c.input == nil {
+		return errors.New("write back is not supported")
+	}
+	c.detachClipboardWithoutLock()
+	err := c.input.WriteBack()
+	if err != nil {
+		return err
+	}
+	c.state.warning = ""
+	return nil
+}
+
 func (c *Editor) Delete() {
 	c.lock(func() {
-		switch c.state.mode {
-		case ModeNormal:
-			c.enterInsertModeWithoutLock()
-			c.writeWithoutLock("")
-			c.e.Delete()
-		case ModeInsert:
-			c.e.Delete()
-		case ModeCommand:
-		// do nothing
-		case ModeSelect:
-			// do nothing
-		default:
-			side_channel.Panic("unknown mode: ", c.state)
-		}
+		c.keyWithoutLock(macroKey{Name: "delete"})
 	})
 }
 
+func (c *Editor) deleteWithoutLock() {
+	switch c.state.mode {
+	case ModeNormal:
+		if !c.editableWithoutLock() {
+			return
+		}
+		c.enterInsertModeWithoutLock()
+		c.writeWithoutLock("")
+		c.e.Delete()
+	case ModeInsert:
+		c.e.Delete()
+	case ModeCommand:
+	// do nothing
+	case ModeSelect:
+		// do nothing
+	case ModeHex:
+		// bytes are overwritten, not deleted
+	case ModeGrep:
+		// do nothing
+	default:
+		side_channel.Panic("unknown mode: ", c.state)
+	}
+}
+
 func (c *Editor) Backspace() {
 	c.lock(func() {
-		switch c.state.mode {
-		case ModeNormal:
-			// do nothing
-		case ModeInsert:
-			c.e.Backspace()
-		case ModeCommand:
-			if len(c.state.command) > 0 {
-				c.state.command = c.state.command[:len(c.state.command)-1]
-				if len(c.state.command) == 0 {
-					c.enterNormalModeWithoutLock()
-					c.writeWithoutLock("")
-				}
+		c.keyWithoutLock(macroKey{Name: "backspace"})
+	})
+}
+
+func (c *Editor) backspaceWithoutLock() {
+	switch c.state.mode {
+	case ModeNormal:
+		// do nothing
+	case ModeInsert:
+		c.e.Backspace()
+	case ModeCommand:
+		c.state.browse = nil
+		if len(c.state.command) > 0 {
+			c.state.command = c.state.command[:len(c.state.command)-1]
+			if len(c.state.command) == 0 {
+				c.enterNormalModeWithoutLock()
+				c.writeWithoutLock("")
 			}
-			c.writeWithoutLock("")
-		case ModeSelect:
-			// do nothing
-		default:
-			side_channel.Panic("unknown mode: ", c.state)
+			c.updateIncsearchWithoutLock()
 		}
-	})
+		c.writeWithoutLock("")
+	case ModeSelect:
+		// do nothing
+	case ModeHex:
+		c.hexMoveWithoutLock(hexLeft)
+	case ModeGrep:
+		// do nothing
+	default:
+		side_channel.Panic("unknown mode: ", c.state)
+	}
 }
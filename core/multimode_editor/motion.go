@@ -0,0 +1,423 @@
+package multimode_editor
+
+import (
+	"telescope/core/editor"
+	"telescope/core/util/text"
+	"unicode"
+)
+
+// textReader - runes of a text around a position, the line break is '\n' at col == len(line)
+// runes are read in windows so that motions stay cheap on gigantic lines
+type textReader struct {
+	t      text.Text
+	row    int // row of the window, -1 if none
+	n      int // length of row
+	beg    int // col of the first rune of the window
+	window []rune
+}
+
+const textReaderWindow = 1024
+
+func newTextReader(t text.Text) *textReader {
+	return &textReader{t: t, row: -1}
+}
+
+func (r *textReader) lineLen(row int) int {
+	if row != r.row {
+		r.row, r.n, r.beg, r.window = row, r.t.LineLen(row), 0, nil
+	}
+	return r.n
+}
+
+func (r *textReader) at(p editor.Cursor) rune {
+	if p.Col >= r.lineLen(p.Row) {
+		return '\n'
+	}
+	if p.Col < r.beg || p.Col >= r.beg+len(r.window) {
+		r.beg = max(0, p.Col-textReaderWindow/2)
+		r.window = r.t.GetRange(p.Row, r.beg, r.beg+textReaderWindow)
+	}
+	return r.window[p.Col-r.beg]
+}
+
+// next - position after p, false at the end of text
+func (r *textReader) next(p editor.Cursor) (editor.Cursor, bool) {
+	if p.Col < r.lineLen(p.Row) {
+		return editor.Cursor{Row: p.Row, Col: p.Col + 1}, true
+	}
+	if p.Row+1 < r.t.Len() {
+		return editor.Cursor{Row: p.Row + 1, Col: 0}, true
+	}
+	return p, false
+}
+
+// prev - position before p, false at the beginning of text
+func (r *textReader) prev(p editor.Cursor) (editor.Cursor, bool) {
+	if p.Col > 0 {
+		return editor.Cursor{Row: p.Row, Col: p.Col - 1}, true
+	}
+	if p.Row > 0 {
+		return editor.Cursor{Row: p.Row - 1, Col: r.lineLen(p.Row - 1)}, true
+	}
+	return p, false
+}
+
+func (r *textReader) emptyLine(p editor.Cursor) bool {
+	return r.lineLen(p.Row) == 0
+}
+
+// blankLine - the line has only white spaces
+func (r *textReader) blankLine(row int) bool {
+	for col := 0; col < r.lineLen(row); col++ {
+		if !unicode.IsSpace(r.at(editor.Cursor{Row: row, Col: col})) {
+			return false
+		}
+	}
+	return true
+}
+
+// firstNonBlank - col of the first non-blank rune of row
+func (r *textReader) firstNonBlank(row int) int {
+	col := 0
+	for col < r.lineLen(row) && unicode.IsSpace(r.at(editor.Cursor{Row: row, Col: col})) {
+		col++
+	}
+	return col
+}
+
+// charClass - 0 for white spaces, 1 for word runes, 2 for other runes, every non-blank rune is a word rune
+// in a WORD
+func charClass(ch rune, bigWord bool) int {
+	switch {
+	case unicode.IsSpace(ch):
+		return 0
+	case bigWord, ch == '_', unicode.IsLetter(ch), unicode.IsDigit(ch):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func before(p editor.Cursor, q editor.Cursor) bool {
+	return p.Row < q.Row || (p.Row == q.Row && p.Col < q.Col)
+}
+
+// wordForward - beginning of the next word, empty lines are words
+func wordForward(r *textReader, p editor.Cursor, bigWord bool) editor.Cursor {
+	q, ok := p, true
+	if cls := charClass(r.at(p), bigWord); cls != 0 {
+		for ok && charClass(r.at(q), bigWord) == cls {
+			q, ok = r.next(q)
+		}
+	}
+	for ok && charClass(r.at(q), bigWord) == 0 && (q == p || !r.emptyLine(q)) {
+		q, ok = r.next(q)
+	}
+	return q
+}
+
+// wordEnd - end of the current or the next word
+func wordEnd(r *textReader, p editor.Cursor, bigWord bool) editor.Cursor {
+	q, ok := r.next(p)
+	for ok && charClass(r.at(q), bigWord) == 0 {
+		q, ok = r.next(q)
+	}
+	cls := charClass(r.at(q), bigWord)
+	for {
+		n, ok := r.next(q)
+		if !ok || charClass(r.at(n), bigWord) != cls {
+			return q
+		}
+		q = n
+	}
+}
+
+// wordBackward - beginning of the current or the previous word, empty lines are words
+func wordBackward(r *textReader, p editor.Cursor, bigWord bool) editor.Cursor {
+	q, ok := r.prev(p)
+	for ok && charClass(r.at(q), bigWord) == 0 && !r.emptyLine(q) {
+		q, ok = r.prev(q)
+	}
+	cls := charClass(r.at(q), bigWord)
+	if cls == 0 {
+		return q
+	}
+	for {
+		n, ok := r.prev(q)
+		if !ok || charClass(r.at(n), bigWord) != cls {
+			return q
+		}
+		q = n
+	}
+}
+
+// findChar - the count-th ch in the line after or before p, f and F land on ch, t and T next to it
+// a repeated t or T does not stop next to the same rune again
+func findChar(r *textReader, p editor.Cursor, key rune, ch rune, count int, repeat bool) (editor.Cursor, bool) {
+	forward := key == 'f' || key == 't'
+	col := p.Col
+	if repeat && key == 't' && col+1 < r.lineLen(p.Row) && r.at(editor.Cursor{Row: p.Row, Col: col + 1}) == ch {
+		col++
+	}
+	if repeat && key == 'T' && col > 0 && r.at(editor.Cursor{Row: p.Row, Col: col - 1}) == ch {
+		col--
+	}
+	for count > 0 {
+		if forward {
+			col++
+		} else {
+			col--
+		}
+		if col < 0 || col >= r.lineLen(p.Row) {
+			return p, false
+		}
+		if r.at(editor.Cursor{Row: p.Row, Col: col}) == ch {
+			count--
+		}
+	}
+	switch key {
+	case 't':
+		col--
+	case 'T':
+		col++
+	}
+	return editor.Cursor{Row: p.Row, Col: col}, true
+}
+
+// motion - target of a cursor motion, an operator applies from the cursor to the target
+type motion struct {
+	pos       editor.Cursor
+	linewise  bool // whole lines
+	inclusive bool // the rune at pos is included
+}
+
+// motionWithoutLock - target of key from p repeated count times, count is 0 if no count was typed
+func (c *Editor) motionWithoutLock(t text.Text, p editor.Cursor, key string, arg rune, count int, op rune) (motion, bool) {
+	r := newTextReader(t)
+	n := max(count, 1)
+	m := motion{pos: p}
+	if (key == "j" || key == "k") && len(c.state.filters) > 0 && op == 0 {
+		// lines shown, operators act on every line of the text
+		if key == "k" {
+			n = -n
+		}
+		row, ok := c.filterStepWithoutLock(p.Row, n)
+		m.pos.Row, m.linewise = row, true
+		return m, ok
+	}
+	switch key {
+	case "h":
+		m.pos.Col = max(0, p.Col-n)
+	case "l":
+		m.pos.Col = min(r.lineLen(p.Row), p.Col+n)
+	case "j":
+		m.pos.Row, m.linewise = min(t.Len()-1, p.Row+n), true
+	case "k":
+		m.pos.Row, m.linewise = max(0, p.Row-n), true
+	case "n", "N":
+		pos, ok := c.searchMotionWithoutLock(t, p, key == "N", n)
+		if !ok {
+			return m, false
+		}
+		m.pos = pos
+	case "0":
+		m.pos.Col = 0
+	case "^":
+		m.pos.Col = r.firstNonBlank(p.Row)
+	case "$":
+		m.pos.Row = min(t.Len()-1, p.Row+n-1)
+		m.pos.Col, m.inclusive = max(0, r.lineLen(m.pos.Row)-1), true
+	case "w", "W":
+		if cls := charClass(r.at(p), key == "W"); op == 'c' && cls != 0 {
+			// cw changes until the end of word, the first word is the one under the cursor
+			for q, ok := r.next(p); ok && charClass(r.at(q), key == "W") == cls; q, ok = r.next(q) {
+				m.pos = q
+			}
+			for i := 1; i < n; i++ {
+				m.pos = wordEnd(r, m.pos, key == "W")
+			}
+			m.inclusive = true
+			break
+		}
+		for i := 0; i < n; i++ {
+			m.pos = wordForward(r, m.pos, key == "W")
+		}
+		if op != 0 && m.pos.Row > p.Row {
+			// an operator does not join the next line
+			m.pos = editor.Cursor{Row: m.pos.Row - 1, Col: r.lineLen(m.pos.Row - 1)}
+		}
+	case "e", "E":
+		for i := 0; i < n; i++ {
+			m.pos = wordEnd(r, m.pos, key == "E")
+		}
+		m.inclusive = true
+	case "b", "B":
+		for i := 0; i < n; i++ {
+			m.pos = wordBackward(r, m.pos, key == "B")
+		}
+	case "f", "F", "t", "T", ";", ",":
+		find := []rune(key)[0]
+		if key == ";" || key == "," {
+			if c.state.lastFind == 0 {
+				return m, false
+			}
+			find, arg = c.state.lastFind, c.state.lastFindChar
+			if key == "," {
+				find = map[rune]rune{'f': 'F', 'F': 'f', 't': 'T', 'T': 't'}[find]
+			}
+		} else {
+			c.state.lastFind, c.state.lastFindChar = find, arg
+		}
+		pos, ok := findChar(r, p, find, arg, n, key == ";" || key == ",")
+		if !ok {
+			return m, false
+		}
+		m.pos, m.inclusive = pos, find == 'f' || find == 't'
+	case "'", "`":
+		pos, ok := c.markWithoutLock(arg)
+		if !ok {
+			return m, false
+		}
+		m.pos.Row = min(pos.Row, t.Len()-1)
+		m.pos.Col = min(pos.Col, r.lineLen(m.pos.Row))
+		m.linewise = key == "'"
+	case "G":
+		m.pos.Row, m.linewise = t.Len()-1, true
+		if count > 0 {
+			m.pos.Row = min(t.Len()-1, count-1)
+		} else if len(c.state.filters) > 0 && op == 0 {
+			m.pos.Row, _ = c.filterStepWithoutLock(p.Row, t.Len())
+		}
+	case "gg":
+		m.pos.Row, m.linewise = 0, true
+		if count > 0 {
+			m.pos.Row = min(t.Len()-1, count-1)
+		} else if len(c.state.filters) > 0 && op == 0 {
+			m.pos.Row, _ = c.filterStepWithoutLock(p.Row, -t.Len())
+		}
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// textObjectWithoutLock - range [beg, end) of a text object around p, whole rows beg.Row to end.Row if linewise
+func (c *Editor) textObjectWithoutLock(t text.Text, p editor.Cursor, key string, count int) (beg editor.Cursor, end editor.Cursor, linewise bool, ok bool) {
+	r := newTextReader(t)
+	n := max(count, 1)
+	around := key[0] == 'a'
+	switch obj := key[1:]; obj {
+	case "w", "W":
+		beg, end = wordObject(r, p, obj == "W", n, around)
+		return beg, end, false, true
+	case `"`, "'", "`":
+		beg, end, ok = quoteObject(r, p, []rune(obj)[0], around)
+		return beg, end, false, ok
+	case "p":
+		beg, end = paragraphObject(r, p, n, around)
+		return beg, end, true, true
+	default:
+		return p, p, false, false
+	}
+}
+
+// wordObject - count words and white space groups of the line around p, aw includes the white spaces
+// after the words or before them if there are none
+func wordObject(r *textReader, p editor.Cursor, bigWord bool, count int, around bool) (editor.Cursor, editor.Cursor) {
+	n := r.lineLen(p.Row)
+	class := func(col int) int {
+		return charClass(r.at(editor.Cursor{Row: p.Row, Col: col}), bigWord)
+	}
+	beg, end := p.Col, p.Col
+	if n == 0 {
+		return p, p
+	}
+	beg, end = min(beg, n-1), min(end, n-1)
+	cls := class(beg)
+	for beg > 0 && class(beg-1) == cls {
+		beg--
+	}
+	skipGroup := func() {
+		if end >= n {
+			return
+		}
+		cls := class(end)
+		for end < n && class(end) == cls {
+			end++
+		}
+	}
+	for i := 0; i < count; i++ {
+		skipGroup()
+		if around && (cls != 0 || i > 0) && end < n && class(end) == 0 {
+			skipGroup() // trailing white spaces
+		} else if around && cls == 0 {
+			skipGroup() // the word after the white spaces
+		}
+	}
+	if around && cls != 0 && class(end-1) != 0 {
+		// no trailing white spaces, include the leading ones
+		for beg > 0 && class(beg-1) == 0 {
+			beg--
+		}
+	}
+	return editor.Cursor{Row: p.Row, Col: beg}, editor.Cursor{Row: p.Row, Col: end}
+}
+
+// quoteObject - inside of the quoted string of the line containing p or after it, a" includes the quotes
+// and the white spaces after them
+func quoteObject(r *textReader, p editor.Cursor, quote rune, around bool) (editor.Cursor, editor.Cursor, bool) {
+	n := r.lineLen(p.Row)
+	at := func(col int) rune {
+		return r.at(editor.Cursor{Row: p.Row, Col: col})
+	}
+	open := -1
+	for col := 0; col < n; col++ {
+		if at(col) != quote || (col > 0 && at(col-1) == '\\') {
+			continue
+		}
+		if open < 0 {
+			open = col
+			continue
+		}
+		if p.Col <= col {
+			// the pair (open, col) contains p or is the first one after p
+			beg, end := open+1, col
+			if around {
+				beg, end = open, col+1
+				for end < n && (at(end) == ' ' || at(end) == '\t') {
+					end++
+				}
+			}
+			return editor.Cursor{Row: p.Row, Col: beg}, editor.Cursor{Row: p.Row, Col: end}, true
+		}
+		open = -1
+	}
+	return p, p, false
+}
+
+// paragraphObject - rows of count groups of blank or non-blank lines around p, ap includes the group after
+func paragraphObject(r *textReader, p editor.Cursor, count int, around bool) (editor.Cursor, editor.Cursor) {
+	last := r.t.Len() - 1
+	blank := r.blankLine(p.Row)
+	beg := p.Row
+	for beg > 0 && r.blankLine(beg-1) == blank {
+		beg--
+	}
+	end := p.Row
+	if around {
+		count++
+	}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			if end >= last {
+				break
+			}
+			end++
+			blank = r.blankLine(end)
+		}
+		for end < last && r.blankLine(end+1) == blank {
+			end++
+		}
+	}
+	return editor.Cursor{Row: beg, Col: 0}, editor.Cursor{Row: end, Col: 0}
+}
@@ -2,12 +2,17 @@ package multimode_editor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"sync"
 	"telescope/config"
 	"telescope/core/editor"
 	"telescope/core/insert_editor"
 	"telescope/core/util/text"
+	"telescope/util/file_util"
+	"telescope/util/persistent/ordered_map"
 
 	"telescope/util/buffer"
 )
@@ -19,59 +24,189 @@ const (
 	ModeCommand Mode = "COMMAND"
 	ModeInsert  Mode = "INSERT"
 	ModeSelect  Mode = "SELECT"
+	ModeHex     Mode = "HEX"
+	ModeGrep    Mode = "GREP"
 )
 
+// SelectKind - shape of the selection
+type SelectKind = string
+
+const (
+	SelectLine  SelectKind = "LINE"
+	SelectChar  SelectKind = "CHAR"
+	SelectBlock SelectKind = "BLOCK"
+)
+
+// Selector - selection from beg to end, both are included
 type Selector struct {
-	beg int
-	end int
+	kind SelectKind
+	beg  editor.Cursor
+	end  editor.Cursor
 }
 
+func (s *Selector) Kind() SelectKind {
+	return s.kind
+}
+
+// Interval - first and last selected rows
 func (s *Selector) Interval() (beg int, end int) {
+	beg, end = s.beg.Row, s.end.Row
+	if beg > end {
+		beg, end = end, beg
+	}
+	return beg, end
+}
+
+// Range - beg and end in text order
+func (s *Selector) Range() (beg editor.Cursor, end editor.Cursor) {
 	beg, end = s.beg, s.end
+	if end.Row < beg.Row || (end.Row == beg.Row && end.Col < beg.Col) {
+		beg, end = end, beg
+	}
+	return beg, end
+}
+
+// Columns - first and last selected columns of a block
+func (s *Selector) Columns() (beg int, end int) {
+	beg, end = s.beg.Col, s.end.Col
 	if beg > end {
 		beg, end = end, beg
 	}
 	return beg, end
 }
 
-type clipboard = text.Text
+// Contains - whether (row, col) is selected
+func (s *Selector) Contains(row int, col int) bool {
+	begRow, endRow := s.Interval()
+	if row < begRow || endRow < row {
+		return false
+	}
+	switch s.kind {
+	case SelectChar:
+		beg, end := s.Range()
+		return (row > beg.Row || col >= beg.Col) && (row < end.Row || col <= end.Col)
+	case SelectBlock:
+		begCol, endCol := s.Columns()
+		return begCol <= col && col <= endCol
+	default:
+		return true
+	}
+}
+
+// clipboard - text cut or copied with the shape of the selection
+type clipboard struct {
+	kind SelectKind
+	text text.Text
+}
+
+// Input - operations on the input file
+type Input interface {
+	// WriteBack - write the current text into the input file and rebase the editor onto the new file
+	WriteBack() error
+	// Reload - load the input file again from scratch
+	Reload() error
+	// Snapshot - keep reading from a private copy of the input file
+	Snapshot() (string, error)
+	// ReadOnly - the input file is opened read-only
+	ReadOnly() bool
+	// Sources - names and offsets of concatenated input files, nil if the input is a single file
+	Sources() ([]string, []int64)
+	// Failures - number of reads of the input that got no data, e.g. failed fetches of an url
+	Failures() int
+}
 
 type state struct {
 	mode      Mode
 	command   string
 	selector  *Selector
 	clipboard clipboard
+	registers map[rune]clipboard // "a to "z and "0 to "9
+	readonly  bool
+	warning   string // input file warning, kept until it is handled
+	hex       HexView
+	pending   []rune // keys of an incomplete NORMAL mode command
+	// last f, F, t or T and its character for ; and ,
+	lastFind     rune
+	lastFindChar rune
+	recording    *change // change in progress
+	lastChange   *change // repeated by .
+	// macros
+	macros    map[rune][]macroKey
+	macroReg  rune       // register being recorded, 0 if not recording
+	macroKeys []macroKey // keys recorded so far
+	lastMacro rune       // played by @@
+	playing   []rune     // registers being played
+	failed    bool       // a command failed, macros stop playing
+	edits     int        // log entries written, a macro run that neither edits nor moves the cursor stops playing
+	// ex commands
+	lastSelection *Selector    // '< and '>
+	confirm       *confirm     // :s with the c flag awaiting an answer
+	task          *task        // running in the background
+	visit         *pendingRows // rows left to visit by :normal
+	deleting      []int        // rows deleted by :g/d while it runs, marks follow them
+	search        *search      // last search
+	incsearch     *incsearch   // search as you type in COMMAND mode
+	grep          *grep        // results of :grep
+	filters       []*filter    // stacked filters, the last one gives the lines shown
+	filterStale   bool         // lines changed in an unknown way, e.g. undo, they are filtered again
+	filterTop     int          // index of the line shown at the top of the window
+	// positions
+	marks    ordered_map.Map[mark] // m{a-z}, saved for the file
+	jumps    []editor.Cursor       // positions before searches and gotos, oldest first
+	jump     int                   // index in jumps of Ctrl+O and Ctrl+I, len(jumps) if none was used
+	lineRefs *lineRefs             // marks and jumps to find again after an edit the rows cannot follow
+	restore  *fileState            // cursor of the last session, restored once the file is loaded
+	// COMMAND mode
+	commands []string       // ex commands typed, oldest first, saved for the file
+	searches []string       // / and ? commands typed
+	browse   *historyBrowse // Up and Down go through the commands starting like the command typed
+	// screen size, the text is shown above the grep panel
+	height int
+	width  int
 }
 
 type Editor struct {
 	stop              func()
+	shell             func(cmd string) error // run cmd in the terminal, nil if there is none
 	mu                sync.Mutex
 	e                 *insert_editor.Editor
 	defaultOutputFile string
+	input             Input
 	state             state
 }
 
 func (c *Editor) enterNormalModeWithoutLock() {
+	c.endChangeWithoutLock()
+	c.stopIncsearchWithoutLock()
 	c.state.mode = ModeNormal
 	c.state.command = ""
 	c.state.selector = nil
+	c.state.pending = nil
 }
 func (c *Editor) enterInsertModeWithoutLock() {
+	c.beginChangeWithoutLock(c.e.Render().Cursor) // the insert session is a change
 	c.state.mode = ModeInsert
 	c.state.command = ""
 	c.state.selector = nil
+	c.state.pending = nil
 }
 func (c *Editor) enterCommandModeWithoutLock(command string) {
+	c.endChangeWithoutLock()
 	c.state.mode = ModeCommand
 	c.state.command = command
 	c.state.selector = nil
+	c.state.pending = nil
+	c.state.browse = nil
 }
-func (c *Editor) enterSelectModeWithoutLock(beg int) {
+func (c *Editor) enterSelectModeWithoutLock(kind SelectKind, beg editor.Cursor) {
+	c.endChangeWithoutLock()
 	c.state.mode = ModeSelect
 	c.state.command = ""
+	c.state.pending = nil
 	c.state.selector = &Selector{
-		beg: beg,
-		end: beg,
+		kind: kind,
+		beg:  beg,
+		end:  beg,
 	}
 }
 
@@ -88,91 +223,234 @@ func (c *Editor) Load(ctx context.Context, reader buffer.Reader) (loadCtx contex
 
 func (c *Editor) Resize(height int, width int) {
 	c.lock(func() {
-		c.e.Resize(height, width)
+		c.state.height, c.state.width = height, width
+		c.resizeWithoutLock()
+		c.refreshFilterWithoutLock(0)
+		if c.state.grep != nil {
+			c.grepMoveWithoutLock(0)
+		}
+		if c.state.mode == ModeHex {
+			c.hexGotoWithoutLock(c.state.hex.Row, c.state.hex.Col)
+			c.writeWithoutLock("")
+		}
 	})
 }
 
 func (c *Editor) Goto(row int, col int) {
 	c.lock(func() {
 		c.e.Goto(row, col)
+		c.refreshFilterWithoutLock(0)
 	})
 }
 
 func (c *Editor) MoveLeft() {
 	c.lock(func() {
-		c.e.MoveLeft()
+		c.keyWithoutLock(macroKey{Name: "move_left"})
 	})
 }
 
+func (c *Editor) moveLeftWithoutLock() {
+	if c.state.mode == ModeHex {
+		c.hexMoveWithoutLock(hexLeft)
+		return
+	}
+	c.e.MoveLeft()
+	c.maybeUpdateSelectorEndWithoutLock()
+}
+
 func (c *Editor) MoveRight() {
 	c.lock(func() {
-		c.e.MoveRight()
+		c.keyWithoutLock(macroKey{Name: "move_right"})
 	})
 }
 
+func (c *Editor) moveRightWithoutLock() {
+	if c.state.mode == ModeHex {
+		c.hexMoveWithoutLock(hexRight)
+		return
+	}
+	c.e.MoveRight()
+	c.maybeUpdateSelectorEndWithoutLock()
+}
+
 func (c *Editor) MoveUp() {
 	c.lock(func() {
-		c.e.MoveUp()
-		if c.state.mode == ModeSelect {
-			row := c.e.Render().Cursor.Row
-			c.state.selector.end = row
-			c.writeWithoutLock("select more")
-		}
+		c.keyWithoutLock(macroKey{Name: "move_up"})
 	})
 }
 
+func (c *Editor) moveUpWithoutLock() {
+	if c.state.mode == ModeCommand {
+		c.browseHistoryWithoutLock(-1)
+		return
+	}
+	if c.state.mode == ModeHex {
+		c.hexMoveWithoutLock(hexUp)
+		return
+	}
+	if c.state.mode == ModeGrep {
+		c.grepMoveWithoutLock(-1)
+		return
+	}
+	if len(c.state.filters) > 0 {
+		c.filterMoveWithoutLock(-1)
+	} else {
+		c.e.MoveUp()
+	}
+	c.maybeUpdateSelectorEndWithoutLock()
+}
+
 func (c *Editor) MoveDown() {
 	c.lock(func() {
-		c.e.MoveDown()
-		if c.state.mode == ModeSelect {
-			row := c.e.Render().Cursor.Row
-			c.state.selector.end = row
-			c.writeWithoutLock("select more")
-		}
+		c.keyWithoutLock(macroKey{Name: "move_down"})
 	})
 }
 
+func (c *Editor) moveDownWithoutLock() {
+	if c.state.mode == ModeCommand {
+		c.browseHistoryWithoutLock(1)
+		return
+	}
+	if c.state.mode == ModeHex {
+		c.hexMoveWithoutLock(hexDown)
+		return
+	}
+	if c.state.mode == ModeGrep {
+		c.grepMoveWithoutLock(1)
+		return
+	}
+	if len(c.state.filters) > 0 {
+		c.filterMoveWithoutLock(1)
+	} else {
+		c.e.MoveDown()
+	}
+	c.maybeUpdateSelectorEndWithoutLock()
+}
+
 func (c *Editor) MoveHome() {
 	c.lock(func() {
-		c.e.MoveHome()
+		c.keyWithoutLock(macroKey{Name: "move_home"})
 	})
 }
 
+func (c *Editor) moveHomeWithoutLock() {
+	if c.state.mode == ModeHex {
+		c.hexMoveWithoutLock(hexHome)
+		return
+	}
+	if c.state.mode == ModeGrep {
+		c.grepMoveWithoutLock(-c.state.grep.hits.len())
+		return
+	}
+	c.e.MoveHome()
+	c.maybeUpdateSelectorEndWithoutLock()
+}
+
 func (c *Editor) MoveEnd() {
 	c.lock(func() {
-		c.e.MoveEnd()
+		c.keyWithoutLock(macroKey{Name: "move_end"})
 	})
 }
 
+func (c *Editor) moveEndWithoutLock() {
+	if c.state.mode == ModeHex {
+		c.hexMoveWithoutLock(hexEnd)
+		return
+	}
+	if c.state.mode == ModeGrep {
+		c.grepMoveWithoutLock(c.state.grep.hits.len())
+		return
+	}
+	c.e.MoveEnd()
+	c.maybeUpdateSelectorEndWithoutLock()
+}
+
 func (c *Editor) MovePageUp() {
 	c.lock(func() {
-		c.e.MovePageUp()
-		c.maybeUpdateSelectorEndWithoutLock()
+		c.keyWithoutLock(macroKey{Name: "move_page_up"})
 	})
 }
 
+func (c *Editor) movePageUpWithoutLock() {
+	if c.state.mode == ModeHex {
+		c.hexMoveWithoutLock(hexPageUp)
+		return
+	}
+	if c.state.mode == ModeGrep {
+		c.grepMoveWithoutLock(-max(c.grepHeightWithoutLock()-1, 1))
+		return
+	}
+	if len(c.state.filters) > 0 {
+		c.filterMoveWithoutLock(-c.e.Render().Window.Height)
+	} else {
+		c.e.MovePageUp()
+	}
+	c.maybeUpdateSelectorEndWithoutLock()
+}
+
 func (c *Editor) MovePageDown() {
 	c.lock(func() {
-		c.e.MovePageDown()
-		c.maybeUpdateSelectorEndWithoutLock()
+		c.keyWithoutLock(macroKey{Name: "move_page_down"})
 	})
 }
 
+func (c *Editor) movePageDownWithoutLock() {
+	if c.state.mode == ModeHex {
+		c.hexMoveWithoutLock(hexPageDown)
+		return
+	}
+	if c.state.mode == ModeGrep {
+		c.grepMoveWithoutLock(max(c.grepHeightWithoutLock()-1, 1))
+		return
+	}
+	if len(c.state.filters) > 0 {
+		c.filterMoveWithoutLock(c.e.Render().Window.Height)
+	} else {
+		c.e.MovePageDown()
+	}
+	c.maybeUpdateSelectorEndWithoutLock()
+}
+
 func (c *Editor) Undo() {
 	c.lock(func() {
-		c.e.Undo()
+		c.keyWithoutLock(macroKey{Name: "undo"})
 	})
 }
 
+func (c *Editor) undoWithoutLock() {
+	if !c.editableWithoutLock() {
+		return
+	}
+	c.e.Undo()
+	if c.state.mode == ModeHex {
+		c.hexGotoWithoutLock(c.state.hex.Row, c.state.hex.Col)
+		c.writeWithoutLock("undo")
+	}
+}
+
 func (c *Editor) Redo() {
 	c.lock(func() {
-		c.e.Redo()
+		c.keyWithoutLock(macroKey{Name: "redo"})
 	})
 }
 
+func (c *Editor) redoWithoutLock() {
+	if !c.editableWithoutLock() {
+		return
+	}
+	c.e.Redo()
+	if c.state.mode == ModeHex {
+		c.hexGotoWithoutLock(c.state.hex.Row, c.state.hex.Col)
+		c.writeWithoutLock("redo")
+	}
+}
+
 func (c *Editor) Apply(entry editor.LogEntry) {
 	c.lock(func() {
 		c.e.Apply(entry)
+		c.refreshGrepWithoutLock()
+		c.refilterWithoutLock()
+		c.refreshFilterWithoutLock(0)
 	})
 }
 
@@ -197,19 +475,28 @@ func (c *Editor) DeleteLine(count int) {
 	})
 }
 
-func New(e *insert_editor.Editor, stop func(), defaultOutputFile string) *Editor {
+func New(e *insert_editor.Editor, stop func(), shell func(cmd string) error, defaultOutputFile string, input Input) *Editor {
 	c := &Editor{
 		stop:              stop,
+		shell:             shell,
 		mu:                sync.Mutex{},
 		e:                 e,
 		defaultOutputFile: defaultOutputFile,
+		input:             input,
 		state: state{
 			mode:      ModeNormal,
 			command:   "",
 			selector:  nil,
-			clipboard: text.Text{},
+			clipboard: clipboard{kind: SelectLine, text: text.Text{}},
+			readonly:  input != nil && input.ReadOnly(),
+			warning:   "",
+			macros:    loadMacros(),
 		},
 	}
+	e.Record(c.recordWithoutLock)
+	c.restoreStateWithoutLock(loadFileState(defaultOutputFile))
+	window := e.Render().Window
+	c.state.height, c.state.width = window.Height, window.Width
 	c.writeWithoutLock("")
 	return c
 }
@@ -221,14 +508,69 @@ func (c *Editor) lock(f func()) {
 	f()
 }
 
+// editableWithoutLock - write a message and return false if the editor is read-only
+func (c *Editor) editableWithoutLock() bool {
+	if c.state.readonly {
+		c.writeWithoutLock("read-only, use :readwrite to enable editing")
+		return false
+	}
+	return true
+}
+
+// writeOptionsWithoutLock - format of t written by :w into filename, files named *.gz are gzip compressed
+// the file is not written if reading the input fails meanwhile since lines would be missing
+func (c *Editor) writeOptionsWithoutLock(filename string, t text.Text) file_util.WriteOptions {
+	opts := file_util.WriteOptions{
+		Gzip:           strings.EqualFold(filepath.Ext(filename), ".gz"),
+		NoFinalNewline: t.NoFinalNewline(),
+	}
+	if c.input != nil {
+		failures := c.input.Failures()
+		opts.Check = func() error {
+			if c.input.Failures() != failures {
+				return errors.New("reading the input failed, the text is incomplete, use :reload")
+			}
+			return nil
+		}
+	}
+	return opts
+}
+
 func (c *Editor) writeWithoutLock(message string) {
 	c.e.Status(func(status editor.Status) editor.Status {
 		if status.Other == nil {
 			status.Other = make(map[string]any)
 		}
 		status.Other["command"] = c.state.command
+		if len(c.state.pending) > 0 {
+			status.Other["command"] = string(c.state.pending)
+		}
 		status.Other["mode"] = c.state.mode
 		status.Other["selector"] = c.state.selector
+		status.Other["readonly"] = c.state.readonly
+		status.Other["warning"] = c.state.warning
+		if c.state.incsearch != nil && c.state.incsearch.re != nil {
+			status.Other["search"] = c.state.incsearch.re
+		} else if c.state.search != nil && c.state.search.highlight {
+			status.Other["search"] = c.state.search.re
+		} else {
+			delete(status.Other, "search")
+		}
+		if c.state.macroReg != 0 {
+			status.Other["recording"] = string(c.state.macroReg)
+		} else {
+			delete(status.Other, "recording")
+		}
+		if c.state.mode == ModeHex {
+			status.Other["hex"] = c.state.hex
+		} else {
+			delete(status.Other, "hex")
+		}
+		if panel := c.grepPanelWithoutLock(); panel != nil {
+			status.Other["grep"] = panel
+		} else {
+			delete(status.Other, "grep")
+		}
 		status.Message = message
 		return status
 	})
@@ -236,45 +578,87 @@ func (c *Editor) writeWithoutLock(message string) {
 
 func (c *Editor) Action(key string, vals ...any) {
 	c.lock(func() {
-		// TODO - consider if we should move these mouse action into the API
-		switch key {
-		case "mouse_click_left":
-			if c.state.mode == ModeInsert { // click only works for insert mode
-				p := vals[0].(editor.Cursor)
-				relRow, relCol := p.Row, p.Col
-				view := c.e.Render()
-				tlRow, tlCol := view.Window.TlRow, view.Window.TlCol
-				row, col := tlRow+relRow, tlCol+relCol
-				c.e.Goto(row, col)
-			}
-		case "mouse_scroll_up":
-			for i := 0; i < config.Load().SCROLL_SPEED; i++ {
-				c.e.MoveUp()
+		if !strings.HasPrefix(key, "key_") && !strings.HasPrefix(key, "mouse_") {
+			c.actionWithoutLock(key, vals...) // not dispatched by the ui
+			return
+		}
+		k := macroKey{Name: key}
+		if len(vals) > 0 {
+			if p, ok := vals[0].(editor.Cursor); ok {
+				k.Cursor = &p
 			}
-		case "mouse_scroll_down":
-			for i := 0; i < config.Load().SCROLL_SPEED; i++ {
-				c.e.MoveDown()
+		}
+		c.keyWithoutLock(k)
+	})
+}
+
+func (c *Editor) actionWithoutLock(key string, vals ...any) {
+	// TODO - consider if we should move these mouse action into the API
+	switch key {
+	case "mouse_click_left":
+		if c.state.mode == ModeInsert { // click only works for insert mode
+			p := vals[0].(editor.Cursor)
+			relRow, relCol := p.Row, p.Col
+			view := c.e.Render()
+			tlRow, tlCol := view.Window.TlRow, view.Window.TlCol
+			row, col := tlRow+relRow, tlCol+relCol
+			if v := c.filterViewWithoutLock(); v != nil {
+				if relRow >= len(v.Rows) {
+					return
+				}
+				row, col = v.Rows[relRow], tlCol+relCol-v.Gutter
 			}
-		case "mouse_scroll_left":
-			for i := 0; i < config.Load().SCROLL_SPEED; i++ {
-				c.e.MoveLeft()
+			c.e.Goto(row, col)
+		}
+	case "mouse_scroll_up":
+		for i := 0; i < config.Load().SCROLL_SPEED; i++ {
+			if c.state.mode == ModeHex {
+				c.hexMoveWithoutLock(hexUp)
+				continue
 			}
-		case "mouse_scroll_right":
-			for i := 0; i < config.Load().SCROLL_SPEED; i++ {
-				c.e.MoveRight()
+			c.e.MoveUp()
+		}
+	case "mouse_scroll_down":
+		for i := 0; i < config.Load().SCROLL_SPEED; i++ {
+			if c.state.mode == ModeHex {
+				c.hexMoveWithoutLock(hexDown)
+				continue
 			}
-		case "key_escape":
-			c.keyEscapeWithoutLock()
-		case "key_tabular":
-			if c.state.mode == ModeInsert {
-				for i := 0; i < config.Load().TAB_SIZE; i++ {
-					c.e.Type(' ')
-				}
+			c.e.MoveDown()
+		}
+	case "mouse_scroll_left":
+		for i := 0; i < config.Load().SCROLL_SPEED; i++ {
+			c.e.MoveLeft()
+		}
+	case "mouse_scroll_right":
+		for i := 0; i < config.Load().SCROLL_SPEED; i++ {
+			c.e.MoveRight()
+		}
+	case "input_loaded":
+		c.restoreCursorWithoutLock()
+	case "input_modified":
+		c.state.warning = fmt.Sprintf("%v", vals[0])
+		c.writeWithoutLock("")
+	case "key_escape":
+		c.keyEscapeWithoutLock()
+	case "key_jump_older":
+		if c.state.mode == ModeNormal {
+			c.jumpWithoutLock(1)
+		}
+	case "key_block_select":
+		c.selectKeyWithoutLock(SelectBlock)
+	case "key_tabular":
+		if c.state.mode == ModeNormal {
+			c.jumpWithoutLock(-1) // Tab is Ctrl+I
+		}
+		if c.state.mode == ModeInsert {
+			for i := 0; i < config.Load().TAB_SIZE; i++ {
+				c.e.Type(' ')
 			}
-		default:
-			c.writeWithoutLock(fmt.Sprintf("action not supported: %s", key))
 		}
-	})
+	default:
+		c.writeWithoutLock(fmt.Sprintf("action not supported: %s", key))
+	}
 }
 
 func (c *Editor) Subscribe(consume func(editor.LogEntry)) uint64 {
@@ -0,0 +1,402 @@
+package multimode_editor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+	"unicode"
+)
+
+// normalCommand - [count] ["register] [count] [operator [count]] (motion | text object) or [count] command key
+type normalCommand struct {
+	count int    // product of the typed counts, 0 if none was typed
+	reg   rune   // register of an operator or of p, 0 if none
+	op    rune   // d, c or y, 0 if none
+	key   string // motion, text object or command, the operator itself for dd, cc and yy
+	arg   rune   // character of f, F, t and T
+}
+
+type parseStatus int
+
+const (
+	parseIncomplete parseStatus = iota
+	parseInvalid
+	parseComplete
+)
+
+const (
+	normalOperators   = "dcy"
+	normalMotions     = "hjklwbeWBE0^$G;,nN"
+	normalArgMotions  = "fFtT'`"
+	normalCommands    = "i:/?Vvpurx." + "DCY"
+	normalArgCommands = "q@m"
+	normalObjects     = "wW\"'`p"
+)
+
+// parseNormal - parse the keys of a NORMAL mode command, operators and commands are not allowed in VISUAL mode
+func parseNormal(keys []rune, visual bool) (normalCommand, parseStatus) {
+	var cmd normalCommand
+	i := 0
+	readCount := func() int {
+		count := 0
+		for i < len(keys) && '0' <= keys[i] && keys[i] <= '9' && (count > 0 || keys[i] != '0') {
+			count = 10*count + int(keys[i]-'0')
+			i++
+		}
+		return count
+	}
+	mulCount := func(count int) {
+		if count > 0 {
+			cmd.count = max(cmd.count, 1) * count
+		}
+	}
+	mulCount(readCount())
+	if i < len(keys) && keys[i] == '"' && !visual {
+		if i+1 == len(keys) {
+			return cmd, parseIncomplete
+		}
+		cmd.reg = keys[i+1]
+		if !validRegister(unicode.ToLower(cmd.reg)) {
+			return cmd, parseInvalid
+		}
+		i += 2
+		mulCount(readCount())
+	}
+	if i == len(keys) {
+		return cmd, parseIncomplete
+	}
+	if k := keys[i]; !visual && strings.ContainsRune(normalOperators, k) {
+		cmd.op = k
+		i++
+		mulCount(readCount())
+		if i == len(keys) {
+			return cmd, parseIncomplete
+		}
+		if keys[i] == k {
+			cmd.key = string(k)
+			return cmd, completeIf(i+1 == len(keys))
+		}
+		if keys[i] == 'i' || keys[i] == 'a' {
+			if i+1 == len(keys) {
+				return cmd, parseIncomplete
+			}
+			if !strings.ContainsRune(normalObjects, keys[i+1]) {
+				return cmd, parseInvalid
+			}
+			cmd.key = string(keys[i : i+2])
+			return cmd, completeIf(i+2 == len(keys))
+		}
+	} else if !visual && strings.ContainsRune(normalCommands, k) {
+		cmd.key = string(k)
+		return cmd, completeIf(i+1 == len(keys))
+	} else if !visual && strings.ContainsRune(normalArgCommands, k) {
+		if i+1 == len(keys) {
+			return cmd, parseIncomplete
+		}
+		cmd.key, cmd.arg = string(k), keys[i+1]
+		return cmd, completeIf(i+2 == len(keys))
+	}
+	switch k := keys[i]; {
+	case strings.ContainsRune(normalMotions, k):
+		cmd.key = string(k)
+		return cmd, completeIf(i+1 == len(keys))
+	case strings.ContainsRune(normalArgMotions, k):
+		if i+1 == len(keys) {
+			return cmd, parseIncomplete
+		}
+		cmd.key, cmd.arg = string(k), keys[i+1]
+		return cmd, completeIf(i+2 == len(keys))
+	case k == 'g':
+		if i+1 == len(keys) {
+			return cmd, parseIncomplete
+		}
+		switch keys[i+1] {
+		case 'g':
+		case '-', '+': // undo tree in time order
+			if visual || cmd.op != 0 {
+				return cmd, parseInvalid
+			}
+		default:
+			return cmd, parseInvalid
+		}
+		cmd.key = string(keys[i : i+2])
+		return cmd, completeIf(i+2 == len(keys))
+	default:
+		return cmd, parseInvalid
+	}
+}
+
+// completeIf - complete if every key was parsed
+func completeIf(complete bool) parseStatus {
+	if complete {
+		return parseComplete
+	}
+	return parseInvalid
+}
+
+// normalTypeWithoutLock - keys are collected until they form a command
+func (c *Editor) normalTypeWithoutLock(ch rune) {
+	if ch == 'q' && len(c.state.pending) == 0 && c.state.macroReg != 0 && c.state.mode == ModeNormal {
+		c.stopRecordingWithoutLock()
+		return
+	}
+	c.state.pending = append(c.state.pending, ch)
+	cmd, st := parseNormal(c.state.pending, c.state.mode == ModeSelect)
+	switch st {
+	case parseIncomplete:
+		c.writeWithoutLock("")
+		return
+	case parseInvalid:
+		keys := string(c.state.pending)
+		c.state.pending = nil
+		c.failWithoutLock("unknown command " + keys)
+		return
+	}
+	c.state.pending = nil
+	c.applyNormalWithoutLock(cmd)
+}
+
+func (c *Editor) applyNormalWithoutLock(cmd normalCommand) {
+	n := max(cmd.count, 1)
+	switch cmd.key {
+	case "x":
+		cmd.op, cmd.key = 'd', "l"
+	case "D":
+		cmd.op, cmd.key = 'd', "$"
+	case "C":
+		cmd.op, cmd.key = 'c', "$"
+	case "Y":
+		cmd.op, cmd.key = 'y', "y"
+	}
+	if cmd.op == 0 {
+		switch cmd.key {
+		case "i":
+			if !c.editableWithoutLock() {
+				return
+			}
+			c.enterInsertModeWithoutLock()
+			c.writeWithoutLock("")
+			return
+		case ":", "/", "?":
+			c.enterCommandModeWithoutLock(cmd.key)
+			c.writeWithoutLock("")
+			return
+		case "V":
+			c.selectKeyWithoutLock(SelectLine)
+			return
+		case "v":
+			c.selectKeyWithoutLock(SelectChar)
+			return
+		case "p":
+			if !c.editableWithoutLock() {
+				return
+			}
+			cb, err := c.registerWithoutLock(cmd.reg)
+			if err != nil {
+				c.failWithoutLock(err.Error())
+				return
+			}
+			if cb.text.Len() == 0 {
+				c.writeWithoutLock("clipboard is empty")
+				return
+			}
+			c.beginChangeWithoutLock(c.e.Render().Cursor)
+			c.pasteWithoutLock(cb, n)
+			c.endChangeWithoutLock()
+			c.writeWithoutLock("pasted")
+			return
+		case "q":
+			c.startRecordingWithoutLock(cmd.arg)
+			return
+		case "@":
+			c.playMacroWithoutLock(cmd.arg, n)
+			return
+		case "m":
+			c.setMarkWithoutLock(cmd.arg)
+			return
+		case ".":
+			if !c.editableWithoutLock() {
+				return
+			}
+			c.repeatChangeWithoutLock(n)
+			return
+		case "u", "r":
+			if !c.editableWithoutLock() {
+				return
+			}
+			for i := 0; i < n; i++ {
+				if cmd.key == "u" {
+					c.e.Undo()
+				} else {
+					c.e.Redo()
+				}
+			}
+			return
+		case "g-":
+			c.undoStepsWithoutLock(-n)
+			return
+		case "g+":
+			c.undoStepsWithoutLock(n)
+			return
+		}
+	}
+
+	view := c.e.Render()
+	t, cur := view.Text, view.Cursor
+	if t.Len() == 0 {
+		c.failWithoutLock("empty file")
+		return
+	}
+	if cmd.op == 0 && (cmd.key == "n" || cmd.key == "N") && c.state.mode == ModeNormal && len(c.state.playing) == 0 {
+		c.repeatSearchWithoutLock(cmd.key == "N", cmd.count)
+		return
+	}
+	if cmd.op == 0 {
+		m, ok := c.motionWithoutLock(t, cur, cmd.key, cmd.arg, cmd.count, 0)
+		message := ""
+		if (cmd.key == "n" || cmd.key == "N") && c.state.search != nil {
+			message = c.state.search.message
+		}
+		if !ok {
+			if cmd.key == "'" || cmd.key == "`" {
+				message = fmt.Sprintf("mark %c not set", cmd.arg)
+			}
+			if len(message) == 0 {
+				message = "no motion " + cmd.key
+			}
+			c.failWithoutLock(message)
+			return
+		}
+		col := m.pos.Col
+		if m.linewise && cmd.key != "j" && cmd.key != "k" {
+			col = newTextReader(t).firstNonBlank(m.pos.Row)
+		}
+		if slices.Contains([]string{"G", "gg", "n", "N", "'", "`"}, cmd.key) {
+			c.pushJumpWithoutLock()
+		}
+		c.e.Goto(m.pos.Row, col)
+		c.maybeUpdateSelectorEndWithoutLock()
+		if c.state.mode != ModeSelect {
+			c.writeWithoutLock(message)
+		}
+		return
+	}
+	if cmd.op != 'y' && !c.editableWithoutLock() {
+		return
+	}
+
+	// range of the operator
+	var beg, end editor.Cursor
+	linewise := false
+	switch {
+	case cmd.key == string(cmd.op):
+		beg, end, linewise = cur, editor.Cursor{Row: min(t.Len()-1, cur.Row+n-1)}, true
+	case cmd.key[0] == 'i' || cmd.key[0] == 'a':
+		var ok bool
+		beg, end, linewise, ok = c.textObjectWithoutLock(t, cur, cmd.key, cmd.count)
+		if !ok {
+			c.failWithoutLock("no text object " + cmd.key)
+			return
+		}
+	default:
+		m, ok := c.motionWithoutLock(t, cur, cmd.key, cmd.arg, cmd.count, cmd.op)
+		if !ok {
+			c.failWithoutLock("no motion " + cmd.key)
+			return
+		}
+		beg, end, linewise = cur, m.pos, m.linewise
+		if before(end, beg) {
+			beg, end = end, beg
+		}
+		if m.inclusive {
+			end.Col = min(end.Col+1, t.LineLen(end.Row))
+		}
+	}
+	if cmd.op == 'y' {
+		c.operateWithoutLock(t, cmd.op, beg, end, linewise)
+		c.setRegisterWithoutLock(cmd.reg, c.state.clipboard)
+		return
+	}
+	c.beginChangeWithoutLock(cur)
+	c.operateWithoutLock(t, cmd.op, beg, end, linewise)
+	c.setRegisterWithoutLock(cmd.reg, c.state.clipboard)
+	if c.state.mode != ModeInsert {
+		c.endChangeWithoutLock() // c continues the change until the insert session ends
+	}
+}
+
+// operateWithoutLock - apply operator to [beg, end) or to rows beg.Row to end.Row if linewise, deleted text
+// is copied into the clipboard and every edit is a single journal entry
+func (c *Editor) operateWithoutLock(t text.Text, op rune, beg editor.Cursor, end editor.Cursor, linewise bool) {
+	if linewise {
+		c.state.clipboard = clipboard{kind: SelectLine, text: text.Slice(t, beg.Row, end.Row+1)}
+	} else {
+		c.state.clipboard = clipboard{kind: SelectChar, text: rangeText(t, beg, end)}
+	}
+	lines := end.Row - beg.Row + 1
+	switch {
+	case op == 'y':
+		c.e.Goto(beg.Row, beg.Col)
+		if linewise {
+			c.writeWithoutLock(fmt.Sprintf("copied %d lines", lines))
+		} else {
+			c.writeWithoutLock("copied")
+		}
+	case op == 'd' && linewise:
+		c.e.Goto(beg.Row, 0)
+		c.e.DeleteLine(lines)
+		if t2 := c.e.Render().Text; t2.Len() > 0 {
+			row := min(beg.Row, t2.Len()-1)
+			c.e.Goto(row, newTextReader(t2).firstNonBlank(row))
+		}
+		c.writeWithoutLock(fmt.Sprintf("cut %d lines", lines))
+	case op == 'd':
+		c.e.DeleteRange(beg, end, false)
+		c.writeWithoutLock("cut")
+	case op == 'c':
+		if linewise {
+			// the lines are replaced by an empty line
+			beg, end = editor.Cursor{Row: beg.Row}, editor.Cursor{Row: end.Row, Col: t.LineLen(end.Row)}
+		}
+		c.e.DeleteRange(beg, end, false)
+		c.enterInsertModeWithoutLock()
+		c.writeWithoutLock("change")
+	}
+}
+
+// repeatText - count copies of the clipboard as pasted at once
+func repeatText(cb clipboard, count int) text.Text {
+	if count <= 1 {
+		return cb.text
+	}
+	lines := cb.text.Repr()
+	switch cb.kind {
+	case SelectLine:
+		ts := make([]text.Text, count)
+		for i := range ts {
+			ts[i] = cb.text
+		}
+		return text.Merge(ts...)
+	case SelectBlock:
+		width := 0
+		for _, line := range lines {
+			width = max(width, len(line))
+		}
+		out := make([][]rune, len(lines))
+		for i, line := range lines {
+			padded := append(slices.Clone(line), []rune(strings.Repeat(" ", width-len(line)))...)
+			out[i] = slices.Repeat(padded, count)
+		}
+		return text.MakeTextFromLine(out)
+	default:
+		out := slices.Clone(lines)
+		for i := 1; i < count; i++ {
+			last := len(out) - 1
+			out[last] = append(slices.Clone(out[last]), lines[0]...)
+			out = append(out, lines[1:]...)
+		}
+		return text.MakeTextFromLine(out)
+	}
+}
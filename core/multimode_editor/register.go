@@ -0,0 +1,47 @@
+package multimode_editor
+
+import (
+	"fmt"
+	"telescope/core/util/text"
+	"unicode"
+)
+
+// setRegisterWithoutLock - cb goes into register reg, an upper case register appends to the lower case one,
+// nothing is done if reg is 0
+func (c *Editor) setRegisterWithoutLock(reg rune, cb clipboard) error {
+	if reg == 0 {
+		return nil
+	}
+	lower := unicode.ToLower(reg)
+	if !validRegister(lower) {
+		return fmt.Errorf("invalid register %c", reg)
+	}
+	if c.state.registers == nil {
+		c.state.registers = make(map[rune]clipboard)
+	}
+	if old, ok := c.state.registers[lower]; ok && reg != lower && old.text.Len() > 0 {
+		kind := old.kind
+		if kind != cb.kind {
+			kind = SelectLine
+		}
+		cb = clipboard{kind: kind, text: text.Merge(old.text, cb.text)}
+	}
+	c.state.registers[lower] = cb
+	return nil
+}
+
+// registerWithoutLock - content of register reg, the clipboard if reg is 0
+func (c *Editor) registerWithoutLock(reg rune) (clipboard, error) {
+	if reg == 0 {
+		return c.state.clipboard, nil
+	}
+	lower := unicode.ToLower(reg)
+	if !validRegister(lower) {
+		return clipboard{}, fmt.Errorf("invalid register %c", reg)
+	}
+	cb, ok := c.state.registers[lower]
+	if !ok || cb.text.Len() == 0 {
+		return clipboard{}, fmt.Errorf("register %c is empty", lower)
+	}
+	return cb, nil
+}
@@ -0,0 +1,88 @@
+package multimode_editor
+
+import (
+	"fmt"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+)
+
+// change - log entries of a change, they are replayed relative to the cursor the change started at
+type change struct {
+	origin  editor.Cursor
+	entries []editor.LogEntry
+}
+
+// recordWithoutLock - called by the insert editor for every edit with the text before the edit, the caller
+// holds the lock
+func (c *Editor) recordWithoutLock(entry editor.LogEntry, t text.Text) {
+	c.state.edits++
+	c.remapGrepWithoutLock(entry, t)
+	c.remapFilterWithoutLock(entry, t)
+	c.remapPendingWithoutLock(entry, t)
+	c.remapMarksWithoutLock(entry, t)
+	if c.state.recording == nil {
+		return
+	}
+	switch entry.Command {
+	case editor.CommandUndo, editor.CommandRedo, editor.CommandUndoGoto, editor.CommandSetVersion:
+		return
+	}
+	c.state.recording.entries = append(c.state.recording.entries, entry)
+}
+
+// beginChangeWithoutLock - start recording a change at origin, a change in progress is continued
+func (c *Editor) beginChangeWithoutLock(origin editor.Cursor) {
+	if c.state.recording != nil {
+		return
+	}
+	c.state.recording = &change{origin: origin}
+}
+
+// endChangeWithoutLock - the recorded change becomes the last change unless nothing was edited
+func (c *Editor) endChangeWithoutLock() {
+	if c.state.recording == nil {
+		return
+	}
+	if len(c.state.recording.entries) > 0 {
+		c.state.lastChange = c.state.recording
+	}
+	c.state.recording = nil
+}
+
+// repeatChangeWithoutLock - replay the last change count times, each time at the cursor
+func (c *Editor) repeatChangeWithoutLock(count int) {
+	last := c.state.lastChange
+	if last == nil {
+		c.failWithoutLock("no change to repeat")
+		return
+	}
+	for i := 0; i < count; i++ {
+		cur := c.e.Render().Cursor
+		dRow, dCol := cur.Row-last.origin.Row, cur.Col-last.origin.Col
+		for _, entry := range last.entries {
+			c.e.Apply(shiftEntry(entry, last.origin, dRow, dCol))
+		}
+	}
+	c.writeWithoutLock(fmt.Sprintf("repeated %d edits", count*len(last.entries)))
+}
+
+// shiftEntry - move entry by dRow rows, columns move by dCol on the row of origin or in a block
+func shiftEntry(entry editor.LogEntry, origin editor.Cursor, dRow int, dCol int) editor.LogEntry {
+	shift := func(row uint64, col uint64) (uint64, uint64) {
+		r, cl := int(row), int(col)
+		if r == origin.Row || entry.Block {
+			cl += dCol
+		}
+		return uint64(max(r+dRow, 0)), uint64(max(cl, 0))
+	}
+	switch entry.Command {
+	case editor.CommandInsertLine, editor.CommandDeleteLine, editor.CommandSetLines:
+		entry.Row = uint64(max(int(entry.Row)+dRow, 0))
+	case editor.CommandDeleteRange:
+		entry.Row, entry.Col = shift(entry.Row, entry.Col)
+		entry.EndRow, entry.EndCol = shift(entry.EndRow, entry.EndCol)
+	default:
+		entry.Row, entry.Col = shift(entry.Row, entry.Col)
+	}
+	return entry
+}
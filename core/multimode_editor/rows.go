@@ -0,0 +1,265 @@
+package multimode_editor
+
+import (
+	"sort"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+	"telescope/util/persistent/seq"
+)
+
+// rowShift - rows after row move by delta, if delta < 0 the lines row+1 to row-delta were removed
+type rowShift struct {
+	row   int
+	delta int
+}
+
+// apply - row after the shift, false if the line of row was removed
+func (s rowShift) apply(row int) (int, bool) {
+	switch {
+	case row <= s.row:
+		return row, true
+	case s.delta < 0 && row <= s.row-s.delta:
+		return s.row, false
+	default:
+		return row + s.delta, true
+	}
+}
+
+// lineShift - how rows move when entry is applied to t, join is true if the removed lines are joined into
+// shift.row, ok is false if no line is inserted or removed
+func lineShift(entry editor.LogEntry, t text.Text) (shift rowShift, join bool, ok bool) {
+	row, col := int(entry.Row), int(entry.Col)
+	switch entry.Command {
+	case editor.CommandEnter:
+		return rowShift{row: row, delta: 1}, false, true
+	case editor.CommandBackspace:
+		if col == 0 && row > 0 {
+			return rowShift{row: row - 1, delta: -1}, true, true
+		}
+	case editor.CommandDelete:
+		if row+1 < t.Len() && col >= t.LineLen(row) {
+			return rowShift{row: row, delta: -1}, true, true
+		}
+	case editor.CommandInsertLine:
+		return rowShift{row: row - 1, delta: len(entry.Text)}, false, true
+	case editor.CommandDeleteLine:
+		if n := min(int(entry.Count), t.Len()-row); n > 0 {
+			return rowShift{row: row - 1, delta: -n}, false, true
+		}
+	case editor.CommandSetByte:
+		if row >= t.Len() {
+			break
+		}
+		n := t.LineBytes(row)
+		if entry.Byte == '\n' && col < n {
+			return rowShift{row: row, delta: 1}, false, true
+		}
+		if entry.Byte != '\n' && col >= n && row+1 < t.Len() {
+			return rowShift{row: row, delta: -1}, true, true
+		}
+	case editor.CommandSetLines:
+		// the first lines are set in place, the others are removed or inserted after them
+		n, m := min(int(entry.Count), t.Len()-row), len(entry.Text)
+		if n != m {
+			return rowShift{row: row - 1 + min(n, m), delta: m - n}, false, true
+		}
+	case editor.CommandDeleteRange:
+		if !entry.Block && int(entry.EndRow) > row {
+			return rowShift{row: row, delta: row - int(entry.EndRow)}, true, true
+		}
+	case editor.CommandInsertText:
+		if !entry.Block && len(entry.Text) > 1 {
+			return rowShift{row: row, delta: len(entry.Text) - 1}, false, true
+		}
+	}
+	return rowShift{}, false, false
+}
+
+// rowEntry - row as it was after the first from shifts of its set
+type rowEntry struct {
+	row  int
+	from int
+}
+
+// rowSet - increasing rows of the text that follow the edits, shifts are applied when rows are read so that
+// an edit costs O(log n) however many rows follow it
+type rowSet struct {
+	entries seq.Seq[rowEntry]
+	shifts  []rowShift
+}
+
+// maxRowShifts - shifts kept before the rows are compacted
+const maxRowShifts = 64
+
+func makeRowSet(rows []int) rowSet {
+	entries := make([]rowEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = rowEntry{row: row}
+	}
+	return rowSet{entries: seq.FromSlice(entries)}
+}
+
+func (r *rowSet) len() int {
+	return r.entries.Len()
+}
+
+func (r *rowSet) get(i int) int {
+	e := r.entries.Get(i)
+	row := e.row
+	for _, s := range r.shifts[e.from:] {
+		row, _ = s.apply(row)
+	}
+	return row
+}
+
+// index - index of the first row at or after row
+func (r *rowSet) index(row int) int {
+	return sort.Search(r.len(), func(i int) bool {
+		return r.get(i) >= row
+	})
+}
+
+func (r *rowSet) contains(row int) bool {
+	i := r.index(row)
+	return i < r.len() && r.get(i) == row
+}
+
+// slice - rows as they are now
+func (r *rowSet) slice() []int {
+	rows := make([]int, 0, r.len())
+	r.entries.Iter(func(_ int, e rowEntry) bool {
+		row := e.row
+		for _, s := range r.shifts[e.from:] {
+			row, _ = s.apply(row)
+		}
+		rows = append(rows, row)
+		return true
+	})
+	return rows
+}
+
+// insert - add rows beg to end-1
+func (r *rowSet) insert(beg int, end int) {
+	i, j := r.index(beg), r.index(end)
+	entries := make([]rowEntry, 0, end-beg)
+	for row := beg; row < end; row++ {
+		entries = append(entries, rowEntry{row: row, from: len(r.shifts)})
+	}
+	r.entries = seq.Merge(r.entries.Slice(0, i), seq.FromSlice(entries), r.entries.Slice(j, r.len()))
+}
+
+// shift - move the rows by s, rows of removed lines are deleted, if join the line they are joined into is
+// added if any of them was in the set, if grow the inserted lines are added if they are next to a row of
+// the set
+func (r *rowSet) shift(s rowShift, join bool, grow bool) {
+	if s.delta < 0 {
+		beg, end := r.index(s.row+1), r.index(s.row-s.delta+1)
+		r.entries = seq.Merge(r.entries.Slice(0, beg), r.entries.Slice(end, r.len()))
+		r.shifts = append(r.shifts, s)
+		if join && beg < end && !r.contains(s.row) {
+			r.insert(s.row, s.row+1)
+		}
+		return
+	}
+	grow = grow && (r.contains(s.row) || r.contains(s.row+1))
+	r.shifts = append(r.shifts, s)
+	if grow {
+		r.insert(s.row+1, s.row+1+s.delta)
+	}
+}
+
+// append - add rows found after every row of the set in the text before the shifts, rows of lines removed
+// since are skipped
+func (r *rowSet) append(rows []int) {
+	entries := make([]rowEntry, 0, len(rows))
+	for _, row := range rows {
+		ok, shifted := true, row
+		for _, s := range r.shifts {
+			if shifted, ok = s.apply(shifted); !ok {
+				break
+			}
+		}
+		if ok {
+			entries = append(entries, rowEntry{row: row})
+		}
+	}
+	r.entries = seq.Merge(r.entries, seq.FromSlice(entries))
+}
+
+// compact - apply the shifts once there are too many of them
+func (r *rowSet) compact() {
+	if len(r.shifts) >= maxRowShifts {
+		*r = makeRowSet(r.slice())
+	}
+}
+
+// rowList - increasing rows shown by stacked filters
+type rowList interface {
+	len() int
+	get(i int) int
+	index(row int) int // index of the first row at or after row
+	slice() []int
+}
+
+// allRows - every row of a text of n lines
+type allRows struct {
+	n int
+}
+
+func (a allRows) len() int {
+	return a.n
+}
+
+func (a allRows) get(i int) int {
+	return i
+}
+
+func (a allRows) index(row int) int {
+	return max(0, min(row, a.n))
+}
+
+func (a allRows) slice() []int {
+	rows := make([]int, a.n)
+	for i := range rows {
+		rows[i] = i
+	}
+	return rows
+}
+
+// exceptRows - rows of parent but the rows of excluded, which are rows of parent too, so that a filter that
+// hides a few lines stores only them
+type exceptRows struct {
+	parent   rowList
+	excluded rowSet
+}
+
+func (x *exceptRows) len() int {
+	return x.parent.len() - x.excluded.len()
+}
+
+func (x *exceptRows) get(i int) int {
+	// the first row of parent with i+1 rows shown up to it
+	k := sort.Search(x.parent.len(), func(k int) bool {
+		return k+1-x.excluded.index(x.parent.get(k)+1) > i
+	})
+	return x.parent.get(k)
+}
+
+func (x *exceptRows) index(row int) int {
+	return x.parent.index(row) - x.excluded.index(row)
+}
+
+func (x *exceptRows) slice() []int {
+	excluded := x.excluded.slice()
+	rows := make([]int, 0, max(0, x.len()))
+	j := 0
+	for _, row := range x.parent.slice() {
+		for j < len(excluded) && excluded[j] < row {
+			j++
+		}
+		if j == len(excluded) || excluded[j] != row {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,357 @@
+package multimode_editor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"telescope/config"
+	"telescope/core/editor"
+	"telescope/core/util/text"
+	"time"
+	"unicode"
+)
+
+// search - the last search, repeated by n and N
+type search struct {
+	pattern   string
+	backward  bool
+	re        *regexp.Regexp
+	highlight bool   // matches are highlighted until :noh
+	message   string // result of the last n or N
+	searching bool   // the first match is searched in the background
+	cancel    func() // cancel the search or the count of matches running in the background
+}
+
+// errNotFound - no match in the whole file
+var errNotFound = errors.New("pattern not found")
+
+// searchFlags - s smartcase, w whole word, i ignore case
+const searchFlags = "swi"
+
+// splitSearch - pattern and flags of "pattern" or "pattern<delim>flags", the delimiter is escaped as \<delim>
+func splitSearch(s string, delim rune) (pattern string, flags string) {
+	var b strings.Builder
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case rs[i] == '\\' && i+1 < len(rs) && rs[i+1] == delim:
+			b.WriteRune(delim)
+			i++
+		case rs[i] == delim && strings.Trim(string(rs[i+1:]), searchFlags) == "":
+			return b.String(), string(rs[i+1:])
+		default:
+			b.WriteRune(rs[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// compileSearch - pattern is a substring unless regex, smartcase ignores case if pattern has no upper case
+func compileSearch(pattern string, regex bool, flags string) (*regexp.Regexp, error) {
+	expr := pattern
+	if !regex {
+		expr = regexp.QuoteMeta(pattern)
+	}
+	if strings.Contains(flags, "w") {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	ignoreCase := strings.Contains(flags, "i")
+	if strings.Contains(flags, "s") && !strings.ContainsFunc(pattern, unicode.IsUpper) {
+		ignoreCase = true
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// startSearchWithoutLock - /, ? and :regex, go to the first match after or before the cursor
+// the match previewed by the incremental search is taken as is, otherwise the file is searched in the background
+func (c *Editor) startSearchWithoutLock(cmd string, delim rune, regex bool, backward bool) {
+	inc := c.state.incsearch
+	c.enterNormalModeWithoutLock() // the cursor goes back to where the incremental search started
+	pattern, flags := splitSearch(cmd, delim)
+	if len(pattern) == 0 {
+		if c.state.search == nil {
+			c.failWithoutLock("empty pattern")
+			return
+		}
+		// repeat the last pattern in the new direction
+		c.cancelSearchWithoutLock()
+		c.state.search.backward = backward
+		c.state.search.highlight = true
+	} else {
+		re, err := compileSearch(pattern, regex, flags)
+		if err != nil {
+			c.failWithoutLock(fmt.Sprintf("regexp compile error %s", err.Error()))
+			return
+		}
+		c.cancelSearchWithoutLock()
+		c.state.search = &search{pattern: pattern, backward: backward, re: re, highlight: true}
+	}
+	view := c.e.Render()
+	if inc != nil && inc.found != nil && inc.command == string(delim)+cmd {
+		c.foundWithoutLock(view.Text, *inc.found, inc.message)
+		return
+	}
+	if len(c.state.playing) > 0 {
+		// macros need the result before the next key
+		pos, message, ok := c.findWithoutLock(view.Text, view.Cursor, backward, 1)
+		if !ok {
+			c.failWithoutLock(message)
+			return
+		}
+		c.foundWithoutLock(view.Text, pos, message)
+		return
+	}
+	c.findInBackgroundWithoutLock(view, backward, 1)
+}
+
+// findInBackgroundWithoutLock - go to the count-th match after or before the cursor of view
+// the search continues in the background after SEARCH_BLOCKING_TIME so that input is never blocked for long,
+// any key cancels it and so does a moved cursor
+func (c *Editor) findInBackgroundWithoutLock(view editor.View, backward bool, count int) {
+	c.cancelSearchWithoutLock()
+	s := c.state.search
+	blockingCtx, blockingCancel := context.WithTimeout(context.Background(), config.Load().SEARCH_BLOCKING_TIME)
+	pos, wrapped, err := find(blockingCtx, view.Text, s.re, view.Cursor, backward, count, nil)
+	blockingCancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		message := searchMessage(s.pattern, wrapped, err)
+		if err != nil {
+			c.failWithoutLock(message)
+			return
+		}
+		c.foundWithoutLock(view.Text, pos, message)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.searching = cancel, true
+	c.writeWithoutLock("searching " + s.pattern)
+	go func() {
+		defer cancel()
+		progress := c.searchProgress(ctx, view.Text.Len()*max(count, 1))
+		pos, wrapped, err := find(ctx, view.Text, s.re, view.Cursor, backward, count, progress)
+		c.lock(func() {
+			if ctx.Err() != nil || c.state.search != s {
+				return // cancelled or replaced by another search
+			}
+			c.cancelSearchWithoutLock()
+			if c.e.Render().Cursor != view.Cursor {
+				return // the cursor moved while searching
+			}
+			message := searchMessage(s.pattern, wrapped, err)
+			if err != nil {
+				c.writeWithoutLock(message)
+				return
+			}
+			c.foundWithoutLock(view.Text, pos, message)
+		})
+	}()
+}
+
+// searchProgress - progress of a background search in Status.Background
+func (c *Editor) searchProgress(ctx context.Context, total int) func(done int) {
+	last := time.Now()
+	return func(done int) {
+		if ctx.Err() != nil || time.Since(last) < config.Load().LOADING_PROGRESS_INTERVAL {
+			return
+		}
+		last = time.Now()
+		c.e.Status(func(status editor.Status) editor.Status {
+			status.Background = fmt.Sprintf("search %d%%", 100*done/max(total, 1))
+			return status
+		})
+	}
+}
+
+// searchKeyWithoutLock - while searching in the background, ESC cancels the search, other keys cancel it too
+// and are handled as usual
+func (c *Editor) searchKeyWithoutLock(k macroKey) bool {
+	c.cancelSearchWithoutLock()
+	if k.Name == "key_escape" {
+		c.writeWithoutLock("search cancelled")
+		return true
+	}
+	return false
+}
+
+func (c *Editor) foundWithoutLock(t text.Text, pos editor.Cursor, message string) {
+	c.pushJumpWithoutLock()
+	c.e.Goto(pos.Row, pos.Col)
+	c.refreshFilterWithoutLock(pos.Row)
+	c.writeWithoutLock(message)
+	c.countMatchesWithoutLock(t, pos)
+}
+
+func searchMessage(pattern string, wrapped bool, err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Sprintf("search timeout after %d seconds", config.Load().MAX_SEACH_TIME/time.Second)
+	case err != nil:
+		return "pattern not found " + pattern
+	case wrapped:
+		return "search wrapped around"
+	default:
+		return "found " + pattern
+	}
+}
+
+// findWithoutLock - count-th match of the last search after p, or before p if backward, bounded by MAX_SEACH_TIME
+func (c *Editor) findWithoutLock(t text.Text, p editor.Cursor, backward bool, count int) (editor.Cursor, string, bool) {
+	if c.state.search == nil {
+		return p, "no previous search", false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Load().MAX_SEACH_TIME)
+	defer cancel()
+	pos, wrapped, err := find(ctx, t, c.state.search.re, p, backward, count, nil)
+	return pos, searchMessage(c.state.search.pattern, wrapped, err), err == nil
+}
+
+// find - count-th match of re after p, or before p if backward, the search wraps around the end of file
+// progress is called with the number of rows searched so far if not nil
+func find(ctx context.Context, t text.Text, re *regexp.Regexp, p editor.Cursor, backward bool, count int, progress func(done int)) (editor.Cursor, bool, error) {
+	if t.Len() == 0 {
+		return p, false, errNotFound
+	}
+	wrapped := false
+	for k := 0; k < max(count, 1); k++ {
+		var next func(done int)
+		if progress != nil {
+			offset := k * t.Len()
+			next = func(done int) {
+				progress(offset + done)
+			}
+		}
+		pos, w, err := findNext(ctx, t, re, p, backward, next)
+		if err != nil {
+			return p, wrapped, err
+		}
+		p, wrapped = pos, wrapped || w
+	}
+	return p, wrapped, nil
+}
+
+// findNext - the first match after p, or before p if backward, whether the search wrapped around
+// rows are searched in parallel in two passes, from p to the end of file then from the beginning of file to p,
+// the other way round if backward
+func findNext(ctx context.Context, t text.Text, re *regexp.Regexp, p editor.Cursor, backward bool, progress func(done int)) (editor.Cursor, bool, error) {
+	n, from := t.Len(), t.ByteCol(p.Row, p.Col)
+	type pass struct {
+		beg    int
+		end    int
+		accept func(h text.Hit) bool
+	}
+	passes := []pass{
+		{p.Row, n, func(h text.Hit) bool { return h.Row > p.Row || h.Beg > from }},
+		{0, p.Row + 1, func(h text.Hit) bool { return h.Row < p.Row || h.Beg <= from }},
+	}
+	if backward {
+		passes = []pass{
+			{0, p.Row + 1, func(h text.Hit) bool { return h.Row < p.Row || h.Beg < from }},
+			{p.Row, n, func(h text.Hit) bool { return h.Row > p.Row || h.Beg >= from }},
+		}
+	}
+	searched := 0
+	for i, ps := range passes {
+		var found *text.Hit
+		err := t.SearchRows(ctx, re, ps.beg, ps.end, backward, func(hits []text.Hit, done int) bool {
+			for j := range hits {
+				h := hits[j]
+				if backward {
+					h = hits[len(hits)-1-j]
+				}
+				if ps.accept(h) {
+					found = &h
+					return false
+				}
+			}
+			if progress != nil {
+				progress(searched + done)
+			}
+			return true
+		})
+		if found != nil {
+			return editor.Cursor{Row: found.Row, Col: t.RuneCol(found.Row, found.Beg)}, i > 0, nil
+		}
+		if err != nil {
+			return p, false, err
+		}
+		searched += ps.end - ps.beg
+	}
+	return p, false, errNotFound
+}
+
+// repeatSearchWithoutLock - n and N in NORMAL mode, long searches continue in the background
+func (c *Editor) repeatSearchWithoutLock(reverse bool, count int) {
+	if c.state.search == nil {
+		c.failWithoutLock("no previous search")
+		return
+	}
+	c.state.search.highlight = true
+	c.findInBackgroundWithoutLock(c.e.Render(), c.state.search.backward != reverse, count)
+}
+
+// searchMotionWithoutLock - n repeats the last search in its direction, N in the other direction
+func (c *Editor) searchMotionWithoutLock(t text.Text, p editor.Cursor, reverse bool, count int) (editor.Cursor, bool) {
+	if c.state.search == nil {
+		return p, false
+	}
+	c.state.search.highlight = true
+	pos, message, ok := c.findWithoutLock(t, p, c.state.search.backward != reverse, count)
+	c.state.search.message = message
+	if ok {
+		c.countMatchesWithoutLock(t, pos)
+	}
+	return pos, ok
+}
+
+func (c *Editor) cancelSearchWithoutLock() {
+	s := c.state.search
+	if s == nil || s.cancel == nil {
+		return
+	}
+	s.cancel()
+	if s.searching {
+		c.e.Status(func(status editor.Status) editor.Status {
+			status.Background = ""
+			return status
+		})
+	}
+	s.cancel, s.searching = nil, false
+}
+
+// countMatchesWithoutLock - count matches in the background, "match k of N" is written if the cursor is still
+// at pos when the count finishes
+func (c *Editor) countMatchesWithoutLock(t text.Text, pos editor.Cursor) {
+	c.cancelSearchWithoutLock()
+	s := c.state.search
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() {
+		defer cancel()
+		total, k := 0, 0
+		from := t.ByteCol(pos.Row, pos.Col)
+		err := t.SearchRows(ctx, s.re, 0, t.Len(), false, func(hits []text.Hit, done int) bool {
+			for _, h := range hits {
+				total++
+				if h.Row < pos.Row || (h.Row == pos.Row && h.Beg <= from) {
+					k++
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return
+		}
+		c.lock(func() {
+			if ctx.Err() != nil || c.state.search != s || c.e.Render().Cursor != pos {
+				return
+			}
+			c.writeWithoutLock(fmt.Sprintf("match %d of %d", k, total))
+		})
+	}()
+}
@@ -0,0 +1,129 @@
+package multimode_editor
+
+import (
+	"telescope/core/editor"
+	"telescope/core/util/text"
+)
+
+// selectKeyWithoutLock - v, V and Ctrl+V start a selection, the same key again ends it and another key
+// changes its shape
+func (c *Editor) selectKeyWithoutLock(kind SelectKind) {
+	if c.state.mode == ModeSelect {
+		if c.state.selector.kind == kind {
+			c.enterNormalModeWithoutLock()
+			c.writeWithoutLock("")
+			return
+		}
+		c.state.selector.kind = kind
+		c.writeWithoutLock("select " + kind)
+		return
+	}
+	if c.state.mode != ModeNormal {
+		return
+	}
+	c.enterSelectModeWithoutLock(kind, c.e.Render().Cursor)
+	c.writeWithoutLock("")
+}
+
+// selectionEnd - end of the selection after the last selected character, (row+1, 0) includes the line
+// break of row
+func selectionEnd(t text.Text, end editor.Cursor) editor.Cursor {
+	if end.Row < t.Len() && end.Col < t.LineLen(end.Row) {
+		return editor.Cursor{Row: end.Row, Col: end.Col + 1}
+	}
+	return editor.Cursor{Row: end.Row + 1, Col: 0}
+}
+
+// rangeText - text from beg to end, end is exclusive and an empty last line is a selected line break
+func rangeText(t text.Text, beg editor.Cursor, end editor.Cursor) text.Text {
+	var lines [][]rune
+	for row := beg.Row; row <= end.Row && row < t.Len(); row++ {
+		b, e := 0, t.LineLen(row)
+		if row == beg.Row {
+			b = beg.Col
+		}
+		if row == end.Row {
+			e = end.Col
+		}
+		lines = append(lines, t.GetRange(row, b, e))
+	}
+	return text.MakeTextFromLine(lines)
+}
+
+// copySelectionWithoutLock - copy the selection into the clipboard
+func (c *Editor) copySelectionWithoutLock() {
+	t := c.e.Render().Text
+	s := c.state.selector
+	var lines [][]rune
+	switch s.kind {
+	case SelectChar:
+		beg, end := s.Range()
+		c.state.clipboard = clipboard{kind: SelectChar, text: rangeText(t, beg, selectionEnd(t, end))}
+		return
+	case SelectBlock:
+		begRow, endRow := s.Interval()
+		begCol, endCol := s.Columns()
+		for row := begRow; row <= endRow && row < t.Len(); row++ {
+			lines = append(lines, t.GetRange(row, begCol, endCol+1))
+		}
+	default:
+		begRow, endRow := s.Interval()
+		c.state.clipboard = clipboard{kind: SelectLine, text: text.Slice(t, begRow, min(endRow+1, t.Len()))}
+		return
+	}
+	c.state.clipboard = clipboard{kind: s.kind, text: text.MakeTextFromLine(lines)}
+}
+
+// selectionOriginWithoutLock - top left corner of the selection
+func (c *Editor) selectionOriginWithoutLock() editor.Cursor {
+	s := c.state.selector
+	switch s.kind {
+	case SelectChar:
+		beg, _ := s.Range()
+		return beg
+	default:
+		row, _ := s.Interval()
+		col, _ := s.Columns()
+		if s.kind == SelectLine {
+			col = 0
+		}
+		return editor.Cursor{Row: row, Col: col}
+	}
+}
+
+// cutSelectionWithoutLock - copy the selection into the clipboard then delete it
+func (c *Editor) cutSelectionWithoutLock() {
+	c.copySelectionWithoutLock()
+	t := c.e.Render().Text
+	s := c.state.selector
+	switch s.kind {
+	case SelectChar:
+		beg, end := s.Range()
+		c.e.DeleteRange(beg, selectionEnd(t, end), false)
+	case SelectBlock:
+		begRow, endRow := s.Interval()
+		begCol, endCol := s.Columns()
+		c.e.DeleteRange(
+			editor.Cursor{Row: begRow, Col: begCol},
+			editor.Cursor{Row: endRow, Col: endCol + 1},
+			true,
+		)
+	default:
+		begRow, _ := s.Interval()
+		c.e.Goto(begRow, 0)
+		c.e.DeleteLine(c.state.clipboard.text.Len())
+	}
+}
+
+// pasteWithoutLock - paste count copies of cb, lines are pasted above the cursor, characters and blocks at the cursor
+func (c *Editor) pasteWithoutLock(cb clipboard, count int) {
+	t := repeatText(cb, count)
+	switch cb.kind {
+	case SelectChar:
+		c.e.InsertText(t, false)
+	case SelectBlock:
+		c.e.InsertText(t, true)
+	default:
+		c.e.InsertLine(t)
+	}
+}
@@ -0,0 +1,181 @@
+package multimode_editor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"telescope/core/util/text"
+)
+
+// runCommand - run cmd with the lines of input on its standard input, the lines of its standard output are
+// returned, progress is called with the number of lines written so far
+func runCommand(ctx context.Context, cmd string, input *text.Text, progress func(done int)) (text.Text, error) {
+	p := exec.CommandContext(ctx, "sh", "-c", cmd)
+	var stdout, stderr bytes.Buffer
+	p.Stdout, p.Stderr = &stdout, &stderr
+	if input != nil {
+		stdin, err := p.StdinPipe()
+		if err != nil {
+			return text.Text{}, err
+		}
+		go func() {
+			defer stdin.Close()
+			w := bufio.NewWriter(stdin)
+			input.IterBytes(func(i int, line []byte) bool {
+				if progress != nil {
+					progress(i)
+				}
+				_, _ = w.Write(line)
+				return w.WriteByte('\n') == nil
+			})
+			_ = w.Flush()
+		}()
+	}
+	if err := p.Run(); err != nil {
+		if msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); len(msg) > 0 {
+			return text.Text{}, fmt.Errorf("%w: %s", err, msg)
+		}
+		return text.Text{}, err
+	}
+	return text.MakeTextFromBytes(stdout.Bytes()), nil
+}
+
+// commandTaskWithoutLock - run cmd in the background, ESC kills it, apply is called with its output
+// macros wait for the command
+func (c *Editor) commandTaskWithoutLock(cmd string, input *text.Text, apply func(out text.Text)) {
+	total := 0
+	if input != nil {
+		total = input.Len()
+	}
+	fail := func(err error) {
+		c.failWithoutLock(fmt.Sprintf("error run %s: %s", cmd, err.Error()))
+	}
+	if len(c.state.playing) > 0 {
+		out, err := runCommand(context.Background(), cmd, input, nil)
+		if err != nil {
+			fail(err)
+			return
+		}
+		apply(out)
+		return
+	}
+	c.runTaskWithoutLock("!"+cmd, total, func(ctx context.Context, progress func(done int)) func() {
+		out, err := runCommand(ctx, cmd, input, progress)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return func() {
+			if err != nil {
+				fail(err)
+				return
+			}
+			apply(out)
+		}
+	})
+}
+
+// filterLinesWithoutLock - :[range]!cmd, the lines are replaced by the output of cmd, the replacement is
+// journaled with the output so that replaying does not run cmd again and a single undo reverts it
+func (c *Editor) filterLinesWithoutLock(ex exCommand) {
+	c.enterNormalModeWithoutLock()
+	beg, end, err := c.parseRangeWithoutLock(ex.rng)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	if len(strings.TrimSpace(ex.arg)) == 0 {
+		c.failWithoutLock("empty command")
+		return
+	}
+	if !c.editableWithoutLock() {
+		return
+	}
+	t := c.e.Render().Text
+	end = min(end+1, t.Len())
+	input := text.Slice(t, beg, end)
+	c.commandTaskWithoutLock(ex.arg, &input, func(out text.Text) {
+		c.e.SetLines(beg, end-beg, out)
+		c.writeWithoutLock(fmt.Sprintf("%d lines filtered into %d lines", end-beg, out.Len()))
+	})
+}
+
+// readWithoutLock - :[line]r !cmd or :[line]r file, the output of cmd or the file is inserted below the line
+func (c *Editor) readWithoutLock(ex exCommand) {
+	c.enterNormalModeWithoutLock()
+	_, end, err := c.parseRangeWithoutLock(ex.rng)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	arg := strings.TrimSpace(ex.arg)
+	if len(arg) == 0 {
+		c.failWithoutLock("empty args")
+		return
+	}
+	if !c.editableWithoutLock() {
+		return
+	}
+	row := min(end+1, c.e.Render().Text.Len())
+	insert := func(out text.Text) {
+		if out.Len() == 0 {
+			c.writeWithoutLock("nothing to insert")
+			return
+		}
+		if t := c.e.Render().Text; row == t.Len() && row > 0 {
+			// lines are inserted above the cursor, after the last line the text goes after its end
+			c.e.Goto(row-1, t.LineLen(row-1))
+			c.e.InsertText(text.Merge(text.MakeTextFromLine([][]rune{nil}), out), false)
+		} else {
+			c.e.Goto(row, 0)
+			c.e.InsertLine(out)
+		}
+		c.e.Goto(row, 0)
+		c.writeWithoutLock(fmt.Sprintf("%d lines inserted", out.Len()))
+	}
+	if cmd, ok := strings.CutPrefix(arg, "!"); ok {
+		c.commandTaskWithoutLock(cmd, nil, insert)
+		return
+	}
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		c.failWithoutLock("error read file " + err.Error())
+		return
+	}
+	insert(text.MakeTextFromBytes(data))
+}
+
+// shellWithoutLock - :!cmd runs in the terminal while the screen is suspended, the editor lock is not held
+// meanwhile so that loading goes on, macros wait for the command
+func (c *Editor) shellWithoutLock(ex exCommand) {
+	c.enterNormalModeWithoutLock()
+	cmd := strings.TrimSpace(ex.arg)
+	if c.shell == nil {
+		c.failWithoutLock("shell is not supported")
+		return
+	}
+	if len(cmd) == 0 {
+		c.failWithoutLock("empty command")
+		return
+	}
+	done := func(err error) {
+		if err != nil {
+			c.failWithoutLock(fmt.Sprintf("error run %s: %s", cmd, err.Error()))
+			return
+		}
+		c.writeWithoutLock("ran " + cmd)
+	}
+	if len(c.state.playing) > 0 {
+		done(c.shell(cmd))
+		return
+	}
+	c.runTaskWithoutLock("!"+cmd, 0, func(ctx context.Context, progress func(done int)) func() {
+		err := c.shell(cmd)
+		return func() {
+			done(err)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package multimode_editor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"telescope/config"
+	"telescope/core/insert_editor"
+	"telescope/core/util/text"
+)
+
+// confirm - state of :s with the c flag, the match at (row, col) awaits an answer
+type confirm struct {
+	r        insert_editor.Replacement
+	re       *regexp.Regexp
+	row      int
+	col      int
+	length   int // bytes of the match
+	replaced int
+	original map[int]text.Text // lines replaced so far as they were before, by row
+}
+
+// parseSubstitute - "/pattern/replacement/flags", a delimiter in the pattern or the replacement is escaped as \/
+func parseSubstitute(s string) (pattern string, replacement string, flags string, ok bool) {
+	if !strings.HasPrefix(s, "/") {
+		return "", "", "", false
+	}
+	var parts []string
+	var b strings.Builder
+	rs := []rune(s[1:])
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case rs[i] == '\\' && i+1 < len(rs) && rs[i+1] == '/':
+			b.WriteRune('/')
+			i++
+		case rs[i] == '/' && len(parts) < 2:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteRune(rs[i])
+		}
+	}
+	parts = append(parts, b.String())
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	if strings.Trim(parts[2], "gci") != "" {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], len(parts[0]) > 0
+}
+
+// substituteWithoutLock - :[range]s/pattern/replacement/[gci], large ranges are replaced in the background
+func (c *Editor) substituteWithoutLock(spec string, s string) {
+	c.enterNormalModeWithoutLock()
+	beg, end, err := c.parseRangeWithoutLock(spec)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	pattern, replacement, flags, ok := parseSubstitute(s)
+	if !ok {
+		c.failWithoutLock("invalid substitute " + s)
+		return
+	}
+	r := insert_editor.Replacement{
+		Row:         beg,
+		EndRow:      end,
+		Pattern:     pattern,
+		Replacement: replacement,
+		Global:      strings.Contains(flags, "g"),
+		IgnoreCase:  strings.Contains(flags, "i"),
+	}
+	re, _, err := r.Compile()
+	if err != nil {
+		c.failWithoutLock(fmt.Sprintf("regexp compile error %s", err.Error()))
+		return
+	}
+	if !c.editableWithoutLock() {
+		return
+	}
+	if strings.Contains(flags, "c") {
+		c.state.confirm = &confirm{r: r, re: re, row: beg, col: 0, original: make(map[int]text.Text)}
+		c.nextConfirmWithoutLock()
+		return
+	}
+
+	t := c.e.Render().Text
+	if end-beg < config.Load().BACKGROUND_ROWS || len(c.state.playing) > 0 {
+		lines, n, _ := insert_editor.ReplaceLines(context.Background(), t, r, nil)
+		c.applyReplaceWithoutLock(r, lines, n)
+		return
+	}
+	c.runTaskWithoutLock("replace", end-beg+1, func(ctx context.Context, progress func(done int)) func() {
+		lines, n, err := insert_editor.ReplaceLines(ctx, t, r, progress)
+		if err != nil {
+			return nil
+		}
+		return func() {
+			c.applyReplaceWithoutLock(r, lines, n)
+		}
+	})
+}
+
+func (c *Editor) applyReplaceWithoutLock(r insert_editor.Replacement, lines []insert_editor.ReplacedLine, matches int) {
+	if len(lines) == 0 {
+		c.failWithoutLock("pattern not found " + r.Pattern)
+		return
+	}
+	c.e.Replace(r, lines)
+	c.writeWithoutLock(fmt.Sprintf("replaced %d matches in %d lines", matches, len(lines)))
+}
+
+// nextConfirmWithoutLock - go to the next match at or after (row, col) and ask for confirmation
+func (c *Editor) nextConfirmWithoutLock() {
+	cf := c.state.confirm
+	t := c.e.Render().Text
+	for ; cf.row <= cf.r.EndRow && cf.row < t.Len(); cf.row, cf.col = cf.row+1, 0 {
+		line := t.GetBytes(cf.row)
+		from := t.ByteCol(cf.row, cf.col)
+		for _, m := range cf.re.FindAllIndex(line, -1) {
+			if m[0] < from {
+				continue
+			}
+			cf.col, cf.length = t.RuneCol(cf.row, m[0]), m[1]-m[0]
+			c.e.Goto(cf.row, cf.col)
+			c.writeWithoutLock(fmt.Sprintf("replace with %s (y/n/a/q/l)?", cf.r.Replacement))
+			return
+		}
+	}
+	c.endConfirmWithoutLock()
+	if cf.replaced == 0 {
+		c.writeWithoutLock("no replacement")
+		return
+	}
+	c.writeWithoutLock(fmt.Sprintf("replaced %d matches", cf.replaced))
+}
+
+// previewReplaceWithoutLock - show lines replaced during the prompt, their content before is kept
+func (c *Editor) previewReplaceWithoutLock(lines []insert_editor.ReplacedLine) {
+	cf := c.state.confirm
+	t := c.e.Render().Text
+	rows, vals := make([]int, 0, len(lines)), make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		if _, ok := cf.original[line.Row]; !ok {
+			cf.original[line.Row] = text.Slice(t, line.Row, line.Row+1)
+		}
+		rows, vals = append(rows, line.Row), append(vals, line.Line)
+	}
+	c.e.Preview(func(t text.Text) text.Text {
+		return t.SetLines(rows, vals)
+	})
+}
+
+// endConfirmWithoutLock - end the prompt, the lines replaced are recorded as a single journal entry and a
+// single undo step setting the rows from the first to the last one replaced
+func (c *Editor) endConfirmWithoutLock() {
+	cf := c.state.confirm
+	c.state.confirm = nil
+	if len(cf.original) == 0 {
+		return
+	}
+	first, last := -1, -1
+	for row := range cf.original {
+		if first < 0 || row < first {
+			first = row
+		}
+		last = max(last, row)
+	}
+	view := c.e.Render()
+	lines := text.Slice(view.Text, first, last+1)
+	c.e.Preview(func(t text.Text) text.Text {
+		for row, line := range cf.original {
+			t = text.Merge(text.Slice(t, 0, row), line, text.Slice(t, row+1, t.Len()))
+		}
+		return t
+	})
+	c.e.SetLines(first, last-first+1, lines)
+	c.e.Goto(view.Cursor.Row, view.Cursor.Col)
+}
+
+// confirmTypeWithoutLock - y replaces the match, n skips it, a replaces every remaining match, q quits and l
+// replaces the match then quits, the replacements are shown at once and recorded when the prompt ends
+func (c *Editor) confirmTypeWithoutLock(ch rune) {
+	cf := c.state.confirm
+	replaceOne := func() int {
+		r := cf.r
+		r.Row, r.EndRow, r.Col, r.Count = cf.row, cf.row, cf.col, 1
+		t := c.e.Render().Text
+		lines, n, _ := insert_editor.ReplaceLines(context.Background(), t, r, nil)
+		if n == 0 {
+			return 0
+		}
+		c.previewReplaceWithoutLock(lines)
+		cf.replaced++
+		return len(lines[0].Line) - t.LineBytes(cf.row)
+	}
+	// advance - skip the match of length bytes, empty matches skip one character
+	advance := func(length int) {
+		if !cf.r.Global {
+			cf.row, cf.col = cf.row+1, 0
+			return
+		}
+		t := c.e.Render().Text
+		end := t.ByteCol(cf.row, cf.col) + length
+		cf.col = t.RuneCol(cf.row, end)
+		if length == 0 {
+			cf.col++
+		}
+		if cf.col > t.LineLen(cf.row) {
+			cf.row, cf.col = cf.row+1, 0
+		}
+	}
+	switch ch {
+	case 'y':
+		advance(cf.length + replaceOne())
+		c.nextConfirmWithoutLock()
+	case 'l':
+		replaceOne()
+		c.endConfirmWithoutLock()
+		c.writeWithoutLock(fmt.Sprintf("replaced %d matches", cf.replaced))
+	case 'n':
+		advance(cf.length)
+		c.nextConfirmWithoutLock()
+	case 'a':
+		r := cf.r
+		r.Row, r.Col = cf.row, cf.col
+		lines, n, _ := insert_editor.ReplaceLines(context.Background(), c.e.Render().Text, r, nil)
+		if len(cf.original) == 0 {
+			// nothing was replaced yet, the rest is a single replacement
+			c.state.confirm = nil
+			c.applyReplaceWithoutLock(r, lines, n)
+			return
+		}
+		c.previewReplaceWithoutLock(lines)
+		c.endConfirmWithoutLock()
+		c.writeWithoutLock(fmt.Sprintf("replaced %d matches", cf.replaced+n))
+	case 'q':
+		c.endConfirmWithoutLock()
+		c.writeWithoutLock(fmt.Sprintf("replaced %d matches", cf.replaced))
+	default:
+		c.writeWithoutLock(fmt.Sprintf("replace with %s (y/n/a/q/l)?", cf.r.Replacement))
+	}
+}
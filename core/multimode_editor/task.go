@@ -0,0 +1,67 @@
+package multimode_editor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"telescope/config"
+	"telescope/core/editor"
+	"time"
+)
+
+// task - long operation running in the background, ESC cancels it
+// keys editing the text are refused while a task is running
+type task struct {
+	name   string
+	cancel func()
+}
+
+// runTaskWithoutLock - run computes in the background with progress in Status.Background, then the
+// returned apply is called with the lock held unless the task was cancelled
+func (c *Editor) runTaskWithoutLock(name string, total int, run func(ctx context.Context, progress func(done int)) (apply func())) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.state.task = &task{name: name, cancel: cancel}
+	c.writeWithoutLock(name + " in background, ESC to cancel")
+	go func() {
+		defer cancel()
+		last := time.Time{}
+		progress := func(done int) {
+			if time.Since(last) < config.Load().LOADING_PROGRESS_INTERVAL {
+				return
+			}
+			last = time.Now()
+			c.e.Status(func(status editor.Status) editor.Status {
+				status.Background = fmt.Sprintf("%s %d%%", name, 100*done/max(total, 1))
+				return status
+			})
+		}
+		apply := run(ctx, progress)
+		c.lock(func() {
+			c.state.task = nil
+			c.e.Status(func(status editor.Status) editor.Status {
+				status.Background = ""
+				return status
+			})
+			if ctx.Err() != nil || apply == nil {
+				c.writeWithoutLock(name + " cancelled")
+				return
+			}
+			apply()
+		})
+	}()
+}
+
+// taskKeyWithoutLock - while a task is running, ESC cancels it, moves are allowed and other keys are refused
+func (c *Editor) taskKeyWithoutLock(k macroKey) bool {
+	switch {
+	case k.Name == "key_escape":
+		c.state.task.cancel()
+		c.writeWithoutLock("cancelling " + c.state.task.name)
+		return true
+	case strings.HasPrefix(k.Name, "move_"), strings.HasPrefix(k.Name, "mouse_"):
+		return false
+	default:
+		c.failWithoutLock(c.state.task.name + " in background, ESC to cancel")
+		return true
+	}
+}
@@ -0,0 +1,123 @@
+package multimode_editor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"telescope/core/util/hist"
+	"time"
+)
+
+// maxUndoLeaves - branches listed by :undolist, the newest ones
+const maxUndoLeaves = 10
+
+// undoGotoWithoutLock - go to change seq of the undo tree
+func (c *Editor) undoGotoWithoutLock(seq int) {
+	c.e.UndoGoto(seq)
+	if c.state.mode == ModeHex {
+		c.hexGotoWithoutLock(c.state.hex.Row, c.state.hex.Col)
+		c.writeWithoutLock(fmt.Sprintf("change %d", seq))
+	}
+}
+
+// undoStepsWithoutLock - g- and g+ go n changes back or forward in time whatever their branch
+func (c *Editor) undoStepsWithoutLock(n int) {
+	if !c.editableWithoutLock() {
+		return
+	}
+	changes, seq := c.e.UndoTree()
+	target := min(max(seq+n, changes[0].Seq), changes[len(changes)-1].Seq)
+	switch {
+	case target == seq && n < 0:
+		c.failWithoutLock("already at oldest change")
+	case target == seq:
+		c.failWithoutLock("already at newest change")
+	default:
+		c.undoGotoWithoutLock(target)
+	}
+}
+
+// undoTimeWithoutLock - go to the last change made before the time of the current change shifted by d
+func (c *Editor) undoTimeWithoutLock(d time.Duration) {
+	if !c.editableWithoutLock() {
+		return
+	}
+	changes, seq := c.e.UndoTree()
+	at := changes[seq-changes[0].Seq].Time.Add(d)
+	target := changes[0].Seq
+	for _, ch := range changes {
+		if ch.Time.After(at) {
+			break
+		}
+		target = ch.Seq
+	}
+	switch {
+	case target == seq && d < 0:
+		c.failWithoutLock("already at oldest change")
+	case target == seq:
+		c.failWithoutLock("already at newest change")
+	default:
+		c.undoGotoWithoutLock(target)
+	}
+}
+
+// parseUndoCount - argument of :earlier and :later, a number of changes or a duration such as 10s 5m 2h 1d
+func parseUndoCount(arg string) (steps int, d time.Duration, err error) {
+	arg = strings.TrimSpace(arg)
+	if len(arg) == 0 {
+		return 1, 0, nil
+	}
+	units := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}
+	unit, ok := units[arg[len(arg)-1]]
+	if ok {
+		arg = arg[:len(arg)-1]
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("invalid count %s", arg)
+	}
+	if ok {
+		return 0, time.Duration(n) * unit, nil
+	}
+	return n, 0, nil
+}
+
+// earlierWithoutLock - :earlier [count] and :later [count], sign is -1 for :earlier
+func (c *Editor) earlierWithoutLock(ex exCommand, sign int) {
+	c.enterNormalModeWithoutLock()
+	steps, d, err := parseUndoCount(ex.arg)
+	if err != nil {
+		c.failWithoutLock(err.Error())
+		return
+	}
+	if steps > 0 {
+		c.undoStepsWithoutLock(sign * steps)
+		return
+	}
+	c.undoTimeWithoutLock(time.Duration(sign) * d)
+}
+
+// undoListWithoutLock - :undolist, the last change of every branch with its number of changes and its time
+func (c *Editor) undoListWithoutLock() {
+	c.enterNormalModeWithoutLock()
+	changes, seq := c.e.UndoTree()
+	parents := make(map[int]bool, len(changes))
+	for _, ch := range changes {
+		parents[ch.Parent] = true
+	}
+	var leaves []hist.Change
+	for _, ch := range changes {
+		if !parents[ch.Seq] && ch.Seq > 0 {
+			leaves = append(leaves, ch)
+		}
+	}
+	if len(leaves) == 0 {
+		c.writeWithoutLock("no changes")
+		return
+	}
+	parts := make([]string, 0, maxUndoLeaves)
+	for _, ch := range leaves[max(0, len(leaves)-maxUndoLeaves):] {
+		parts = append(parts, fmt.Sprintf("%d (%d changes, %s)", ch.Seq, ch.Depth, ch.Time.Format(time.TimeOnly)))
+	}
+	c.writeWithoutLock(fmt.Sprintf("change %d, branches %s", seq, strings.Join(parts, " ")))
+}
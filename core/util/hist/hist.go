@@ -2,41 +2,138 @@ package hist
 
 import (
 	"telescope/config"
+	"time"
 )
 
 func New[T any](t T) *Hist[T] {
 	return &Hist[T]{
-		latest: 0,
-		stack:  []T{t},
+		base:    0,
+		latest:  0,
+		changes: []change[T]{{val: t, parent: -1, next: -1, time: time.Now()}},
 	}
 }
 
+// Hist - undo tree, every version is a change numbered in the order it was made, the first is 0
+// an update after an undo starts a new branch, older branches are kept
 type Hist[T any] struct {
-	latest uint64 // latest version
-	stack  []T    // all versions
+	base    int         // number of changes[0], the oldest changes are dropped
+	latest  int         // number of the current change
+	changes []change[T] // all versions in the order they were made
+}
+
+type change[T any] struct {
+	val    T
+	parent int // number of the change it was made on, -1 if none, dropped if less than base
+	next   int // number of the child redo goes to, the last one made or visited, -1 if none
+	time   time.Time
+	stamp  int // set by the owner to tell how up to date val is, copied from the parent
+}
+
+// Change - a version of the undo tree
+type Change struct {
+	Seq    int // number of the change, in the order the changes were made
+	Parent int // -1 for the oldest change of a branch
+	Depth  int // number of changes from the oldest change
+	Time   time.Time
+}
+
+func (h *Hist[T]) get(seq int) *change[T] {
+	if seq < h.base || seq >= h.base+len(h.changes) {
+		return nil
+	}
+	return &h.changes[seq-h.base]
 }
 
 func (h *Hist[T]) Update(modifier func(T) T) {
-	next := modifier(h.stack[h.latest])
-	h.stack = append(h.stack[:h.latest+1], next)
-	h.latest++
-	if len(h.stack) > config.Load().MAXSIZE_HISTORY_STACK {
-		h.stack = h.stack[1:]
-		h.latest--
+	next := modifier(h.Get())
+	seq := h.base + len(h.changes)
+	h.get(h.latest).next = seq
+	stamp := h.get(h.latest).stamp
+	h.changes = append(h.changes, change[T]{val: next, parent: h.latest, next: -1, time: time.Now(), stamp: stamp})
+	h.latest = seq
+	if len(h.changes) > config.Load().MAXSIZE_HISTORY_STACK {
+		// children of the oldest change become the oldest changes of their branches
+		h.changes = h.changes[1:]
+		h.base++
 	}
 }
 
+// Map - apply f to every version
+func (h *Hist[T]) Map(f func(T) T) {
+	for i := range h.changes {
+		h.changes[i].val = f(h.changes[i].val)
+	}
+}
+
+// MapStamped - apply f to every version and its stamp, the stamp is replaced by the one returned
+func (h *Hist[T]) MapStamped(f func(val T, stamp int) (T, int)) {
+	for i := range h.changes {
+		h.changes[i].val, h.changes[i].stamp = f(h.changes[i].val, h.changes[i].stamp)
+	}
+}
+
+// Restamp - replace the current version and its stamp without making a change
+func (h *Hist[T]) Restamp(f func(val T, stamp int) (T, int)) {
+	c := h.get(h.latest)
+	c.val, c.stamp = f(c.val, c.stamp)
+}
+
 func (h *Hist[T]) Get() T {
-	return h.stack[h.latest]
+	return h.get(h.latest).val
+}
+
+// Undo - go to the parent of the current change, false if there is none
+func (h *Hist[T]) Undo() bool {
+	parent := h.get(h.latest).parent
+	if !h.Has(parent) {
+		return false
+	}
+	h.get(parent).next = h.latest
+	h.latest = parent
+	return true
+}
+
+// Redo - go to the child of the current change made or visited last, false if there is none
+func (h *Hist[T]) Redo() bool {
+	next := h.get(h.latest).next
+	if next < 0 {
+		return false
+	}
+	h.latest = next
+	return true
 }
-func (h *Hist[T]) Undo() {
-	if h.latest > 0 {
-		h.latest--
+
+// Seq - number of the current change
+func (h *Hist[T]) Seq() int {
+	return h.latest
+}
+
+// Has - change seq is kept
+func (h *Hist[T]) Has(seq int) bool {
+	return h.get(seq) != nil
+}
+
+// Goto - go to change seq in any branch, redo then follows the path to seq, false if seq was dropped
+func (h *Hist[T]) Goto(seq int) bool {
+	if !h.Has(seq) {
+		return false
+	}
+	h.latest = seq
+	for c := seq; h.Has(h.get(c).parent); c = h.get(c).parent {
+		h.get(h.get(c).parent).next = c
 	}
+	return true
 }
 
-func (h *Hist[T]) Redo() {
-	if int(h.latest) < len(h.stack)-1 {
-		h.latest++
+// Changes - every change kept, in the order they were made
+func (h *Hist[T]) Changes() []Change {
+	changes := make([]Change, len(h.changes))
+	for i, c := range h.changes {
+		parent, depth := -1, 0
+		if h.Has(c.parent) {
+			parent, depth = c.parent, changes[c.parent-h.base].Depth+1
+		}
+		changes[i] = Change{Seq: h.base + i, Parent: parent, Depth: depth, Time: c.time}
 	}
+	return changes
 }
@@ -0,0 +1,170 @@
+package hist
+
+import (
+	"telescope/config"
+	"testing"
+)
+
+func push(h *Hist[string], s string) {
+	h.Update(func(string) string {
+		return s
+	})
+}
+
+func depths(h *Hist[string]) map[int][2]int {
+	m := make(map[int][2]int)
+	for _, c := range h.Changes() {
+		m[c.Seq] = [2]int{c.Parent, c.Depth}
+	}
+	return m
+}
+
+func TestUndoRedo(t *testing.T) {
+	h := New("a")
+	push(h, "b")
+	push(h, "c")
+	if !h.Undo() || h.Get() != "b" || !h.Undo() || h.Get() != "a" {
+		t.Fatal("undo")
+	}
+	if h.Undo() || h.Get() != "a" {
+		t.Fatal("undo past the oldest change")
+	}
+	if !h.Redo() || h.Get() != "b" || !h.Redo() || h.Get() != "c" {
+		t.Fatal("redo")
+	}
+	if h.Redo() || h.Seq() != 2 {
+		t.Fatal("redo past the newest change")
+	}
+}
+
+func TestUpdateAfterUndoBranches(t *testing.T) {
+	h := New("a")
+	push(h, "b")
+	push(h, "c")
+	h.Undo()
+	push(h, "d") // change 3 made on change 1, change 2 is kept on the other branch
+	if h.Seq() != 3 || h.Get() != "d" {
+		t.Fatalf("seq %d val %q", h.Seq(), h.Get())
+	}
+	if !h.Undo() || h.Get() != "b" {
+		t.Fatal("undo to the branch point")
+	}
+	// redo follows the branch made last
+	if !h.Redo() || h.Get() != "d" {
+		t.Fatal("redo to the new branch")
+	}
+	if !h.Has(2) {
+		t.Fatal("old branch dropped")
+	}
+	expected := map[int][2]int{0: {-1, 0}, 1: {0, 1}, 2: {1, 2}, 3: {1, 2}}
+	for seq, d := range depths(h) {
+		if expected[seq] != d {
+			t.Fatalf("change %d has parent and depth %v, expected %v", seq, d, expected[seq])
+		}
+	}
+}
+
+func TestGotoRewiresNext(t *testing.T) {
+	h := New("a")
+	push(h, "b")
+	push(h, "c")
+	h.Undo()
+	h.Undo()
+	push(h, "d")
+	push(h, "e") // branches 0-1-2 and 0-3-4
+	if !h.Goto(2) || h.Get() != "c" {
+		t.Fatal("goto")
+	}
+	// redo from the root follows the path to the change gone to
+	h.Undo()
+	h.Undo()
+	if h.Get() != "a" || !h.Redo() || h.Get() != "b" || !h.Redo() || h.Get() != "c" {
+		t.Fatal("redo after goto does not follow the path to the change gone to")
+	}
+	if !h.Goto(0) || !h.Redo() || h.Get() != "b" {
+		t.Fatal("goto an ancestor keeps the path")
+	}
+	if !h.Goto(4) || !h.Goto(0) || !h.Redo() || h.Get() != "d" {
+		t.Fatal("goto the other branch")
+	}
+	if h.Goto(5) || h.Goto(-1) || h.Seq() != 3 {
+		t.Fatal("goto a missing change")
+	}
+}
+
+func TestDropBase(t *testing.T) {
+	size := config.Load().MAXSIZE_HISTORY_STACK
+	config.Load().MAXSIZE_HISTORY_STACK = 3
+	defer func() {
+		config.Load().MAXSIZE_HISTORY_STACK = size
+	}()
+	h := New("a")
+	push(h, "b")
+	push(h, "c")
+	h.Undo()
+	push(h, "d") // changes 0 1 2 3, change 0 is dropped
+	if h.Has(0) || !h.Has(1) || !h.Has(2) || !h.Has(3) {
+		t.Fatal("oldest change not dropped")
+	}
+	if h.Goto(0) {
+		t.Fatal("goto a dropped change")
+	}
+	h.Undo()
+	if h.Undo() || h.Get() != "b" {
+		t.Fatal("undo past the oldest change kept")
+	}
+	push(h, "e") // change 1 is dropped, changes 2 3 4 are the oldest of their branches
+	expected := map[int][2]int{2: {-1, 0}, 3: {-1, 0}, 4: {-1, 0}}
+	for seq, d := range depths(h) {
+		if expected[seq] != d {
+			t.Fatalf("change %d has parent and depth %v, expected %v", seq, d, expected[seq])
+		}
+	}
+	if len(h.Changes()) != 3 || h.Undo() {
+		t.Fatal("oldest changes of branches")
+	}
+	if !h.Goto(2) || h.Get() != "c" || h.Undo() {
+		t.Fatal("goto a change whose parent was dropped")
+	}
+}
+
+func TestChangesDepth(t *testing.T) {
+	h := New("a")
+	for _, s := range []string{"b", "c", "d"} {
+		push(h, s)
+	}
+	h.Goto(1)
+	push(h, "e")
+	push(h, "f")
+	expected := map[int][2]int{0: {-1, 0}, 1: {0, 1}, 2: {1, 2}, 3: {2, 3}, 4: {1, 2}, 5: {4, 3}}
+	changes := h.Changes()
+	if len(changes) != len(expected) {
+		t.Fatalf("%d changes, expected %d", len(changes), len(expected))
+	}
+	for i, c := range changes {
+		if c.Seq != i || expected[c.Seq] != [2]int{c.Parent, c.Depth} {
+			t.Fatalf("change %d has parent %d depth %d, expected %v", c.Seq, c.Parent, c.Depth, expected[c.Seq])
+		}
+	}
+}
+
+func TestStamp(t *testing.T) {
+	h := New("a")
+	h.Restamp(func(val string, stamp int) (string, int) {
+		return val + "1", 1
+	})
+	push(h, "b")
+	h.Undo()
+	h.Restamp(func(val string, stamp int) (string, int) {
+		return val + "2", 2
+	})
+	stamps := map[string]int{}
+	h.MapStamped(func(val string, stamp int) (string, int) {
+		stamps[val] = stamp
+		return val, stamp
+	})
+	// a change is made with the stamp of its parent
+	if len(stamps) != 2 || stamps["a12"] != 2 || stamps["b"] != 1 {
+		t.Fatalf("stamps %v", stamps)
+	}
+}
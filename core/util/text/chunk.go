@@ -0,0 +1,219 @@
+package text
+
+import (
+	"slices"
+	"telescope/util/buffer"
+	"unicode/utf8"
+)
+
+// chunk - a piece of a gigantic line, either a range of the reader or in memory
+// chunks other than the first one never start with a utf-8 continuation byte so that rune counts add up
+type chunk struct {
+	offset int64  // offset in the reader, -1 if in memory
+	data   []byte // in-memory chunk, never modified
+	bytes  int
+	runes  int
+}
+
+func (c chunk) repr(reader buffer.Reader) []byte {
+	if c.offset < 0 {
+		return c.data
+	}
+	buf := make([]byte, c.bytes) // bytes after the end of a truncated reader are left as zeros
+	buffer.Read(reader, buf, int(c.offset))
+	return buf
+}
+
+func makeMemChunk(data []byte) chunk {
+	return chunk{offset: -1, data: data, bytes: len(data), runes: utf8.RuneCount(data)}
+}
+
+// chunkEnd - end of the chunk starting at beg, moved forward so that the next chunk does not start
+// inside a utf-8 sequence
+func chunkEnd(beg int, n int, size int, at func(i int) byte) int {
+	end := min(beg+size, n)
+	for k := 0; k < utf8.UTFMax && end < n && !utf8.RuneStart(at(end)); k++ {
+		end++
+	}
+	return end
+}
+
+// makeReaderChunks - split [beg, end) of reader into chunks of about size bytes
+func makeReaderChunks(reader buffer.Reader, beg int, end int, size int) *ropeNode {
+	var root *ropeNode = nil
+	at := func(i int) byte {
+		return reader.At(beg + i)
+	}
+	n := end - beg
+	buf := make([]byte, 0, size+utf8.UTFMax)
+	for s := 0; s < n; {
+		e := chunkEnd(s, n, size, at)
+		buf = buf[:0]
+		for i := s; i < e; i++ {
+			buf = append(buf, at(i))
+		}
+		root = ropeIns(root, ropeWeight(root), chunk{
+			offset: int64(beg + s),
+			data:   nil,
+			bytes:  e - s,
+			runes:  utf8.RuneCount(buf),
+		})
+		s = e
+	}
+	return root
+}
+
+// makeMemChunks - split data into chunks of about size bytes, chunks share data
+func makeMemChunks(data []byte, size int) *ropeNode {
+	var root *ropeNode = nil
+	at := func(i int) byte {
+		return data[i]
+	}
+	for s := 0; s < len(data); {
+		e := chunkEnd(s, len(data), size, at)
+		root = ropeIns(root, ropeWeight(root), makeMemChunk(data[s:e:e]))
+		s = e
+	}
+	return root
+}
+
+// chunksRepr - bytes [beg, end) of the chunks
+func chunksRepr(root *ropeNode, reader buffer.Reader, beg int, end int) []byte {
+	end = min(end, ropeBytes(root))
+	if beg >= end {
+		return nil
+	}
+	buf := make([]byte, 0, end-beg)
+	i, _, pos := ropeLocate(root, beg, false)
+	for ; i < ropeWeight(root) && pos < end; i++ {
+		c := ropeGet(root, i)
+		data := c.repr(reader)
+		lo, hi := max(beg-pos, 0), min(end-pos, c.bytes)
+		buf = append(buf, data[lo:hi]...)
+		pos += c.bytes
+	}
+	return buf
+}
+
+// chunksRunes - runes [beg, end) of the chunks
+func chunksRunes(root *ropeNode, reader buffer.Reader, beg int, end int) []rune {
+	end = min(end, ropeRunes(root))
+	if beg >= end {
+		return nil
+	}
+	rs := make([]rune, 0, end-beg)
+	i, pos, _ := ropeLocate(root, beg, true)
+	for ; i < ropeWeight(root) && pos < end; i++ {
+		c := ropeGet(root, i)
+		chunkRunes := bytesToRunes(c.repr(reader))
+		lo, hi := max(beg-pos, 0), min(end-pos, c.runes)
+		rs = append(rs, chunkRunes[lo:hi]...)
+		pos += c.runes
+	}
+	return rs
+}
+
+// chunksByteCol - byte offset of rune col
+func chunksByteCol(root *ropeNode, reader buffer.Reader, col int) int {
+	if col >= ropeRunes(root) {
+		return ropeBytes(root)
+	}
+	i, runePos, bytePos := ropeLocate(root, col, true)
+	data := ropeGet(root, i).repr(reader)
+	return bytePos + runeToByteOffset(data, col-runePos)
+}
+
+// chunksRuneCol - rune col of byte offset
+func chunksRuneCol(root *ropeNode, reader buffer.Reader, offset int) int {
+	if offset >= ropeBytes(root) {
+		return ropeRunes(root)
+	}
+	i, runePos, bytePos := ropeLocate(root, offset, false)
+	data := ropeGet(root, i).repr(reader)
+	return runePos + utf8.RuneCount(data[:offset-bytePos])
+}
+
+// chunksEdit - replace [offset, offset+del) by ins inside a single chunk, the chunk is split if it grows
+// beyond 2 * size, empty chunks are removed
+func chunksEdit(root *ropeNode, reader buffer.Reader, offset int, del int, ins []byte, size int) *ropeNode {
+	if ropeWeight(root) == 0 {
+		return makeMemChunks(slices.Clone(ins), size)
+	}
+	i, _, pos := ropeLocate(root, offset, false)
+	if i == ropeWeight(root) {
+		// append to the last chunk
+		i--
+		pos -= ropeGet(root, i).bytes
+	}
+	data := ropeGet(root, i).repr(reader)
+	local := offset - pos
+	if local+del > len(data) {
+		del = len(data) - local // edits never span chunks
+	}
+	newData := make([]byte, 0, len(data)-del+len(ins))
+	newData = append(newData, data[:local]...)
+	newData = append(newData, ins...)
+	newData = append(newData, data[local+del:]...)
+
+	root = ropeDel(root, i)
+	if len(newData) > 2*size {
+		for j, c := range ropeIterChunks(makeMemChunks(newData, size)) {
+			root = ropeIns(root, i+j, c)
+		}
+	} else if len(newData) > 0 {
+		root = ropeIns(root, i, makeMemChunk(newData))
+	}
+	return chunksFixStart(root, reader, i)
+}
+
+// chunksFixStart - move the leading continuation bytes of chunk i to the end of chunk i-1
+func chunksFixStart(root *ropeNode, reader buffer.Reader, i int) *ropeNode {
+	if i <= 0 || i >= ropeWeight(root) {
+		return root
+	}
+	data := ropeGet(root, i).repr(reader)
+	k := 0
+	for k < len(data) && !utf8.RuneStart(data[k]) {
+		k++
+	}
+	if k == 0 {
+		return root
+	}
+	prev := ropeGet(root, i-1).repr(reader)
+	root = ropeDel(ropeDel(root, i), i-1)
+	root = ropeIns(root, i-1, makeMemChunk(slices.Concat(prev, data[:k])))
+	if k < len(data) {
+		root = ropeIns(root, i, makeMemChunk(data[k:]))
+	}
+	return root
+}
+
+// chunksJoin - chunks of l followed by chunks of r
+func chunksJoin(l *ropeNode, r *ropeNode, reader buffer.Reader) *ropeNode {
+	return chunksFixStart(ropeMerge(l, r), reader, ropeWeight(l))
+}
+
+// chunksSplit - split chunks at byte offset
+func chunksSplit(root *ropeNode, reader buffer.Reader, offset int) (*ropeNode, *ropeNode) {
+	if offset >= ropeBytes(root) {
+		return root, nil
+	}
+	i, _, pos := ropeLocate(root, offset, false)
+	left, right := ropeSplit(root, i)
+	if local := offset - pos; local > 0 {
+		data := ropeGet(right, 0).repr(reader)
+		right = ropeDel(right, 0)
+		left = ropeIns(left, ropeWeight(left), makeMemChunk(data[:local:local]))
+		right = ropeIns(right, 0, makeMemChunk(data[local:]))
+	}
+	return left, right
+}
+
+func runeToByteOffset(data []byte, col int) int {
+	i := 0
+	for ; col > 0 && i < len(data); col-- {
+		_, size := utf8.DecodeRune(data[i:])
+		i += size
+	}
+	return i
+}
@@ -1,19 +1,34 @@
 package text
 
-import "telescope/util/buffer"
+import (
+	"bytes"
+	"math"
+	"slices"
+	"telescope/config"
+	"telescope/util/buffer"
+)
 
 const delim byte = '\n'
 
 // Line - if offset >= 0, this is a file else this is a []byte buffer
+// gigantic lines are stored as chunks so that reading or editing a part of them touches only a few chunks
 type Line struct {
-	offset int64   // 8 bytes
-	data   *[]byte // 8 bytes on 64-bit system
+	offset int64     // 8 bytes
+	data   *lineData // 8 bytes on 64-bit system
+}
+
+type lineData struct {
+	bytes  []byte    // in-memory line
+	chunks *ropeNode // gigantic line, bytes is nil
 }
 
 func MakeLineFromData(data []byte) Line {
+	if len(data) > config.Load().LINE_CHUNK_THRESHOLD {
+		return makeChunkedLine(nil, -1, makeMemChunks(data, config.Load().LINE_CHUNK_SIZE))
+	}
 	return Line{
 		offset: -1,
-		data:   &data,
+		data:   &lineData{bytes: data},
 	}
 }
 
@@ -24,24 +39,168 @@ func MakeLineFromOffset(offset int) Line {
 	}
 }
 
+// MakeLineFromReader - line [beg, end) of reader, gigantic lines are split into chunks
+func MakeLineFromReader(reader buffer.Reader, beg int, end int) Line {
+	if end-beg > config.Load().LINE_CHUNK_THRESHOLD {
+		return makeChunkedLine(reader, int64(beg), makeReaderChunks(reader, beg, end, config.Load().LINE_CHUNK_SIZE))
+	}
+	return MakeLineFromOffset(beg)
+}
+
+// makeChunkedLine - edited lines shrinking below half of the threshold are stored in memory again
+func makeChunkedLine(reader buffer.Reader, offset int64, chunks *ropeNode) Line {
+	if ropeBytes(chunks) <= config.Load().LINE_CHUNK_THRESHOLD/2 {
+		return Line{
+			offset: -1,
+			data:   &lineData{bytes: chunksRepr(chunks, reader, 0, ropeBytes(chunks))},
+		}
+	}
+	return Line{
+		offset: offset,
+		data:   &lineData{chunks: chunks},
+	}
+}
+
 func (l Line) Offset() int64 {
 	return l.offset
 }
 
+func (l Line) chunked() bool {
+	return l.data != nil && l.data.chunks != nil
+}
+
 func (l Line) Repr(reader buffer.Reader) []byte {
+	if l.chunked() {
+		return chunksRepr(l.data.chunks, reader, 0, ropeBytes(l.data.chunks))
+	}
 	if l.offset < 0 {
 		// in-memory
-		return *l.data
+		return l.data.bytes
 	} else {
 		// from file
-		buf := make([]byte, 0)
-		for i := int(l.offset); i < reader.Len(); i++ {
-			b := reader.At(i)
-			if b == delim {
-				break
-			}
-			buf = append(buf, b)
+		return readLine(reader, int(l.offset), math.MaxInt)
+	}
+}
+
+// readLine - the line at offset of reader, at most limit bytes, read in blocks growing with the line
+func readLine(reader buffer.Reader, offset int, limit int) []byte {
+	buf := make([]byte, 0)
+	block := make([]byte, 256)
+	for len(buf) < limit {
+		want := min(len(block), limit-len(buf))
+		n := buffer.Read(reader, block[:want], offset+len(buf))
+		if i := bytes.IndexByte(block[:n], delim); i >= 0 {
+			return append(buf, block[:i]...)
 		}
-		return buf
+		buf = append(buf, block[:n]...)
+		if n < want {
+			break // end of reader
+		}
+		if len(block) < config.Load().LINE_CHUNK_SIZE {
+			block = make([]byte, 2*len(block))
+		}
+	}
+	return buf
+}
+
+// toChunks - chunks of the line, a small line becomes a single chunk
+func (l Line) toChunks(reader buffer.Reader) *ropeNode {
+	if l.chunked() {
+		return l.data.chunks
+	}
+	return makeMemChunks(l.Repr(reader), config.Load().LINE_CHUNK_SIZE)
+}
+
+// the functions below read or edit a part of the line, only the affected chunks are touched if the line is
+// chunked
+
+func (l Line) runeLen(reader buffer.Reader) int {
+	if l.chunked() {
+		return ropeRunes(l.data.chunks)
+	}
+	return len(bytesToRunes(l.Repr(reader)))
+}
+
+func (l Line) byteLen(reader buffer.Reader) int {
+	if l.chunked() {
+		return ropeBytes(l.data.chunks)
+	}
+	return len(l.Repr(reader))
+}
+
+func (l Line) runes(reader buffer.Reader, beg int, end int) []rune {
+	if l.chunked() {
+		return chunksRunes(l.data.chunks, reader, beg, end)
+	}
+	rs := bytesToRunes(l.Repr(reader))
+	end = min(end, len(rs))
+	if beg >= end {
+		return nil
+	}
+	return rs[beg:end]
+}
+
+func (l Line) bytes(reader buffer.Reader, beg int, end int) []byte {
+	if l.chunked() {
+		return chunksRepr(l.data.chunks, reader, beg, end)
+	}
+	if l.offset >= 0 {
+		// from file, read until end
+		buf := readLine(reader, int(l.offset), end)
+		if beg >= len(buf) {
+			return nil
+		}
+		return buf[beg:]
+	}
+	bs := l.Repr(reader)
+	end = min(end, len(bs))
+	if beg >= end {
+		return nil
+	}
+	return bs[beg:end]
+}
+
+func (l Line) byteCol(reader buffer.Reader, col int) int {
+	if l.chunked() {
+		return chunksByteCol(l.data.chunks, reader, col)
+	}
+	return runeToByteOffset(l.Repr(reader), col)
+}
+
+func (l Line) runeCol(reader buffer.Reader, offset int) int {
+	if l.chunked() {
+		return chunksRuneCol(l.data.chunks, reader, offset)
+	}
+	bs := l.Repr(reader)
+	return len(bytesToRunes(bs[:min(offset, len(bs))]))
+}
+
+// edit - replace bytes [offset, offset+del) by ins, ins must not contain delim
+func (l Line) edit(reader buffer.Reader, offset int, del int, ins []byte) Line {
+	if l.chunked() {
+		return makeChunkedLine(reader, -1, chunksEdit(l.data.chunks, reader, offset, del, ins, config.Load().LINE_CHUNK_SIZE))
+	}
+	bs := l.Repr(reader)
+	offset = min(offset, len(bs))
+	del = min(del, len(bs)-offset)
+	return MakeLineFromData(slices.Concat(bs[:offset], ins, bs[offset+del:]))
+}
+
+// split - bytes [0, offset) and [offset, len)
+func (l Line) split(reader buffer.Reader, offset int) (Line, Line) {
+	if l.chunked() {
+		left, right := chunksSplit(l.data.chunks, reader, offset)
+		return makeChunkedLine(reader, -1, left), makeChunkedLine(reader, -1, right)
+	}
+	bs := l.Repr(reader)
+	offset = min(offset, len(bs))
+	return MakeLineFromData(slices.Clone(bs[:offset])), MakeLineFromData(slices.Clone(bs[offset:]))
+}
+
+// join - l followed by l2
+func (l Line) join(reader buffer.Reader, l2 Line) Line {
+	if !l.chunked() && !l2.chunked() {
+		return MakeLineFromData(slices.Concat(l.Repr(reader), l2.Repr(reader)))
 	}
+	return makeChunkedLine(reader, -1, chunksJoin(l.toChunks(reader), l2.toChunks(reader), reader))
 }
@@ -5,20 +5,60 @@ import (
 	"telescope/util/buffer"
 )
 
+// indexBlockSize - bytes read from the reader at once while indexing
+const indexBlockSize = 64 * 1024
+
 func IndexFile(reader buffer.Reader) iter.Seq[int] {
+	return NewIndexer().Index(reader)
+}
+
+// Indexer - index lines of a reader that might grow over time, every line offset is yielded once
+// the last line is yielded even if it is incomplete since its content is read until delim anyway
+// indexing stops before bytes that cannot be read and goes on from there on the next call
+type Indexer struct {
+	pos     int  // next byte to scan
+	offset  int  // offset of the current line
+	yielded bool // whether the current line was yielded
+}
+
+func NewIndexer() *Indexer {
+	return &Indexer{
+		pos:     0,
+		offset:  0,
+		yielded: false,
+	}
+}
+
+// Pos - number of bytes indexed so far
+func (x *Indexer) Pos() int {
+	return x.pos
+}
+
+// Index - yield offsets of lines from the last indexed byte to the end of reader
+func (x *Indexer) Index(reader buffer.Reader) iter.Seq[int] {
 	return func(yield func(offset int) bool) {
-		offset := 0
-		for i := 0; i < reader.Len(); i++ {
-			b := reader.At(i)
-			if b == delim {
-				if !yield(offset) {
-					return
+		block := make([]byte, indexBlockSize)
+		for x.pos < reader.Len() {
+			want := min(len(block), reader.Len()-x.pos)
+			n := buffer.Read(reader, block[:want], x.pos)
+			for _, b := range block[:n] {
+				if b == delim {
+					offset, yielded := x.offset, x.yielded
+					x.offset, x.yielded = x.pos+1, false
+					if !yielded && !yield(offset) {
+						x.pos++
+						return
+					}
 				}
-				offset = i + 1
+				x.pos++
+			}
+			if n < want {
+				break // the reader was truncated or failed, e.g. a fetch, nothing after is indexed
 			}
 		}
-		if offset < reader.Len() {
-			yield(offset)
+		if x.pos >= reader.Len() && x.offset < reader.Len() && !x.yielded {
+			x.yielded = true
+			yield(x.offset)
 		}
 	}
 }
@@ -0,0 +1,228 @@
+package text
+
+import "iter"
+
+// ropeNode - persistent sequence of chunks, a weight-balanced tree as seq.Seq
+// every node also keeps the number of runes and bytes of its subtree so that a position in a gigantic
+// line is located in O(log n)
+type ropeNode struct {
+	weight int
+	height int
+	runes  int
+	bytes  int
+	entry  chunk
+	left   *ropeNode
+	right  *ropeNode
+}
+
+const ropeDelta = 3
+
+func ropeWeight(n *ropeNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.weight
+}
+
+func ropeHeight(n *ropeNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func ropeRunes(n *ropeNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.runes
+}
+
+func ropeBytes(n *ropeNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.bytes
+}
+
+func makeRopeNode(entry chunk, left *ropeNode, right *ropeNode) *ropeNode {
+	return &ropeNode{
+		weight: 1 + ropeWeight(left) + ropeWeight(right),
+		height: 1 + max(ropeHeight(left), ropeHeight(right)),
+		runes:  entry.runes + ropeRunes(left) + ropeRunes(right),
+		bytes:  entry.bytes + ropeBytes(left) + ropeBytes(right),
+		entry:  entry,
+		left:   left,
+		right:  right,
+	}
+}
+
+func ropeGet(n *ropeNode, i int) chunk {
+	for n != nil {
+		switch {
+		case i < ropeWeight(n.left):
+			n = n.left
+		case i == ropeWeight(n.left):
+			return n.entry
+		default:
+			i -= ropeWeight(n.left) + 1
+			n = n.right
+		}
+	}
+	panic("index out of range")
+}
+
+// ropeLocate - index of the chunk containing pos and the number of runes and bytes before it
+// pos is a rune position if byRunes else a byte position, the number of chunks is returned if pos is
+// at or after the end
+func ropeLocate(n *ropeNode, pos int, byRunes bool) (index int, runesBefore int, bytesBefore int) {
+	measure := func(n *ropeNode) int {
+		if byRunes {
+			return ropeRunes(n)
+		}
+		return ropeBytes(n)
+	}
+	for n != nil {
+		size := n.entry.bytes
+		if byRunes {
+			size = n.entry.runes
+		}
+		switch {
+		case pos < measure(n.left):
+			n = n.left
+		case pos < measure(n.left)+size:
+			return index + ropeWeight(n.left), runesBefore + ropeRunes(n.left), bytesBefore + ropeBytes(n.left)
+		default:
+			pos -= measure(n.left) + size
+			index += ropeWeight(n.left) + 1
+			runesBefore += ropeRunes(n.left) + n.entry.runes
+			bytesBefore += ropeBytes(n.left) + n.entry.bytes
+			n = n.right
+		}
+	}
+	return index, runesBefore, bytesBefore
+}
+
+func ropeIterChunks(n *ropeNode) iter.Seq2[int, chunk] {
+	return func(yield func(int, chunk) bool) {
+		i := 0
+		var walk func(n *ropeNode) bool
+		walk = func(n *ropeNode) bool {
+			if n == nil {
+				return true
+			}
+			if !walk(n.left) {
+				return false
+			}
+			if !yield(i, n.entry) {
+				return false
+			}
+			i++
+			return walk(n.right)
+		}
+		walk(n)
+	}
+}
+
+func ropeBalance(n *ropeNode) *ropeNode {
+	// assuming both n.left and n.right are balance, then this return a balanced tree
+	if n == nil {
+		return nil
+	}
+	if ropeWeight(n.left)+ropeWeight(n.right) <= 1 {
+		return n
+	}
+	if ropeWeight(n.left) > ropeDelta*ropeWeight(n.right) {
+		// right rotate
+		l, r := n.left, n.right
+		ll, lr := l.left, l.right
+		n1 := ropeBalance(makeRopeNode(n.entry, lr, r))
+		return makeRopeNode(l.entry, ll, n1)
+	} else if ropeDelta*ropeWeight(n.left) < ropeWeight(n.right) {
+		// left rotate
+		l, r := n.left, n.right
+		rl, rr := r.left, r.right
+		n1 := ropeBalance(makeRopeNode(n.entry, l, rl))
+		return makeRopeNode(r.entry, n1, rr)
+	}
+	return n
+}
+
+func ropeIns(n *ropeNode, i int, entry chunk) *ropeNode {
+	if n == nil && i == 0 {
+		return makeRopeNode(entry, nil, nil)
+	}
+	if n == nil || i < 0 || i > n.weight {
+		panic("index out of range")
+	}
+	if i <= ropeWeight(n.left) {
+		return ropeBalance(makeRopeNode(n.entry, ropeIns(n.left, i, entry), n.right))
+	}
+	return ropeBalance(makeRopeNode(n.entry, n.left, ropeIns(n.right, i-(ropeWeight(n.left)+1), entry)))
+}
+
+func ropeDel(n *ropeNode, i int) *ropeNode {
+	if n == nil || i < 0 || i >= n.weight {
+		panic("index out of range")
+	}
+	if i < ropeWeight(n.left) {
+		return ropeBalance(makeRopeNode(n.entry, ropeDel(n.left, i), n.right))
+	}
+	if i == ropeWeight(n.left) {
+		if n.right == nil {
+			return n.left
+		}
+		entry := ropeGet(n.right, 0)
+		return ropeBalance(makeRopeNode(entry, n.left, ropeDel(n.right, 0)))
+	}
+	return ropeBalance(makeRopeNode(n.entry, n.left, ropeDel(n.right, i-(ropeWeight(n.left)+1))))
+}
+
+// ropeSplit - chunks [0, i) and [i, n)
+func ropeSplit(n *ropeNode, i int) (*ropeNode, *ropeNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if i <= 0 {
+		return nil, n
+	}
+	if i < ropeWeight(n.left) {
+		ll, lr := ropeSplit(n.left, i)
+		return ll, ropeJoin(lr, n.entry, n.right)
+	}
+	if i == ropeWeight(n.left) {
+		return n.left, ropeIns(n.right, 0, n.entry)
+	}
+	if i < n.weight {
+		rl, rr := ropeSplit(n.right, i-(ropeWeight(n.left)+1))
+		return ropeJoin(n.left, n.entry, rl), rr
+	}
+	return n, nil
+}
+
+func ropeMerge(l *ropeNode, r *ropeNode) *ropeNode {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if l.weight > r.weight {
+		entry := ropeGet(l, l.weight-1)
+		return ropeJoin(ropeDel(l, l.weight-1), entry, r)
+	}
+	entry := ropeGet(r, 0)
+	return ropeJoin(l, entry, ropeDel(r, 0))
+}
+
+// ropeJoin - l, entry, r where every chunk of l is before every chunk of r
+func ropeJoin(l *ropeNode, entry chunk, r *ropeNode) *ropeNode {
+	switch {
+	case ropeWeight(l) > ropeDelta*ropeWeight(r)+1:
+		return ropeBalance(makeRopeNode(l.entry, l.left, ropeJoin(l.right, entry, r)))
+	case ropeWeight(r) > ropeDelta*ropeWeight(l)+1:
+		return ropeBalance(makeRopeNode(r.entry, ropeJoin(l, entry, r.left), r.right))
+	default:
+		return ropeBalance(makeRopeNode(entry, l, r))
+	}
+}
@@ -0,0 +1,280 @@
+package text
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"strings"
+	"telescope/util/buffer"
+	"testing"
+	"unicode/utf8"
+)
+
+// memRope - rope of in-memory chunks, one per piece
+func memRope(pieces ...string) *ropeNode {
+	var root *ropeNode
+	for _, p := range pieces {
+		root = ropeIns(root, ropeWeight(root), makeMemChunk([]byte(p)))
+	}
+	return root
+}
+
+func ropePieces(root *ropeNode, reader buffer.Reader) []string {
+	var pieces []string
+	for _, c := range ropeIterChunks(root) {
+		pieces = append(pieces, string(c.repr(reader)))
+	}
+	return pieces
+}
+
+// checkRope - sizes kept by the nodes add up and the height is logarithmic
+func checkRope(t *testing.T, n *ropeNode, reader buffer.Reader) {
+	t.Helper()
+	var walk func(n *ropeNode)
+	walk = func(n *ropeNode) {
+		if n == nil {
+			return
+		}
+		walk(n.left)
+		walk(n.right)
+		data := n.entry.repr(reader)
+		if n.entry.bytes != len(data) || n.entry.runes != utf8.RuneCount(data) {
+			t.Fatalf("chunk %q has %d bytes and %d runes", data, n.entry.bytes, n.entry.runes)
+		}
+		if n.weight != 1+ropeWeight(n.left)+ropeWeight(n.right) ||
+			n.runes != n.entry.runes+ropeRunes(n.left)+ropeRunes(n.right) ||
+			n.bytes != n.entry.bytes+ropeBytes(n.left)+ropeBytes(n.right) {
+			t.Fatal("node sizes do not add up")
+		}
+		if n.height != 1+max(ropeHeight(n.left), ropeHeight(n.right)) {
+			t.Fatal("node height is wrong")
+		}
+	}
+	walk(n)
+	// single rotations keep the tree within a constant factor of the optimal height
+	if h, w := ropeHeight(n), ropeWeight(n); h > 2*bits.Len(uint(w)) {
+		t.Fatalf("height %d for %d chunks", h, w)
+	}
+}
+
+// checkChunks - chunks are not empty and no rune is split between two chunks so that the rune counts of the
+// chunks add up to the runes of the line
+func checkChunks(t *testing.T, root *ropeNode, reader buffer.Reader) {
+	t.Helper()
+	checkRope(t, root, reader)
+	line := chunksRepr(root, reader, 0, ropeBytes(root))
+	if ropeRunes(root) != utf8.RuneCount(line) {
+		t.Fatalf("%d runes in %q", ropeRunes(root), line)
+	}
+	if got := string(chunksRunes(root, reader, 0, ropeRunes(root))); got != string([]rune(string(line))) {
+		t.Fatalf("runes %q of %q", got, line)
+	}
+	for i, c := range ropeIterChunks(root) {
+		if c.bytes == 0 {
+			t.Fatalf("empty chunk %d", i)
+		}
+	}
+}
+
+func TestRopeSplitJoin(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 5, 17, 100} {
+		pieces := make([]string, n)
+		for i := range pieces {
+			pieces[i] = fmt.Sprintf("<%d>", i)
+		}
+		root := memRope(pieces...)
+		for i := 0; i <= n; i++ {
+			l, r := ropeSplit(root, i)
+			checkRope(t, l, nil)
+			checkRope(t, r, nil)
+			if got := strings.Join(ropePieces(l, nil), ""); got != strings.Join(pieces[:i], "") {
+				t.Fatalf("split %d chunks at %d, left %q", n, i, got)
+			}
+			if got := strings.Join(ropePieces(r, nil), ""); got != strings.Join(pieces[i:], "") {
+				t.Fatalf("split %d chunks at %d, right %q", n, i, got)
+			}
+			joined := ropeJoin(l, makeMemChunk([]byte("|")), r)
+			checkRope(t, joined, nil)
+			want := strings.Join(pieces[:i], "") + "|" + strings.Join(pieces[i:], "")
+			if got := strings.Join(ropePieces(joined, nil), ""); got != want {
+				t.Fatalf("join %d chunks at %d: %q", n, i, got)
+			}
+			merged := ropeMerge(l, r)
+			checkRope(t, merged, nil)
+			if got := strings.Join(ropePieces(merged, nil), ""); got != strings.Join(pieces, "") {
+				t.Fatalf("merge %d chunks at %d: %q", n, i, got)
+			}
+		}
+	}
+}
+
+func TestRopeJoinUneven(t *testing.T) {
+	tests := []struct {
+		left  int
+		right int
+	}{
+		{0, 0}, {0, 1}, {1, 0}, {0, 50}, {50, 0}, {1, 50}, {50, 1}, {7, 64}, {64, 7}, {30, 30},
+	}
+	for _, test := range tests {
+		var l, r []string
+		for i := 0; i < test.left; i++ {
+			l = append(l, fmt.Sprintf("l%d ", i))
+		}
+		for i := 0; i < test.right; i++ {
+			r = append(r, fmt.Sprintf("r%d ", i))
+		}
+		joined := ropeJoin(memRope(l...), makeMemChunk([]byte("| ")), memRope(r...))
+		checkRope(t, joined, nil)
+		want := strings.Join(l, "") + "| " + strings.Join(r, "")
+		if got := strings.Join(ropePieces(joined, nil), ""); got != want {
+			t.Fatalf("join %d and %d chunks: %q", test.left, test.right, got)
+		}
+	}
+}
+
+func TestRopeLocate(t *testing.T) {
+	// "ab" is 2 bytes and 2 runes, "é€" is 5 bytes and 2 runes, "\xffx" is 2 bytes and 2 runes
+	root := memRope("ab", "é€", "\xffx")
+	tests := []struct {
+		pos     int
+		byRunes bool
+		index   int
+		runes   int
+		bytes   int
+	}{
+		{0, true, 0, 0, 0},
+		{1, true, 0, 0, 0},
+		{2, true, 1, 2, 2},
+		{3, true, 1, 2, 2},
+		{4, true, 2, 4, 7},
+		{5, true, 2, 4, 7},
+		{6, true, 3, 6, 9},
+		{100, true, 3, 6, 9},
+		{0, false, 0, 0, 0},
+		{2, false, 1, 2, 2},
+		{4, false, 1, 2, 2},
+		{6, false, 1, 2, 2},
+		{7, false, 2, 4, 7},
+		{8, false, 2, 4, 7},
+		{9, false, 3, 6, 9},
+		{100, false, 3, 6, 9},
+	}
+	for _, test := range tests {
+		index, runes, bytes := ropeLocate(root, test.pos, test.byRunes)
+		if index != test.index || runes != test.runes || bytes != test.bytes {
+			t.Errorf("locate %d (runes %v) = %d, %d, %d, want %d, %d, %d",
+				test.pos, test.byRunes, index, runes, bytes, test.index, test.runes, test.bytes)
+		}
+	}
+	if index, runes, bytes := ropeLocate(nil, 0, true); index != 0 || runes != 0 || bytes != 0 {
+		t.Error("locate in an empty rope")
+	}
+}
+
+func TestChunksEdit(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		size   int
+		offset int
+		del    int
+		ins    string
+		want   string
+	}{
+		{"insert in the middle", "abcdefgh", 4, 2, 0, "XY", "abXYcdefgh"},
+		{"insert at a chunk boundary", "abcdefgh", 4, 4, 0, "XY", "abcdXYefgh"},
+		{"append", "abcdefgh", 4, 8, 0, "XY", "abcdefghXY"},
+		{"insert at the start", "abcdefgh", 4, 0, 0, "XY", "XYabcdefgh"},
+		{"delete a whole chunk", "abcdefgh", 4, 4, 4, "", "abcd"},
+		{"delete the first chunk", "abcdefgh", 4, 0, 4, "", "efgh"},
+		{"delete is kept in its chunk", "abcdefgh", 4, 2, 4, "", "abefgh"},
+		{"replace", "abcdefgh", 4, 5, 2, "XYZ", "abcdeXYZh"},
+		{"chunk grows beyond twice the size", "abcdefgh", 4, 1, 0, "0123456789", "a0123456789bcdefgh"},
+		{"into an empty line", "", 4, 0, 0, "héllo wörld", "héllo wörld"},
+		{"multi-byte runes around the edit", "éééééé", 4, 4, 0, "€", "éé€éééé"},
+		{"multi-byte rune at a boundary", "aaaa€bbb", 4, 4, 0, "é", "aaaaé€bbb"},
+		{"delete a multi-byte rune", "aaé€bb", 4, 2, 2, "", "aa€bb"},
+		{"grow into multi-byte runes", "€€€€", 3, 3, 0, "ééééé", "€ééééé€€€"},
+		{"invalid utf-8 is kept", "ab\xffcd\xfe", 3, 3, 0, "\x80", "ab\xff\x80cd\xfe"},
+		{"lone continuation bytes", "\x80\x80\x80\x80\x80\x80\x80\x80", 2, 4, 1, "é", "\x80\x80\x80\x80é\x80\x80\x80"},
+		{"truncated rune at the end", "abcd\xe2\x82", 4, 6, 0, "\xac", "abcd\xe2\x82\xac"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := makeMemChunks([]byte(test.data), test.size)
+			checkChunks(t, root, nil)
+			edited := chunksEdit(root, nil, test.offset, test.del, []byte(test.ins), test.size)
+			checkChunks(t, edited, nil)
+			if got := chunksRepr(edited, nil, 0, ropeBytes(edited)); string(got) != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+			for _, c := range ropeIterChunks(edited) {
+				if c.bytes > 2*test.size+utf8.UTFMax {
+					t.Fatalf("chunk of %d bytes", c.bytes)
+				}
+			}
+			// the rope edited is persistent
+			if got := chunksRepr(root, nil, 0, ropeBytes(root)); string(got) != test.data {
+				t.Fatalf("original changed to %q", got)
+			}
+		})
+	}
+}
+
+func TestChunksEditReader(t *testing.T) {
+	data := []byte(strings.Repeat("aé€\xff", 50))
+	reader := buffer.NewMemReader(data)
+	root := makeReaderChunks(reader, 0, len(data), 16)
+	checkChunks(t, root, reader)
+	want := data
+	for _, offset := range []int{0, 15, 16, 17, 100, len(data) - 1} {
+		root = chunksEdit(root, reader, offset, 0, []byte("€"), 16)
+		want = append(want[:offset:offset], append([]byte("€"), want[offset:]...)...)
+		checkChunks(t, root, reader)
+		if got := chunksRepr(root, reader, 0, ropeBytes(root)); !bytes.Equal(got, want) {
+			t.Fatalf("insert at %d: got %q", offset, got)
+		}
+	}
+}
+
+func TestChunksSplitJoin(t *testing.T) {
+	data := "héllo wörld, ça va? \xff€€"
+	root := makeMemChunks([]byte(data), 4)
+	for offset := 0; offset <= len(data); offset++ {
+		l, r := chunksSplit(root, nil, offset)
+		checkRope(t, l, nil)
+		checkRope(t, r, nil)
+		if got := string(chunksRepr(l, nil, 0, ropeBytes(l))); got != data[:offset] {
+			t.Fatalf("split at %d, left %q", offset, got)
+		}
+		if got := string(chunksRepr(r, nil, 0, ropeBytes(r))); got != data[offset:] {
+			t.Fatalf("split at %d, right %q", offset, got)
+		}
+		joined := chunksJoin(l, r, nil)
+		checkChunks(t, joined, nil)
+		if got := string(chunksRepr(joined, nil, 0, ropeBytes(joined))); got != data {
+			t.Fatalf("join at %d: %q", offset, got)
+		}
+	}
+}
+
+func TestChunksCols(t *testing.T) {
+	data := "aé€\xffb€"
+	root := makeMemChunks([]byte(data), 2)
+	col := 0
+	for offset := range data {
+		if got := chunksByteCol(root, nil, col); got != offset {
+			t.Fatalf("byte of rune %d is %d, want %d", col, got, offset)
+		}
+		if got := chunksRuneCol(root, nil, offset); got != col {
+			t.Fatalf("rune of byte %d is %d, want %d", offset, got, col)
+		}
+		col++
+	}
+	if chunksByteCol(root, nil, col) != len(data) || chunksRuneCol(root, nil, len(data)) != col {
+		t.Fatal("columns at the end")
+	}
+	if got := string(chunksRunes(root, nil, 1, 4)); got != "é€�" {
+		t.Fatalf("runes 1 to 4: %q", got)
+	}
+}
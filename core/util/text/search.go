@@ -0,0 +1,204 @@
+package text
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+	"telescope/config"
+	"telescope/util/buffer"
+)
+
+const (
+	searchRunGap   = 64 * 1024   // rows further apart in the reader are read separately, the lines in between were deleted
+	searchRunBlock = 1024 * 1024 // rows of a run start within a block, only the last line may read past it
+)
+
+// Hit - a match at byte offsets [Beg, End) of line Row
+type Hit struct {
+	Row int
+	Beg int
+	End int
+}
+
+// SearchRows - search re in rows [beg, end) in parallel, rows are split into blocks of SEARCH_BLOCK_ROWS
+// hits of each block are given to yield in row order, blocks are given in increasing order or in decreasing
+// order if backward, done is the number of rows searched so far
+// searching stops when yield returns false or when ctx is done
+func (t Text) SearchRows(ctx context.Context, re *regexp.Regexp, beg int, end int, backward bool, yield func(hits []Hit, done int) bool) error {
+	beg, end = max(beg, 0), min(end, t.Len())
+	if beg >= end {
+		return ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait() // workers stop at the next row
+	}()
+
+	size := max(config.Load().SEARCH_BLOCK_ROWS, 1)
+	workers := max(config.Load().SEARCH_WORKERS, 1)
+	n := (end - beg + size - 1) / size
+	block := func(k int) (int, int) {
+		if backward {
+			k = n - 1 - k
+		}
+		return beg + k*size, min(beg+(k+1)*size, end)
+	}
+
+	results := make([]chan []Hit, n)
+	for k := range results {
+		results[k] = make(chan []Hit, 1)
+	}
+	jobs := make(chan int)
+	ahead := make(chan struct{}, 2*workers) // blocks searched ahead of yield
+	go func() {
+		defer close(jobs)
+		for k := 0; k < n; k++ {
+			select {
+			case ahead <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- k:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				b, e := block(k)
+				results[k] <- t.searchBlock(ctx, re, b, e)
+			}
+		}()
+	}
+
+	done := 0
+	for k := 0; k < n; k++ {
+		var hits []Hit
+		select {
+		case hits = <-results[k]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err() // the block might be incomplete
+		}
+		<-ahead
+		b, e := block(k)
+		done += e - b
+		if !yield(hits, done) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// searchBlock - hits of rows [beg, end) in row order
+// consecutive file-backed rows are read from the reader at once and searched without copying each line
+// a run ends at a large gap, e.g. deleted lines, and at a block so that its buffer stays small
+func (t Text) searchBlock(ctx context.Context, re *regexp.Regexp, beg int, end int) []Hit {
+	var hits []Hit
+	var run []int64 // offsets of consecutive file-backed rows
+	runBeg := beg
+	flush := func() {
+		if len(run) > 0 {
+			hits = t.searchReader(re, runBeg, run, hits)
+			run = run[:0]
+		}
+	}
+	t.lines.Slice(beg, end).Iter(func(i int, l Line) bool {
+		row := beg + i
+		if i%1024 == 0 && ctx.Err() != nil {
+			return false
+		}
+		if l.offset >= 0 && !l.chunked() && (len(run) == 0 || extendsRun(run, l.offset)) {
+			if len(run) == 0 {
+				runBeg = row
+			}
+			run = append(run, l.offset)
+			return true
+		}
+		flush()
+		if l.offset >= 0 && !l.chunked() {
+			runBeg, run = row, append(run, l.offset)
+			return true
+		}
+		for _, m := range re.FindAllIndex(l.Repr(t.reader), -1) {
+			hits = append(hits, Hit{Row: row, Beg: m[0], End: m[1]})
+		}
+		return true
+	})
+	flush()
+	return hits
+}
+
+// extendsRun - the line at offset follows the last line of run closely and starts within the block of run
+// the gap is measured from the start of the last line since its end is not known before reading it
+func extendsRun(run []int64, offset int64) bool {
+	last := run[len(run)-1]
+	return offset > last && offset-last <= searchRunGap && offset-run[0] < searchRunBlock
+}
+
+// searchReader - hits of rows beg, beg+1, ... whose lines start at offsets in the reader
+// bytes from the first offset to the end of the last line are read at once, lines in between that are not in
+// offsets were deleted and are skipped, a literal prefix of re is looked up in the whole range first
+func (t Text) searchReader(re *regexp.Regexp, beg int, offsets []int64, hits []Hit) []Hit {
+	base := int(offsets[0])
+	last := int(offsets[len(offsets)-1])
+	end := last
+	for end < t.reader.Len() && t.reader.At(end) != delim {
+		end++
+	}
+	buf := make([]byte, end-base)
+	buf = buf[:buffer.Read(t.reader, buf, base)]
+
+	// rowOf - row of the line starting at pos in buf, -1 if the line was deleted
+	rowOf := func(pos int) int {
+		k := sort.Search(len(offsets), func(k int) bool {
+			return int(offsets[k]) >= base+pos
+		})
+		if k < len(offsets) && int(offsets[k]) == base+pos {
+			return beg + k
+		}
+		return -1
+	}
+	searchLine := func(pos int) int {
+		lineEnd := bytes.IndexByte(buf[pos:], delim)
+		if lineEnd < 0 {
+			lineEnd = len(buf)
+		} else {
+			lineEnd += pos
+		}
+		if row := rowOf(pos); row >= 0 {
+			for _, m := range re.FindAllIndex(buf[pos:lineEnd], -1) {
+				hits = append(hits, Hit{Row: row, Beg: m[0], End: m[1]})
+			}
+		}
+		return lineEnd + 1
+	}
+
+	prefix, _ := re.LiteralPrefix()
+	if len(prefix) == 0 {
+		for pos := 0; pos <= len(buf); {
+			pos = searchLine(pos)
+		}
+		return hits
+	}
+	// only lines containing the prefix are searched
+	for pos := 0; pos < len(buf); {
+		i := bytes.Index(buf[pos:], []byte(prefix))
+		if i < 0 {
+			break
+		}
+		pos = searchLine(bytes.LastIndexByte(buf[:pos+i], delim) + 1)
+	}
+	return hits
+}
@@ -15,39 +15,261 @@ func New(reader buffer.Reader) Text {
 }
 
 type Text struct {
-	reader buffer.Reader
-	lines  seq.Seq[Line]
+	reader         buffer.Reader
+	lines          seq.Seq[Line]
+	noFinalNewline bool // the last line has no line break, it is kept by edits and on write
 }
 
 func (t Text) Get(i int) []rune {
 	return bytesToRunes(t.lines.Get(i).Repr(t.reader))
 }
 
+// GetBytes - line i as stored, invalid utf-8 is kept as is
+func (t Text) GetBytes(i int) []byte {
+	return t.lines.Get(i).Repr(t.reader)
+}
+
+// Offset - offset of line i in the reader, -1 if the line is in memory
+func (t Text) Offset(i int) int64 {
+	return t.lines.Get(i).Offset()
+}
+
+// Search - the first line at or after offset in the reader, lines in memory are skipped
+// file-backed lines are assumed to be in order as they are loaded
+// probes skip the lines in memory one by one, callers asking again for the same text keep the result, see SameLines
+func (t Text) Search(offset int64) int {
+	beg, end := 0, t.Len()
+	for beg < end {
+		mid := (beg + end) / 2
+		i := mid
+		for i < end && t.Offset(i) < 0 {
+			i++
+		}
+		if i == end {
+			end = mid
+		} else if t.Offset(i) < offset {
+			beg = i + 1
+		} else {
+			end = mid
+		}
+	}
+	return beg
+}
+
+// SameLines - t and other are the same version of the lines, results computed from one hold for the other
+func (t Text) SameLines(other Text) bool {
+	return t.lines == other.lines
+}
+
+// LineLen - number of runes of line i
+func (t Text) LineLen(i int) int {
+	return t.lines.Get(i).runeLen(t.reader)
+}
+
+// LineBytes - number of bytes of line i
+func (t Text) LineBytes(i int) int {
+	return t.lines.Get(i).byteLen(t.reader)
+}
+
+// GetRange - runes [beg, end) of line i, only the chunks in range are read for gigantic lines
+func (t Text) GetRange(i int, beg int, end int) []rune {
+	return t.lines.Get(i).runes(t.reader, beg, end)
+}
+
+// GetBytesRange - bytes [beg, end) of line i
+func (t Text) GetBytesRange(i int, beg int, end int) []byte {
+	return t.lines.Get(i).bytes(t.reader, beg, end)
+}
+
+// ByteCol - byte offset of rune col in line i
+func (t Text) ByteCol(i int, col int) int {
+	return t.lines.Get(i).byteCol(t.reader, col)
+}
+
+// RuneCol - rune col of byte offset in line i, each invalid byte is one rune
+func (t Text) RuneCol(i int, offset int) int {
+	return t.lines.Get(i).runeCol(t.reader, offset)
+}
+
+// InsBytesAt - insert b at byte offset of line i, b must not contain a line break
+func (t Text) InsBytesAt(i int, offset int, b []byte) Text {
+	return Text{
+		reader:         t.reader,
+		lines:          t.lines.Set(i, t.lines.Get(i).edit(t.reader, offset, 0, b)),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// DelBytesAt - delete n bytes from byte offset of line i
+func (t Text) DelBytesAt(i int, offset int, n int) Text {
+	return Text{
+		reader:         t.reader,
+		lines:          t.lines.Set(i, t.lines.Get(i).edit(t.reader, offset, n, nil)),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// SplitLine - split line i at byte offset, line i is kept as is if offset is at the end
+func (t Text) SplitLine(i int, offset int) Text {
+	l := t.lines.Get(i)
+	if offset >= l.byteLen(t.reader) {
+		return t.InsBytes(i+1, nil)
+	}
+	l1, l2 := l.split(t.reader, offset)
+	return Text{
+		reader:         t.reader,
+		lines:          t.lines.Set(i, l1).Ins(i+1, l2),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// JoinLine - join line i with line i+1
+func (t Text) JoinLine(i int) Text {
+	l := t.lines.Get(i).join(t.reader, t.lines.Get(i+1))
+	return Text{
+		reader:         t.reader,
+		lines:          t.lines.Set(i, l).Del(i + 1),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// ChunkLine - line i stored as chunks, false unless it is a gigantic line read from the reader and not chunked yet
+// used for the last line once the reader stops growing
+func (t Text) ChunkLine(i int) (Line, bool) {
+	l := t.lines.Get(i)
+	if l.offset < 0 || l.chunked() {
+		return Line{}, false
+	}
+	end := int(l.offset)
+	for end < t.reader.Len() && t.reader.At(end) != delim {
+		end++
+	}
+	chunked := MakeLineFromReader(t.reader, int(l.offset), end)
+	return chunked, chunked.chunked()
+}
+
+// SetChunkedLine - replace the last line by l made by ChunkLine if it is the same line not chunked yet
+func (t Text) SetChunkedLine(l Line) Text {
+	if t.Len() == 0 {
+		return t
+	}
+	last := t.lines.Get(t.Len() - 1)
+	if last.offset != l.offset || last.chunked() {
+		return t
+	}
+	return Text{
+		reader:         t.reader,
+		lines:          t.lines.Set(t.Len()-1, l),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
 func (t Text) Set(i int, val []rune) Text {
+	return Text{
+		reader:         t.reader,
+		lines:          t.lines.Set(i, MakeLineFromData(runesToBytes(val))),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// SetBytes - set line i without utf-8 conversion
+func (t Text) SetBytes(i int, val []byte) Text {
+	return Text{
+		reader:         t.reader,
+		lines:          t.lines.Set(i, MakeLineFromData(val)),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// SetLines - set line rows[i] to vals[i] without utf-8 conversion, rows are increasing
+// the lines from the first to the last row are rebuilt at once if many lines are set
+func (t Text) SetLines(rows []int, vals [][]byte) Text {
+	if len(rows) < 64 {
+		for i, row := range rows {
+			t = t.SetBytes(row, vals[i])
+		}
+		return t
+	}
+	beg, end := rows[0], rows[len(rows)-1]+1
+	lines := t.lines.Slice(beg, end).Repr()
+	for i, row := range rows {
+		lines[row-beg] = MakeLineFromData(vals[i])
+	}
+	return Text{
+		reader:         t.reader,
+		lines:          seq.Merge(t.lines.Slice(0, beg), seq.FromSlice(lines), t.lines.Slice(end, t.Len())),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// DelLines - delete lines rows, rows are increasing
+// the lines from the first to the last row are rebuilt at once if many lines are deleted
+func (t Text) DelLines(rows []int) Text {
+	if len(rows) < 64 {
+		for i := len(rows) - 1; i >= 0; i-- {
+			t = t.Del(rows[i])
+		}
+		return t
+	}
+	beg, end := rows[0], rows[len(rows)-1]+1
+	lines := t.lines.Slice(beg, end).Repr()
+	kept := lines[:0]
+	for i, j := 0, 0; i < len(lines); i++ {
+		if j < len(rows) && rows[j] == beg+i {
+			j++
+			continue
+		}
+		kept = append(kept, lines[i])
+	}
+	return Text{
+		reader:         t.reader,
+		lines:          seq.Merge(t.lines.Slice(0, beg), seq.FromSlice(kept), t.lines.Slice(end, t.Len())),
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// PickLines - text of lines rows in order
+func (t Text) PickLines(rows []int) Text {
+	lines := make([]Line, len(rows))
+	for i, row := range rows {
+		lines[i] = t.lines.Get(row)
+	}
 	return Text{
 		reader: t.reader,
-		lines:  t.lines.Set(i, MakeLineFromData(runesToBytes(val))),
+		lines:  seq.FromSlice(lines),
+	}
+}
+
+// InsBytes - insert a line without utf-8 conversion
+func (t Text) InsBytes(i int, val []byte) Text {
+	return Text{
+		reader:         t.reader,
+		lines:          t.lines.Ins(i, MakeLineFromData(val)),
+		noFinalNewline: t.noFinalNewline,
 	}
 }
 
 func (t Text) Ins(i int, val []rune) Text {
 	return Text{
-		reader: t.reader,
-		lines:  t.lines.Ins(i, MakeLineFromData(runesToBytes(val))),
+		reader:         t.reader,
+		lines:          t.lines.Ins(i, MakeLineFromData(runesToBytes(val))),
+		noFinalNewline: t.noFinalNewline,
 	}
 }
 
 func (t Text) Append(line Line) Text {
 	return Text{
-		reader: t.reader,
-		lines:  t.lines.Ins(t.lines.Len(), line),
+		reader:         t.reader,
+		lines:          t.lines.Ins(t.lines.Len(), line),
+		noFinalNewline: t.noFinalNewline,
 	}
 }
 
 func (t Text) Del(i int) Text {
 	return Text{
-		reader: t.reader,
-		lines:  t.lines.Del(i),
+		reader:         t.reader,
+		lines:          t.lines.Del(i),
+		noFinalNewline: t.noFinalNewline,
 	}
 }
 
@@ -57,6 +279,34 @@ func (t Text) Iter(f func(i int, val []rune) bool) {
 	})
 }
 
+// IterBytes - iterate lines as stored, invalid utf-8 is kept as is
+func (t Text) IterBytes(f func(i int, val []byte) bool) {
+	t.lines.Iter(func(i int, l Line) bool {
+		return f(i, l.Repr(t.reader))
+	})
+}
+
+// Rebase - the same lines read from another reader
+// every file-backed line of t must have a valid offset in reader
+func (t Text) Rebase(reader buffer.Reader) Text {
+	return Text{
+		reader:         reader,
+		lines:          t.lines,
+		noFinalNewline: t.noFinalNewline,
+	}
+}
+
+// NoFinalNewline - the last line has no line break
+func (t Text) NoFinalNewline() bool {
+	return t.noFinalNewline
+}
+
+// SetNoFinalNewline - whether the last line has a line break
+func (t Text) SetNoFinalNewline(noFinalNewline bool) Text {
+	t.noFinalNewline = noFinalNewline
+	return t
+}
+
 func (t Text) Len() int {
 	return t.lines.Len()
 }
@@ -69,13 +319,16 @@ func (t Text) Repr() [][]rune {
 	return text
 }
 
+// Slice - lines [beg, end), the last line has no line break only if it is the last line of t
 func Slice(t Text, beg int, end int) Text {
 	return Text{
-		reader: t.reader,
-		lines:  t.lines.Slice(beg, end),
+		reader:         t.reader,
+		lines:          t.lines.Slice(beg, end),
+		noFinalNewline: t.noFinalNewline && end == t.Len(),
 	}
 }
 
+// Merge - lines of ts in order, the last text tells whether the last line has a line break
 func Merge(ts ...Text) Text {
 	if len(ts) == 0 {
 		side_channel.Panic("cannot merge empty text")
@@ -94,8 +347,9 @@ func Merge(ts ...Text) Text {
 			}
 		}
 		t = Text{
-			reader: reader,
-			lines:  seq.Merge(t.lines, t1.lines),
+			reader:         reader,
+			lines:          seq.Merge(t.lines, t1.lines),
+			noFinalNewline: t1.noFinalNewline,
 		}
 	}
 	return t
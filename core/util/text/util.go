@@ -1,6 +1,7 @@
 package text
 
 import (
+	"bytes"
 	"telescope/util/persistent/seq"
 )
 
@@ -15,6 +16,23 @@ func MakeTextFromLine(lines [][]rune) Text {
 	}
 }
 
+// MakeTextFromBytes - lines of data separated by \n, a final \n does not start another line
+func MakeTextFromBytes(data []byte) Text {
+	var lines []Line
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			i = len(data)
+		}
+		lines = append(lines, MakeLineFromData(data[:i:i]))
+		data = data[min(i+1, len(data)):]
+	}
+	return Text{
+		reader: nil,
+		lines:  seq.FromSlice(lines),
+	}
+}
+
 func runesToBytes(rs []rune) (bs []byte) {
 	return []byte(string(rs))
 }
@@ -5,11 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
 	"telescope/config"
 	"telescope/core/editor"
 	"telescope/core/multimode_editor"
+	"telescope/core/util/text"
 	"time"
+	"unicode/utf8"
 
 	"telescope/util/side_channel"
 
@@ -35,6 +42,24 @@ func getModeAndCommand(m map[string]any) (string, string) {
 	return mode, command
 }
 
+func getReadonlyAndWarning(m map[string]any) (bool, string) {
+	if m == nil {
+		return false, ""
+	}
+	readonly, _ := m["readonly"].(bool)
+	warning, _ := m["warning"].(string)
+	return readonly, warning
+}
+
+// getRecording - register of the macro being recorded, empty if not recording
+func getRecording(m map[string]any) string {
+	if m == nil {
+		return ""
+	}
+	reg, _ := m["recording"].(string)
+	return reg
+}
+
 func getSelector(m map[string]any) *multimode_editor.Selector {
 	if m == nil {
 		return nil
@@ -53,6 +78,50 @@ func getSelector(m map[string]any) *multimode_editor.Selector {
 	return selector
 }
 
+// getSearch - regexp of the last search if its matches are highlighted
+func getSearch(m map[string]any) *regexp.Regexp {
+	if m == nil {
+		return nil
+	}
+	re, _ := m["search"].(*regexp.Regexp)
+	return re
+}
+
+// searchMargin - long lines are searched only around the visible part, matches are at most that long
+const searchMargin = 1024
+
+// getMatches - whether each visible column of the row is in a match of re
+func getMatches(t text.Text, row int, tlCol int, width int, re *regexp.Regexp) []bool {
+	if re == nil || row >= t.Len() {
+		return nil
+	}
+	var line []byte
+	offset := 0 // rune column of line[0]
+	if t.LineBytes(row) <= 64*1024 {
+		line = t.GetBytes(row)
+	} else {
+		offset = max(0, tlCol-searchMargin)
+		line = []byte(string(t.GetRange(row, offset, tlCol+width+searchMargin)))
+	}
+	var matched []bool
+	col, last := offset, 0
+	for _, m := range re.FindAllIndex(line, -1) {
+		col += utf8.RuneCount(line[last:m[0]])
+		end := col + utf8.RuneCount(line[m[0]:m[1]])
+		for c := max(col, tlCol); c < min(end, tlCol+width); c++ {
+			if matched == nil {
+				matched = make([]bool, width)
+			}
+			matched[c-tlCol] = true
+		}
+		col, last = end, m[1]
+		if col >= tlCol+width {
+			break
+		}
+	}
+	return matched
+}
+
 func getStatusStyle(mode string) tcell.Style {
 	switch mode {
 	case multimode_editor.ModeNormal:
@@ -63,48 +132,92 @@ func getStatusStyle(mode string) tcell.Style {
 		return tcell.StyleDefault.Background(tcell.ColorLightGreen).Foreground(tcell.ColorBlack)
 	case multimode_editor.ModeCommand:
 		return tcell.StyleDefault.Background(tcell.ColorLightBlue).Foreground(tcell.ColorBlack)
+	case multimode_editor.ModeHex:
+		return tcell.StyleDefault.Background(tcell.ColorPlum).Foreground(tcell.ColorBlack)
 	default:
 		return tcell.StyleDefault.Background(tcell.ColorLightGray).Foreground(tcell.ColorBlack)
 	}
 }
 
-func getTextStyle(textRow int, selector *multimode_editor.Selector) tcell.Style {
-	if selector != nil {
-		beg, end := selector.Interval()
-		if beg <= textRow && textRow <= end {
+func getWarningStyle() tcell.Style {
+	return tcell.StyleDefault.Background(tcell.ColorRed).Foreground(tcell.ColorWhite)
+}
+
+// getTextStyle - style of the cell at (textRow, textCol), lineLen is the length of the line
+// a character or block selection is highlighted only up to the line break
+func getTextStyle(textRow int, textCol int, lineLen int, selector *multimode_editor.Selector) tcell.Style {
+	if selector != nil && selector.Contains(textRow, textCol) {
+		if selector.Kind() == multimode_editor.SelectLine || textCol <= lineLen {
 			return tcell.StyleDefault.Background(tcell.ColorLightGray).Foreground(tcell.ColorBlack)
 		}
 	}
 	return tcell.StyleDefault
 }
 
-func draw(s tcell.Screen, view editor.View) {
+func getMatchStyle() tcell.Style {
+	return tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack)
+}
+
+// draw - source is the input file and the line number of the cursor in it if the input is a concatenation
+func draw(s tcell.Screen, view editor.View, source string) {
 	s.Clear()
 	screenWidth, screenHeight := s.Size()
 	selector := getSelector(view.Status.Other)
+	hex, isHex := getHex(view.Status.Other)
+	search := getSearch(view.Status.Other)
+	grep := getGrep(view.Status.Other)
+	filter := getFilter(view.Status.Other)
+	textHeight := screenHeight - 1
+	if grep != nil {
+		textHeight -= grep.Height
+	}
 
 	// Draw cursor from (0, 0)
 	col := view.Cursor.Col - view.Window.TlCol
 	row := view.Cursor.Row - view.Window.TlRow
+	if filter != nil {
+		col, row = col+filter.Gutter, filter.Cursor
+	}
 	s.ShowCursor(col, row)
 
 	// Draw content from (0, 0) -> (screenWidth-1, screenHeight-2)
-	contentDrawContext := makeDrawContext(s, 0, 0, screenWidth, screenHeight-1)
+	contentDrawContext := makeDrawContext(s, 0, 0, screenWidth, textHeight)
 	contentDrawContext(func(width int, height int, draw drawFunc) {
+		if isHex {
+			s.ShowCursor(drawHex(width, height, draw, view, hex))
+			return
+		}
 		t := view.Text
+		if filter != nil {
+			width -= drawFilterGutter(height, draw, filter)
+			draw = shiftDraw(draw, filter.Gutter)
+		}
 		for relRow := 0; relRow < height; relRow++ {
 			row := view.Window.TlRow + relRow
-			style := getTextStyle(row, selector)
-			var line []rune = nil
+			if filter != nil {
+				row = t.Len() // past the lines shown
+				if relRow < len(filter.Rows) {
+					row = filter.Rows[relRow]
+				}
+			}
+			var line []rune = nil // visible part of the line
+			lineLen := 0
 			if row < t.Len() {
-				line = t.Get(row)
+				line = t.GetRange(row, view.Window.TlCol, view.Window.TlCol+width)
+				if selector != nil && selector.Kind() != multimode_editor.SelectLine {
+					lineLen = t.LineLen(row)
+				}
 			}
+			matched := getMatches(t, row, view.Window.TlCol, width, search)
 
 			for relCol := 0; relCol < width; relCol++ {
-				col := view.Window.TlCol + relCol
+				style := getTextStyle(row, view.Window.TlCol+relCol, lineLen, selector)
+				if matched != nil && matched[relCol] && style == tcell.StyleDefault {
+					style = getMatchStyle()
+				}
 				ch := ' '
-				if col < len(line) {
-					ch = line[col]
+				if relCol < len(line) {
+					ch = line[relCol]
 				}
 				if row >= t.Len() && relCol == 0 {
 					ch = '~' // special case
@@ -114,12 +227,27 @@ func draw(s tcell.Screen, view editor.View) {
 		}
 	})
 
+	// Draw the grep panel below the content
+	if grep != nil {
+		grepDrawContext := makeDrawContext(s, 0, textHeight, screenWidth, grep.Height)
+		grepDrawContext(func(width int, height int, draw drawFunc) {
+			y := drawGrep(width, height, draw, view, grep)
+			if mode, _ := getModeAndCommand(view.Status.Other); mode == multimode_editor.ModeGrep && y >= 0 {
+				s.ShowCursor(0, textHeight+y)
+			}
+		})
+	}
+
 	// Draw the status bar at the bottom (screenHeight-1)
 	statusDrawContext := makeDrawContext(s, 0, screenHeight-1, screenWidth, 1)
 	statusDrawContext(func(width int, height int, draw drawFunc) {
 		sep := []rune(" > ")
 		mode, command := getModeAndCommand(view.Status.Other)
+		readonly, warning := getReadonlyAndWarning(view.Status.Other)
 		style := getStatusStyle(mode)
+		if len(warning) > 0 {
+			style = getWarningStyle()
+		}
 
 		// draw bottom bar
 		for col := 0; col < width; col++ {
@@ -138,7 +266,31 @@ func draw(s tcell.Screen, view editor.View) {
 		}
 		// draw mode, cursor, command, messge
 		var fromLeft []rune
-		fromLeft = append(fromLeft, []rune(fmt.Sprintf(" %s (%d, %d)", mode, view.Cursor.Row+1, view.Cursor.Col+1))...)
+		fromLeft = append(fromLeft, []rune(" "+mode)...)
+		if selector != nil && selector.Kind() != multimode_editor.SelectLine {
+			fromLeft = append(fromLeft, []rune(" "+selector.Kind())...)
+		}
+		if readonly {
+			fromLeft = append(fromLeft, []rune(" [RO]")...)
+		}
+		if filter != nil {
+			fromLeft = append(fromLeft, []rune(fmt.Sprintf(" [filter %s: %d]", strings.Join(filter.Patterns, " "), filter.Count))...)
+		}
+		if reg := getRecording(view.Status.Other); len(reg) > 0 {
+			fromLeft = append(fromLeft, []rune(" recording @"+reg)...)
+		}
+		cursorRow, cursorCol := view.Cursor.Row, view.Cursor.Col
+		if isHex {
+			cursorRow, cursorCol = hex.Row, hex.Col // byte column
+		}
+		fromLeft = append(fromLeft, []rune(fmt.Sprintf(" (%d, %d)", cursorRow+1, cursorCol+1))...)
+		if len(source) > 0 {
+			fromLeft = append(fromLeft, []rune(" "+source)...)
+		}
+		if len(warning) > 0 {
+			fromLeft = append(fromLeft, sep...)
+			fromLeft = append(fromLeft, []rune(warning)...)
+		}
 		if len(command) > 0 {
 			fromLeft = append(fromLeft, sep...)
 			fromLeft = append(fromLeft, []rune(command)...)
@@ -180,7 +332,23 @@ func sendQuitEvent(s tcell.Screen) {
 	}
 }
 
-func RunEditor(inputFilename string, logFilename string, multiMode bool) error {
+// interruptEvent - the process was asked to stop by a signal, it stops like Ctrl+C and keeps the log
+type interruptEvent struct {
+	when time.Time
+}
+
+func (e interruptEvent) When() time.Time {
+	return e.when
+}
+
+// Options - how the input file is opened and edited
+type Options struct {
+	MultiMode bool
+	Follow    bool     // keep loading the input file as it grows
+	Concat    []string // files concatenated after the input file, read-only
+}
+
+func RunEditor(inputFilename string, logFilename string, options Options) error {
 	defer func() {
 		if r := recover(); r != nil {
 			side_channel.WriteLn(string(debug.Stack()))
@@ -198,6 +366,7 @@ func RunEditor(inputFilename string, logFilename string, multiMode bool) error {
 	defer s.Fini()
 
 	s.EnableMouse()
+	s.EnableFocus()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -205,11 +374,13 @@ func RunEditor(inputFilename string, logFilename string, multiMode bool) error {
 	width, height := s.Size()
 
 	var e editor.Editor
+	var drawMu sync.Mutex
 	// make editor
-	insertEditor, loadCtx, finalizer, err := makeInsertEditor(ctx, inputFilename, logFilename, width, height-1)
+	session, finalizer, err := makeInsertEditor(ctx, inputFilename, logFilename, width, height-1, options)
 	if err != nil {
 		return err
 	}
+	insertEditor := session.editor
 	defer finalizer.Close()
 	flush := func() {
 		if err := finalizer.Flush(); err != nil {
@@ -219,16 +390,45 @@ func RunEditor(inputFilename string, logFilename string, multiMode bool) error {
 		}
 	}
 
-	if multiMode {
+	if options.MultiMode {
 		stop := func() {
 			cancel()
 			sendQuitEvent(s)
 		}
-		e = multimode_editor.New(insertEditor, stop, inputFilename)
+		shell := func(cmd string) error {
+			// nothing is drawn while the command owns the terminal
+			drawMu.Lock()
+			defer drawMu.Unlock()
+			return runShell(s, cmd)
+		}
+		multimodeEditor := multimode_editor.New(insertEditor, stop, shell, inputFilename, session)
+		// state is saved on every exit, before the finalizer unmaps the input file
+		defer multimodeEditor.SaveState()
+		e = multimodeEditor
+		// the cursor of the last session is restored once the input is loaded
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-session.LoadCtx().Done():
+				e.Action("input_loaded")
+			}
+		}()
 	} else {
 		e = insertEditor
 	}
 
+	// stop on signals like on Ctrl+C so that deferred functions run
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-signals:
+			_ = s.PostEvent(interruptEvent{when: time.Now()})
+		}
+	}()
+
 	// draw loop
 	go func() {
 		for {
@@ -236,7 +436,9 @@ func RunEditor(inputFilename string, logFilename string, multiMode bool) error {
 			case <-ctx.Done():
 				return
 			case view := <-e.Update():
-				draw(s, view)
+				drawMu.Lock()
+				draw(s, view, session.Source(view))
+				drawMu.Unlock()
 			}
 		}
 	}()
@@ -254,6 +456,35 @@ func RunEditor(inputFilename string, logFilename string, multiMode bool) error {
 		}
 	}()
 
+	// watch input file for external modification
+	checkInterval := config.Load().INPUT_CHECK_INTERVAL
+	checkInput := func() {
+		if message, modified := session.Check(); modified {
+			e.Action("input_modified", message)
+		}
+	}
+	if options.Follow {
+		// growing input file is expected, rotation and truncation cause reloading
+		checkInterval = config.Load().FOLLOW_INTERVAL
+		checkInput = func() {
+			if message := session.Follow(); len(message) > 0 {
+				writeMessage(e, message)
+			}
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkInput()
+			}
+		}
+	}()
+
 	// event loop
 	for {
 		event := s.PollEvent()
@@ -262,8 +493,10 @@ func RunEditor(inputFilename string, logFilename string, multiMode bool) error {
 			// quit from insert_editor - delete log
 			writeMessage(e, "exiting... ")
 			_ = os.Remove(logFilename)
-			<-loadCtx.Done()
+			<-session.LoadCtx().Done()
 			return nil
+		case interruptEvent:
+			return interruptError
 		case *tcell.EventMouse:
 			handleEditorMouse(e, event)
 		case *tcell.EventKey:
@@ -275,6 +508,10 @@ func RunEditor(inputFilename string, logFilename string, multiMode bool) error {
 				handleEditorKey(e, event)
 			}
 
+		case *tcell.EventFocus:
+			if event.Focused {
+				checkInput()
+			}
 		case *tcell.EventResize:
 			s.Sync()
 			width, height = s.Size()
@@ -358,6 +595,10 @@ func handleEditorKey(e editor.Editor, ev *tcell.EventKey) {
 		e.Undo()
 	case tcell.KeyCtrlR:
 		e.Redo()
+	case tcell.KeyCtrlV:
+		e.Action("key_block_select")
+	case tcell.KeyCtrlO:
+		e.Action("key_jump_older")
 	default:
 		writeMessage(e, fmt.Sprintf("unknown key %v", ev.Name()))
 	}
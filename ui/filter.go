@@ -0,0 +1,37 @@
+package ui
+
+import (
+	"fmt"
+	"telescope/core/multimode_editor"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func getFilter(m map[string]any) *multimode_editor.FilterView {
+	if m == nil {
+		return nil
+	}
+	filter, _ := m["filter"].(*multimode_editor.FilterView)
+	return filter
+}
+
+func getGutterStyle() tcell.Style {
+	return tcell.StyleDefault.Dim(true)
+}
+
+// drawFilterGutter - draw the line numbers of the lines shown, return the width of the gutter
+func drawFilterGutter(height int, draw drawFunc, filter *multimode_editor.FilterView) int {
+	for y := 0; y < height && y < len(filter.Rows); y++ {
+		for x, ch := range fmt.Sprintf("%*d", filter.Gutter-1, filter.Rows[y]+1) {
+			draw(x, y, ch, nil, getGutterStyle())
+		}
+	}
+	return filter.Gutter
+}
+
+// shiftDraw - draw dx columns to the right
+func shiftDraw(draw drawFunc, dx int) drawFunc {
+	return func(x int, y int, primary rune, combining []rune, style tcell.Style) {
+		draw(x+dx, y, primary, combining, style)
+	}
+}
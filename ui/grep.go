@@ -0,0 +1,62 @@
+package ui
+
+import (
+	"fmt"
+	"telescope/core/editor"
+	"telescope/core/multimode_editor"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func getGrep(m map[string]any) *multimode_editor.GrepPanel {
+	if m == nil {
+		return nil
+	}
+	panel, _ := m["grep"].(*multimode_editor.GrepPanel)
+	return panel
+}
+
+func getGrepTitleStyle() tcell.Style {
+	return tcell.StyleDefault.Reverse(true)
+}
+
+func getGrepSelectedStyle() tcell.Style {
+	return tcell.StyleDefault.Bold(true).Underline(true)
+}
+
+// drawGrep - draw the title then a line number and the text of each visible hit, return the row of the selected hit
+func drawGrep(width int, height int, draw drawFunc, view editor.View, panel *multimode_editor.GrepPanel) (cursorY int) {
+	cursorY = -1
+	drawString := func(x int, y int, s []rune, style tcell.Style) {
+		for i, ch := range s {
+			draw(x+i, y, ch, nil, style)
+		}
+	}
+	title := fmt.Sprintf(" grep %s: %d lines", panel.Pattern, panel.Count)
+	if len(panel.Progress) > 0 {
+		title += " " + panel.Progress
+	}
+	for x := 0; x < width; x++ {
+		draw(x, 0, ' ', nil, getGrepTitleStyle())
+	}
+	drawString(0, 0, []rune(title), getGrepTitleStyle())
+
+	t := view.Text
+	numWidth := len(fmt.Sprint(t.Len()))
+	for i, row := range panel.Rows {
+		y := i + 1
+		if y >= height {
+			break
+		}
+		style := tcell.StyleDefault
+		if panel.Top+i == panel.Selected {
+			style, cursorY = getGrepSelectedStyle(), y
+		}
+		prefix := []rune(fmt.Sprintf("%*d  ", numWidth, row+1))
+		drawString(0, y, prefix, tcell.StyleDefault.Dim(true))
+		if row < t.Len() {
+			drawString(len(prefix), y, t.GetRange(row, 0, width-len(prefix)), style)
+		}
+	}
+	return cursorY
+}
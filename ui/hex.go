@@ -0,0 +1,97 @@
+package ui
+
+import (
+	"fmt"
+	"telescope/core/editor"
+	"telescope/core/multimode_editor"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	hexOffsetWidth = 10 // "%08x" and 2 spaces
+	hexByteWidth   = 3  // "hh "
+	hexAsciiCol    = hexOffsetWidth + multimode_editor.HexWidth*hexByteWidth + 1
+)
+
+func getHex(m map[string]any) (multimode_editor.HexView, bool) {
+	if m == nil {
+		return multimode_editor.HexView{}, false
+	}
+	hex, ok := m["hex"].(multimode_editor.HexView)
+	return hex, ok
+}
+
+func getHexBreakStyle() tcell.Style {
+	return tcell.StyleDefault.Dim(true)
+}
+
+// drawHex - draw offset | hex | ascii columns from the top of the hex window, return the cursor position
+func drawHex(width int, height int, draw drawFunc, view editor.View, hex multimode_editor.HexView) (cursorX int, cursorY int) {
+	cursorX, cursorY = -1, -1
+	t := view.Text
+	row, sub := hex.TopRow, hex.TopSub
+	n := 0 // number of bytes of row
+	if row < t.Len() {
+		n = t.LineBytes(row)
+	}
+	drawString := func(x int, y int, s string, style tcell.Style) {
+		for i, ch := range s {
+			draw(x+i, y, ch, nil, style)
+		}
+	}
+	for y := 0; y < height; y++ {
+		if row >= t.Len() {
+			draw(0, y, '~', nil, tcell.StyleDefault)
+			continue
+		}
+		beg := sub * multimode_editor.HexWidth
+		// bytes of this hex row
+		line := t.GetBytesRange(row, beg, beg+multimode_editor.HexWidth)
+		offset := "--------" // line in memory
+		if t.Offset(row) >= 0 {
+			offset = fmt.Sprintf("%08x", t.Offset(row)+int64(beg))
+		}
+		drawString(0, y, offset, tcell.StyleDefault)
+		for k := 0; k < multimode_editor.HexWidth && beg+k <= n; k++ {
+			col := beg + k
+			b, style := byte('\n'), getHexBreakStyle()
+			if k < len(line) {
+				b, style = line[k], tcell.StyleDefault
+			}
+			hexStr := fmt.Sprintf("%02x", b)
+			eof := col == n && row == t.Len()-1 && t.NoFinalNewline()
+			if eof {
+				// no final line break, the cell is the end of the file where bytes are added
+				hexStr = "  "
+			}
+			asciiStyle := style
+			if row == hex.Row && col == hex.Col {
+				cursorX, cursorY = hexOffsetWidth+k*hexByteWidth, y
+				if hex.Pending >= 0 {
+					hexStr = fmt.Sprintf("%x%c", hex.Pending, hexStr[1])
+					cursorX++
+				}
+				asciiStyle = style.Reverse(true)
+			}
+			drawString(hexOffsetWidth+k*hexByteWidth, y, hexStr, style)
+			ch := '.'
+			if eof {
+				ch = ' '
+			} else if 0x20 <= b && b < 0x7f {
+				ch = rune(b)
+			}
+			draw(hexAsciiCol+k, y, ch, nil, asciiStyle)
+		}
+		// next hex row
+		if sub < n/multimode_editor.HexWidth {
+			sub++
+		} else {
+			row, sub = row+1, 0
+			if row < t.Len() {
+				n = t.LineBytes(row)
+			}
+		}
+	}
+	return cursorX, cursorY
+}
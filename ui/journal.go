@@ -0,0 +1,59 @@
+package ui
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+	"telescope/core/editor"
+	"telescope/core/log_writer"
+)
+
+// journal - log_writer over a log file, the log can be restarted from an empty file
+type journal struct {
+	mu        sync.Mutex
+	file      *os.File
+	writer    *bufio.Writer
+	logWriter *log_writer.Writer
+}
+
+func newJournal(file *os.File) (*journal, error) {
+	j := &journal{
+		mu:   sync.Mutex{},
+		file: file,
+	}
+	if err := j.restart(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *journal) restart() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(j.file)
+	logWriter, err := log_writer.New(writer)
+	if err != nil {
+		return err
+	}
+	j.writer, j.logWriter = writer, logWriter
+	return nil
+}
+
+func (j *journal) write(entry editor.LogEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.logWriter.Write(entry)
+}
+
+func (j *journal) flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.writer.Flush()
+}
@@ -15,10 +15,11 @@ func RunReplay(inputFilename string, logFilename string) error {
 	_, _ = fmt.Fprintf(os.Stderr, "loading input file %s\n", inputFilename)
 
 	// make insert_editor without log_writer
-	insertEditor, loadCtx, finalizer, err := makeInsertEditor(ctx, inputFilename, "", 20, 20)
+	session, finalizer, err := makeInsertEditor(ctx, inputFilename, "", 20, 20, Options{})
 	if err != nil {
 		return err
 	}
+	insertEditor := session.editor
 	defer finalizer.Close()
 
 	go func() {
@@ -35,7 +36,7 @@ func RunReplay(inputFilename string, logFilename string) error {
 	}()
 
 	// wait for loading
-	<-loadCtx.Done()
+	<-session.LoadCtx().Done()
 
 	_, _ = fmt.Fprintf(os.Stderr, "loading log_writer file %s\n", logFilename)
 
@@ -48,7 +49,11 @@ func RunReplay(inputFilename string, logFilename string) error {
 	}
 	_, _ = fmt.Fprintf(os.Stderr, "replaying file\n")
 	t := insertEditor.Render().Text
-	for _, line := range t.Iter {
+	for i, line := range t.Iter {
+		if i == t.Len()-1 && t.NoFinalNewline() {
+			_, _ = fmt.Fprint(os.Stdout, string(line))
+			continue
+		}
 		_, _ = fmt.Fprintln(os.Stdout, string(line))
 	}
 	return nil
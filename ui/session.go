@@ -0,0 +1,582 @@
+package ui
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"telescope/config"
+	"telescope/core/editor"
+	"telescope/core/insert_editor"
+	"telescope/core/util/text"
+	"telescope/util/buffer"
+	"telescope/util/file_util"
+
+	"golang.org/x/exp/mmap"
+)
+
+// session - an insert editor together with its input file
+type session struct {
+	ctx           context.Context
+	editor        *insert_editor.Editor
+	inputFilename string
+	follow        bool
+	concat        []string // files concatenated after the input file
+	f             *finalizer
+
+	mu         sync.Mutex // the fields below are protected by mu
+	loadCtx    context.Context
+	reader     *buffer.LimitReader // mapping of the input file, nil if the input is streamed
+	grow       *buffer.GrowReader  // follow mode - the input file is mapped again when it grows
+	info       os.FileInfo         // input file info at the last check
+	mappedInfo os.FileInfo         // input file info when it was mapped, the text reads from that content
+	detached   bool                // text no longer reads from the input file
+	compressed bool                // text reads from the decompressed input file
+	missing    bool                // input file was removed
+	remote     *buffer.HTTPReader  // text reads from an http url
+	archived   bool                // text reads from a tar or zip archive member
+
+	sourceNames   []string      // concatenation - names of the source files
+	sourceOffsets []int64       // concatenation - offset of each source file in the concatenated reader
+	sourceText    text.Text     // concatenation - version of the text sourceRows were found in
+	sourceRows    map[int64]int // concatenation - row of the first line of source files by their offset
+}
+
+func (s *session) lock(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+// openInputWithoutLock - map the input file, the old mapping is kept until finalizer is closed
+// since older views might still read from it
+// stdin and files that cannot be mapped are streamed into spill files instead
+func (s *session) openInputWithoutLock() (buffer.Reader, error) {
+	s.reader, s.grow = nil, nil
+	s.detached, s.missing, s.remote, s.archived = false, false, nil, false
+	if s.inputFilename == "-" {
+		return s.openStreamWithoutLock(os.Stdin)
+	}
+	if buffer.IsHTTPURL(s.inputFilename) {
+		return s.openRemoteWithoutLock()
+	}
+	if archive, member, ok := file_util.SplitArchivePath(s.inputFilename); ok {
+		return s.openArchiveMemberWithoutLock(archive, member)
+	}
+	if len(s.concat) > 0 {
+		return s.openConcatWithoutLock()
+	}
+	info, err := os.Stat(s.inputFilename)
+	if err != nil {
+		return nil, err
+	}
+	s.info, s.mappedInfo = info, info
+	if !info.Mode().IsRegular() || (info.Size() == 0 && !s.follow) {
+		// pipe, device or files of special file systems that report zero size
+		file, err := os.Open(s.inputFilename)
+		if err != nil {
+			return nil, err
+		}
+		s.f.closerList = append(s.f.closerList, file.Close)
+		return s.openStreamWithoutLock(file)
+	}
+
+	inputMmapReader, err := mmap.Open(s.inputFilename)
+	if err != nil {
+		return nil, err
+	}
+	s.f.closerList = append(s.f.closerList, inputMmapReader.Close)
+	if reader, ok, err := s.openCompressedWithoutLock(inputMmapReader); ok || err != nil {
+		return reader, err
+	}
+	if s.follow {
+		s.grow = buffer.NewGrowReader(inputMmapReader)
+		s.reader = buffer.NewLimitReader(s.grow)
+	} else {
+		s.reader = buffer.NewLimitReader(inputMmapReader)
+	}
+	return s.reader, nil
+}
+
+// openCompressedWithoutLock - decompress the mapped input file if it is gzip or bzip2 compressed
+func (s *session) openCompressedWithoutLock(inputMmapReader *mmap.ReaderAt) (buffer.Reader, bool, error) {
+	s.compressed = false
+	reader := buffer.NewLimitReader(inputMmapReader)
+	var compressedReader *buffer.CompressedReader
+	var err error
+	blockSize, cacheSize := config.Load().COMPRESSED_BLOCK_SIZE, config.Load().COMPRESSED_CACHE_SIZE
+	spillDir, spillSize := filepath.Join(config.Load().TMP_DIR, "spill"), config.Load().COMPRESSED_SPILL_SIZE
+	switch {
+	case buffer.IsGzip(reader):
+		compressedReader, err = buffer.NewGzipReader(reader, blockSize, cacheSize, spillDir, spillSize)
+	case buffer.IsBzip2(reader):
+		compressedReader, err = buffer.NewBzip2Reader(reader, blockSize, cacheSize, spillDir, spillSize)
+	default:
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	s.f.closerList = append(s.f.closerList, compressedReader.Close)
+	s.reader, s.compressed = reader, true
+	return compressedReader, true, nil
+}
+
+// openConcatWithoutLock - map the input file and the files after it, the concatenation is read-only
+// a line break is inserted after files that do not end with one so that lines never span two files
+func (s *session) openConcatWithoutLock() (buffer.Reader, error) {
+	var readers []buffer.Reader
+	s.sourceNames, s.sourceOffsets = nil, nil
+	offset := 0
+	for _, filename := range append([]string{s.inputFilename}, s.concat...) {
+		mmapReader, err := mmap.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		s.f.closerList = append(s.f.closerList, mmapReader.Close)
+		s.sourceNames = append(s.sourceNames, filename)
+		s.sourceOffsets = append(s.sourceOffsets, int64(offset))
+		// a fault reading a truncated file reads as zeros instead of crashing
+		readers = append(readers, buffer.NewLimitReader(mmapReader))
+		offset += mmapReader.Len()
+		if mmapReader.Len() > 0 && mmapReader.At(mmapReader.Len()-1) != '\n' {
+			readers = append(readers, buffer.NewMemReader([]byte{'\n'}))
+			offset++
+		}
+	}
+	return buffer.Concat(readers...), nil
+}
+
+// Sources - names of the concatenated files and their offsets, nil if the input is a single file
+func (s *session) Sources() (names []string, offsets []int64) {
+	s.lock(func() {
+		names, offsets = s.sourceNames, s.sourceOffsets
+	})
+	return names, offsets
+}
+
+// Source - the concatenated file at the cursor and the line number in that file
+func (s *session) Source(view editor.View) string {
+	names, offsets := s.Sources()
+	if len(names) == 0 || view.Cursor.Row >= view.Text.Len() {
+		return ""
+	}
+	offset := view.Text.Offset(view.Cursor.Row)
+	if offset < 0 {
+		return "" // line in memory
+	}
+	i := sort.Search(len(offsets), func(i int) bool {
+		return offsets[i] > offset
+	}) - 1
+	row := view.Cursor.Row - s.sourceRow(view.Text, offsets[i]) + 1
+	return fmt.Sprintf("%s:%d", filepath.Base(names[i]), row)
+}
+
+// sourceRow - row of the first line of the source file at offset, rows are kept until the text changes since
+// the status line asks for them on every frame
+func (s *session) sourceRow(t text.Text, offset int64) int {
+	var row int
+	var ok bool
+	s.lock(func() {
+		if !t.SameLines(s.sourceText) {
+			s.sourceText, s.sourceRows = t, make(map[int64]int)
+		}
+		row, ok = s.sourceRows[offset]
+	})
+	if ok {
+		return row
+	}
+	row = t.Search(offset)
+	s.lock(func() {
+		if t.SameLines(s.sourceText) {
+			s.sourceRows[offset] = row
+		}
+	})
+	return row
+}
+
+// openRemoteWithoutLock - read the input url with range requests, it is opened read-only
+func (s *session) openRemoteWithoutLock() (buffer.Reader, error) {
+	httpReader, err := buffer.NewHTTPReader(
+		s.inputFilename,
+		config.Load().HTTP_BLOCK_SIZE, config.Load().HTTP_CACHE_SIZE, config.Load().HTTP_PREFETCH,
+		config.Load().HTTP_TIMEOUT,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.f.closerList = append(s.f.closerList, httpReader.Close)
+	s.remote = httpReader
+	return httpReader, nil
+}
+
+// openArchiveMemberWithoutLock - read a member of a tar or zip archive in place, it is opened read-only
+func (s *session) openArchiveMemberWithoutLock(archive string, name string) (buffer.Reader, error) {
+	archiveMmapReader, err := mmap.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	s.f.closerList = append(s.f.closerList, archiveMmapReader.Close)
+	// a fault reading a truncated archive reads as zeros instead of crashing
+	archiveReader := buffer.NewLimitReader(archiveMmapReader)
+	members, err := buffer.ListArchive(archiveReader)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.Name != name {
+			continue
+		}
+		reader, err := buffer.OpenArchiveMember(
+			archiveReader, member,
+			config.Load().COMPRESSED_BLOCK_SIZE, config.Load().COMPRESSED_CACHE_SIZE,
+			filepath.Join(config.Load().TMP_DIR, "spill"), config.Load().COMPRESSED_SPILL_SIZE,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if closer, ok := reader.(io.Closer); ok {
+			s.f.closerList = append(s.f.closerList, closer.Close)
+		}
+		s.archived = true
+		return reader, nil
+	}
+	return nil, fmt.Errorf("%s: no member %s", archive, name)
+}
+
+func (s *session) openStreamWithoutLock(r io.Reader) (buffer.Reader, error) {
+	spillDir := filepath.Join(config.Load().TMP_DIR, "spill")
+	err := os.MkdirAll(spillDir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	spillDir, err = os.MkdirTemp(spillDir, "")
+	if err != nil {
+		return nil, err
+	}
+	spillReader, err := buffer.NewSpillReader(r, spillDir, config.Load().SPILL_BLOCK_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	s.f.closerList = append(s.f.closerList, spillReader.Close)
+	return spillReader, nil
+}
+
+// ReadOnly - compressed input, concatenations, urls and archive members are opened read-only
+func (s *session) ReadOnly() (readonly bool) {
+	s.lock(func() {
+		readonly = s.compressed || len(s.concat) > 0 || s.remote != nil || s.archived
+	})
+	return readonly
+}
+
+func (s *session) LoadCtx() (loadCtx context.Context) {
+	s.lock(func() {
+		loadCtx = s.loadCtx
+	})
+	return loadCtx
+}
+
+// WriteBack - write the current text into the input file, the new file is mapped again and the editor is
+// rebased onto it so that no line refers to the old mapping, then the log restarts from the new file
+func (s *session) WriteBack() (err error) {
+	s.lock(func() {
+		if len(s.inputFilename) == 0 || s.inputFilename == "-" {
+			err = errors.New("no input file")
+			return
+		}
+		if s.remote != nil {
+			err = errors.New("input is a url, use :w with a local file")
+			return
+		}
+		if s.archived {
+			err = errors.New("input is an archive member, use :w with another file")
+			return
+		}
+		if info, err1 := os.Stat(s.inputFilename); err1 == nil && !info.Mode().IsRegular() {
+			err = errors.New("input file is not a regular file")
+			return
+		}
+		if s.compressed {
+			err = errors.New("input file is compressed, use :w with another file")
+			return
+		}
+		if len(s.concat) > 0 {
+			err = errors.New("input is a concatenation, use :w with another file")
+			return
+		}
+		if s.loadCtx.Err() == nil {
+			err = errors.New("cannot write into input file while loading")
+			return
+		}
+		t := s.editor.Render().Text
+
+		// offsets of lines in the new file are recorded while writing since the old mapping might be
+		// overwritten in place if rename is not possible
+		var offsets []int
+		offset := 0
+		iter := func(yield func(i int, line []byte) bool) {
+			for i, line := range t.IterBytes {
+				offsets = append(offsets, offset)
+				offset += len(line) + 1
+				if !yield(i, line) {
+					return
+				}
+			}
+		}
+		err = file_util.SafeWriteFileWith(s.inputFilename, iter, file_util.WriteOptions{
+			NoFinalNewline: t.NoFinalNewline(),
+		})
+		if err != nil {
+			return
+		}
+
+		reader, err1 := s.openInputWithoutLock()
+		if err1 != nil {
+			err = err1
+			return
+		}
+		if s.follow {
+			// the loader follows the old file, the written file is loaded and followed instead
+			s.loadCtx = s.editor.Reload(s.ctx, reader)
+			err = s.f.RestartLog()
+			return
+		}
+		rebased := text.New(reader)
+		for i, beg := range offsets {
+			end := offset - 1
+			if i+1 < len(offsets) {
+				end = offsets[i+1] - 1
+			}
+			rebased = rebased.Append(text.MakeLineFromReader(reader, beg, end))
+		}
+		s.editor.Rebase(rebased.SetNoFinalNewline(t.NoFinalNewline()))
+		err = s.f.RestartLog()
+	})
+	return err
+}
+
+// Reload - map the input file again and load it from scratch, the log restarts from the new file
+func (s *session) Reload() (err error) {
+	s.lock(func() {
+		if len(s.inputFilename) == 0 {
+			err = errors.New("no input file")
+			return
+		}
+		if s.inputFilename == "-" {
+			err = errors.New("cannot reload stdin")
+			return
+		}
+		reader, err1 := s.openInputWithoutLock()
+		if err1 != nil {
+			err = err1
+			return
+		}
+		s.loadCtx = s.editor.Reload(s.ctx, reader)
+		err = s.f.RestartLog()
+	})
+	return err
+}
+
+// Snapshot - copy the mapped input file into a private file and read the text from it instead
+// the log stays valid with the snapshot as input file
+func (s *session) Snapshot() (snapshotFilename string, err error) {
+	s.lock(func() {
+		if s.reader == nil {
+			err = errors.New("input file is not mapped or concatenated")
+			return
+		}
+		if s.compressed {
+			err = errors.New("input file is compressed")
+			return
+		}
+		if s.loadCtx.Err() == nil {
+			err = errors.New("cannot snapshot input file while loading")
+			return
+		}
+		if err = s.snapshotableWithoutLock(); err != nil {
+			return
+		}
+		if s.grow != nil {
+			// following stops so that no line is loaded past the end of the snapshot
+			s.editor.StopLoad()
+			s.grow = nil
+		}
+		absPath, _ := filepath.Abs(s.inputFilename)
+		snapshotFilename = filepath.Join(config.Load().TMP_DIR, "snapshot", absPath)
+		err = writeReader(snapshotFilename, s.reader)
+		if err != nil {
+			return
+		}
+		snapshotMmapReader, err1 := mmap.Open(snapshotFilename)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		s.f.closerList = append(s.f.closerList, snapshotMmapReader.Close)
+		s.editor.RebaseReader(snapshotMmapReader)
+		s.detached = true
+	})
+	return snapshotFilename, err
+}
+
+// snapshotableWithoutLock - the mapping still holds the content the text was loaded from, a removed or replaced
+// file stays mapped with its old content, a file modified in place only if it was appended to since the mapping
+// covers the old length only
+func (s *session) snapshotableWithoutLock() error {
+	info, err := os.Stat(s.inputFilename)
+	if err != nil || !os.SameFile(s.mappedInfo, info) {
+		return nil
+	}
+	switch {
+	case info.Size() < s.mappedInfo.Size():
+		return errors.New("input file was truncated, use :reload")
+	case info.Size() == s.mappedInfo.Size() && !info.ModTime().Equal(s.mappedInfo.ModTime()):
+		return errors.New("input file was modified in place, use :reload")
+	}
+	return nil
+}
+
+// Check - compare the input file with the last check, a message is returned if it was modified externally
+// if the file was truncated in place, the mapping is limited to the new size, a url reports failed fetches instead
+func (s *session) Check() (message string, modified bool) {
+	s.lock(func() {
+		if s.remote != nil {
+			message = s.fetchErrorWithoutLock()
+			return
+		}
+		if s.reader == nil || s.detached {
+			return
+		}
+		info, err := os.Stat(s.inputFilename)
+		if err != nil {
+			if !s.missing {
+				s.missing = true
+				message = "input file was removed"
+			}
+			return
+		}
+		s.missing = false
+		switch {
+		case !os.SameFile(s.info, info):
+			message = "input file was replaced"
+		case info.Size() < s.info.Size():
+			s.reader.Limit(int(info.Size()))
+			message = "input file was truncated"
+		case info.Size() != s.info.Size() || !info.ModTime().Equal(s.info.ModTime()):
+			message = "input file was modified"
+		default:
+			return
+		}
+		s.info = info
+		if s.snapshotableWithoutLock() == nil {
+			message += ", use :reload, :snapshot or :readonly"
+		} else {
+			message += ", use :reload or :readonly"
+		}
+	})
+	if len(message) == 0 {
+		return "", false
+	}
+	return message, true
+}
+
+// Failures - number of failed fetches of the input url, the input file is mapped and reads never fail
+func (s *session) Failures() (failures int) {
+	s.lock(func() {
+		if s.remote != nil {
+			failures = s.remote.Failures()
+		}
+	})
+	return failures
+}
+
+// fetchErrorWithoutLock - the last failed fetch of the input url since the previous check, empty if none
+func (s *session) fetchErrorWithoutLock() string {
+	if err := s.remote.Err(); err != nil {
+		return fmt.Sprintf("fetch failed, the text is incomplete: %v, use :reload", err)
+	}
+	return ""
+}
+
+// Follow - map the input file again if it has grown, reload it if it was rotated or truncated
+func (s *session) Follow() (message string) {
+	s.lock(func() {
+		if s.remote != nil {
+			message = s.fetchErrorWithoutLock()
+			return
+		}
+		if s.grow == nil {
+			return
+		}
+		info, err := os.Stat(s.inputFilename)
+		if err != nil {
+			return // rotated file is not created yet
+		}
+		switch {
+		case !os.SameFile(s.info, info):
+			message = "input file was rotated, reloading"
+		case info.Size() < s.info.Size():
+			s.reader.Limit(int(info.Size()))
+			message = "input file was truncated, reloading"
+		case info.Size() > s.info.Size():
+			inputMmapReader, err := mmap.Open(s.inputFilename)
+			if err != nil {
+				return
+			}
+			// previous mappings are closed by the finalizer only, lines and views might still read from them
+			s.f.closerList = append(s.f.closerList, inputMmapReader.Close)
+			s.grow.Grow(inputMmapReader)
+			s.info = info
+			return
+		default:
+			return
+		}
+		reader, err := s.openInputWithoutLock()
+		if err != nil {
+			message = "error reload file " + err.Error()
+			return
+		}
+		s.loadCtx = s.editor.Reload(s.ctx, reader)
+		err = s.f.RestartLog()
+		if err != nil {
+			message = "error restart log " + err.Error()
+		}
+	})
+	return message
+}
+
+func writeReader(filename string, reader buffer.Reader) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0o700)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	block := make([]byte, 64*1024)
+	for i := 0; i < reader.Len(); {
+		n := buffer.Read(reader, block, i)
+		if n == 0 {
+			break // the reader was truncated
+		}
+		_, err = writer.Write(block[:n])
+		if err != nil {
+			return err
+		}
+		i += n
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,38 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// runShell - run cmd in the terminal with the screen suspended, the screen comes back after ENTER
+func runShell(s tcell.Screen, cmd string) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	if err := s.Suspend(); err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Resume()
+		s.Sync()
+	}()
+	// Ctrl+C stops the command, not the editor
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	p := exec.Command("sh", "-c", cmd)
+	p.Stdin, p.Stdout, p.Stderr = tty, tty, tty
+	err = p.Run()
+	_, _ = fmt.Fprint(tty, "\nPress ENTER to continue")
+	_, _ = bufio.NewReader(tty).ReadString('\n')
+	return err
+}
@@ -1,22 +1,19 @@
 package ui
 
 import (
-	"bufio"
 	"context"
 	"os"
 	"telescope/core/editor"
 	"telescope/core/insert_editor"
-	"telescope/core/log_writer"
 
 	"telescope/util/side_channel"
 
 	"telescope/util/buffer"
-
-	"golang.org/x/exp/mmap"
 )
 
 type finalizer struct {
 	flush      func() error
+	restartLog func() error
 	closerList []func() error
 }
 
@@ -33,57 +30,78 @@ func (c *finalizer) Flush() error {
 	return c.flush()
 }
 
+func (c *finalizer) RestartLog() error {
+	if c.restartLog == nil {
+		return nil
+	}
+	return c.restartLog()
+}
+
 func makeInsertEditor(
 	ctx context.Context,
 	inputFilename string, logFilename string,
-	width int, height int,
-) (insertEditor *insert_editor.Editor, loadCtx context.Context, f *finalizer, err error) {
+	width int, height int, options Options,
+) (s *session, f *finalizer, err error) {
 	f = &finalizer{}
 
-	insertEditor, err = insert_editor.New(height, width)
+	insertEditor, err := insert_editor.New(height, width, options.Follow)
 	if err != nil {
 		f.Close()
-		return nil, nil, nil, err
+		return nil, nil, err
+	}
+	s = &session{
+		ctx:           ctx,
+		editor:        insertEditor,
+		inputFilename: inputFilename,
+		follow:        options.Follow,
+		concat:        options.Concat,
+		f:             f,
 	}
 	var inputBuffer buffer.Reader = nil
 	if len(inputFilename) > 0 {
-		inputMmapReader, err := mmap.Open(inputFilename)
+		inputBuffer, err = s.openInputWithoutLock()
 		if err != nil {
 			f.Close()
-			return nil, nil, nil, err
+			return nil, nil, err
 		}
-		f.closerList = append(f.closerList, inputMmapReader.Close)
-		inputBuffer = inputMmapReader
 	}
-	loadCtx, err = insertEditor.Load(ctx, inputBuffer)
+	s.loadCtx, err = insertEditor.Load(ctx, inputBuffer)
 	if err != nil {
 		f.Close()
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 	if len(logFilename) > 0 {
 		logFile, err := os.OpenFile(logFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
 			f.Close()
-			return nil, nil, nil, err
+			return nil, nil, err
 		}
 		f.closerList = append(f.closerList, logFile.Close)
-		writer := bufio.NewWriter(logFile)
-		f.closerList = append(f.closerList, writer.Flush)
-		logWriter, err := log_writer.New(writer)
+		j, err := newJournal(logFile)
 		if err != nil {
 			f.Close()
-			return nil, nil, nil, err
+			return nil, nil, err
+		}
+		// entries logged so far are written before flushing or restarting the journal
+		flush := func() error {
+			insertEditor.SyncLog()
+			return j.flush()
+		}
+		f.closerList = append(f.closerList, flush)
+		f.flush = flush
+		f.restartLog = func() error {
+			insertEditor.SyncLog()
+			return j.restart()
 		}
-		f.flush = writer.Flush
 
 		insertEditor.Subscribe(func(entry editor.LogEntry) {
-			err := logWriter.Write(entry)
+			err := j.write(entry)
 			if err != nil {
 				side_channel.Panic(err)
 			}
 		})
 	}
-	return insertEditor, loadCtx, f, nil
+	return s, f, nil
 }
 
 func writeMessage(e editor.Editor, message string) {
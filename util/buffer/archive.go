@@ -0,0 +1,149 @@
+package buffer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ArchiveMember - a regular file in a tar or zip archive
+type ArchiveMember struct {
+	Name    string
+	Size    int  // uncompressed size
+	offset  int  // offset of the member data in the archive
+	stored  int  // size of the member data in the archive
+	deflate bool // zip member compressed with deflate
+}
+
+// IsZip - src starts with a zip local file header or is an empty zip
+func IsZip(src Reader) bool {
+	return hasPrefix(src, 0, []byte("PK\x03\x04")) || hasPrefix(src, 0, []byte("PK\x05\x06"))
+}
+
+// IsTar - src starts with a ustar or gnu tar header
+func IsTar(src Reader) bool {
+	return hasPrefix(src, 257, []byte("ustar"))
+}
+
+// ListArchive - regular files in a tar or zip archive in the order they are stored
+func ListArchive(src Reader) ([]ArchiveMember, error) {
+	switch {
+	case IsZip(src):
+		return listZip(src)
+	case IsTar(src):
+		return listTar(src)
+	default:
+		return nil, errors.New("not a tar or zip archive")
+	}
+}
+
+func listZip(src Reader) ([]ArchiveMember, error) {
+	z, err := zip.NewReader(&readerAt{reader: src}, int64(src.Len()))
+	if err != nil {
+		return nil, err
+	}
+	var members []ArchiveMember
+	for _, file := range z.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		offset, err := file.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		member := ArchiveMember{
+			Name:   file.Name,
+			Size:   int(file.UncompressedSize64),
+			offset: int(offset),
+			stored: int(file.CompressedSize64),
+		}
+		switch file.Method {
+		case zip.Store:
+		case zip.Deflate:
+			member.deflate = true
+		default:
+			return nil, fmt.Errorf("%s: unsupported compression method %d", file.Name, file.Method)
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func listTar(src Reader) ([]ArchiveMember, error) {
+	// tar data is stored as is, the position after reading a header is the offset of the data
+	section := &seekSectionReader{sectionReader: sectionReader{reader: src, pos: 0, end: src.Len()}}
+	t := tar.NewReader(section)
+	var members []ArchiveMember
+	for {
+		header, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue // sparse files are not stored contiguously
+		}
+		members = append(members, ArchiveMember{
+			Name:   header.Name,
+			Size:   int(header.Size),
+			offset: section.pos,
+			stored: int(header.Size),
+		})
+	}
+	return members, nil
+}
+
+// OpenArchiveMember - stored members are slices of src, deflated members are decompressed on demand, see
+// CompressedReader for spillDir and spillSize
+func OpenArchiveMember(
+	src Reader, member ArchiveMember, blockSize int, cacheSize int, spillDir string, spillSize int,
+) (Reader, error) {
+	if member.offset+member.stored > src.Len() {
+		return nil, fmt.Errorf("%s: truncated archive", member.Name)
+	}
+	data := Slice(src, member.offset, member.offset+member.stored)
+	if !member.deflate {
+		return data, nil
+	}
+	return NewDeflateReader(data, blockSize, cacheSize, spillDir, spillSize)
+}
+
+// readerAt - io.ReaderAt over a Reader
+type readerAt struct {
+	reader Reader
+}
+
+func (r *readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(r.reader.Len()) {
+		return 0, io.EOF
+	}
+	n := Read(r.reader, p, int(off))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// seekSectionReader - sectionReader that can skip forward, tar skips member data by seeking
+type seekSectionReader struct {
+	sectionReader
+}
+
+func (s *seekSectionReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(s.pos)
+	case io.SeekEnd:
+		offset += int64(s.end)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = int(offset)
+	return offset, nil
+}
@@ -0,0 +1,505 @@
+package buffer
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+CompressedReader - random access reader over gzip, bzip2 or raw deflate compressed data
+
+the standard library decompressors cannot resume from the middle of a stream, so restart points are
+recorded wherever a fresh decompressor can start: gzip members and bzip2 streams. multi-member files
+(bgzip, pbzip2) are therefore cheap to seek. in single-member files, a block that was evicted from the
+block cache is decompressed again from the start of the member, or from the block read last when reading
+forward. spilling trades disk for that time: with a spillLimit, blocks further than one block from a
+restart point are written decompressed into a file in spillDir while scanning, up to spillLimit bytes,
+and read back from it once evicted. the limit bounds the disk used, which is otherwise as large as the
+decompressed input since the line indexer reads every byte.
+
+the input is decompressed in the background to discover restart points and the length, the reader is a
+Stream that keeps growing until Done is closed.
+*/
+type CompressedReader struct {
+	src       Reader
+	format    compressedFormat
+	blockSize int
+	cacheSize int
+	spillDir  string // directory of the spill file
+	spillSize int    // decompressed bytes spilled at most, 0 to decompress evicted blocks again
+
+	length    atomic.Int64
+	requested atomic.Int64 // highest offset read, the background scan does not run too far ahead
+	last      atomic.Pointer[compressedBlock]
+	done      chan struct{}
+	err       error // decompression error, valid after done is closed
+
+	mu          sync.Mutex // the fields below are protected by mu
+	restarts    []restartPoint
+	cache       map[int]*compressedBlock
+	tick        uint64
+	cursor      *compressedCursor // decompressor left by the last cache miss
+	spill       *os.File          // decompressed blocks far from a restart point, removed on Close
+	spilled     map[int]spillBlock
+	spilledSize int
+	noSpill     bool // spilling failed, reached spillSize or was not requested
+	closed      bool
+}
+
+// spillBlock - a decompressed block in the spill file
+type spillBlock struct {
+	offset int64
+	size   int
+}
+
+type restartPoint struct {
+	offset int // compressed offset
+	pos    int // decompressed offset
+}
+
+type compressedBlock struct {
+	beg  int
+	data []byte
+	used uint64
+}
+
+type compressedCursor struct {
+	r    io.Reader
+	next func() int // compressed offset of the next segment, valid after r reaches EOF
+	pos  int        // decompressed offset
+}
+
+func NewGzipReader(src Reader, blockSize int, cacheSize int, spillDir string, spillSize int) (*CompressedReader, error) {
+	return newCompressedReader(src, gzipFormat{}, blockSize, cacheSize, spillDir, spillSize)
+}
+
+func NewBzip2Reader(src Reader, blockSize int, cacheSize int, spillDir string, spillSize int) (*CompressedReader, error) {
+	return newCompressedReader(src, &bzip2Format{ends: make(map[int]int)}, blockSize, cacheSize, spillDir, spillSize)
+}
+
+// NewDeflateReader - raw deflate data without header, e.g. a deflated zip member
+func NewDeflateReader(src Reader, blockSize int, cacheSize int, spillDir string, spillSize int) (*CompressedReader, error) {
+	return newCompressedReader(src, deflateFormat{}, blockSize, cacheSize, spillDir, spillSize)
+}
+
+// IsGzip - src starts with gzip magic number
+func IsGzip(src Reader) bool {
+	return hasPrefix(src, 0, []byte{0x1f, 0x8b})
+}
+
+// IsBzip2 - src starts with a bzip2 stream
+func IsBzip2(src Reader) bool {
+	return isBzip2Stream(src, 0)
+}
+
+func newCompressedReader(
+	src Reader, format compressedFormat, blockSize int, cacheSize int, spillDir string, spillSize int,
+) (*CompressedReader, error) {
+	if blockSize <= 0 || cacheSize <= 1 {
+		return nil, errors.New("invalid block or cache size")
+	}
+	c := &CompressedReader{
+		src:       src,
+		format:    format,
+		blockSize: blockSize,
+		cacheSize: cacheSize,
+		spillDir:  spillDir,
+		spillSize: spillSize,
+		done:      make(chan struct{}),
+		cache:     make(map[int]*compressedBlock),
+		spilled:   make(map[int]spillBlock),
+		noSpill:   len(spillDir) == 0 || spillSize <= 0,
+	}
+	go c.scan()
+	return c, nil
+}
+
+// scan - decompress sequentially, record restart points and fill the cache ahead of the readers
+func (c *CompressedReader) scan() {
+	defer close(c.done)
+	pos := 0
+	data := make([]byte, c.blockSize)
+	fill := 0
+	putBlock := func() bool {
+		c.putBlock(&compressedBlock{beg: pos - fill, data: data[:fill]})
+		c.length.Store(int64(pos))
+		data, fill = make([]byte, c.blockSize), 0
+		// wait for readers so that the cache is not evicted before use
+		for int64(pos)-c.requested.Load() > int64(c.cacheSize/2*c.blockSize) {
+			if c.isClosed() {
+				return false
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return !c.isClosed()
+	}
+
+	offset := 0
+	for offset < c.src.Len() {
+		r, next, err := c.format.open(c.src, offset)
+		if err != nil {
+			if pos == 0 {
+				c.err = err
+			}
+			break // trailing garbage is ignored
+		}
+		c.mu.Lock()
+		c.restarts = append(c.restarts, restartPoint{offset: offset, pos: pos})
+		c.mu.Unlock()
+		for {
+			n, err := r.Read(data[fill:])
+			fill, pos = fill+n, pos+n
+			if fill == c.blockSize {
+				if !putBlock() {
+					return
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				c.err = err
+				offset = c.src.Len()
+				break
+			}
+		}
+		if c.err == nil {
+			offset = next()
+		}
+	}
+	if fill > 0 {
+		putBlock()
+	}
+}
+
+func (c *CompressedReader) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *CompressedReader) putBlock(b *compressedBlock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spillBlockWithoutLock(b)
+	c.putBlockWithoutLock(b)
+}
+
+// spillBlockWithoutLock - write b into the spill file if it is further than one block from a restart point
+// spilling stops at the first error or once spillSize is reached, later blocks are decompressed again instead
+func (c *CompressedReader) spillBlockWithoutLock(b *compressedBlock) {
+	if c.noSpill || c.closed {
+		return
+	}
+	restart := 0
+	for i := len(c.restarts) - 1; i >= 0; i-- {
+		if c.restarts[i].pos <= b.beg {
+			restart = c.restarts[i].pos
+			break
+		}
+	}
+	if b.beg-restart < c.blockSize {
+		return
+	}
+	if c.spillSize-c.spilledSize < len(b.data) {
+		c.noSpill = true
+		return
+	}
+	if c.spill == nil {
+		err := os.MkdirAll(c.spillDir, 0o700)
+		if err == nil {
+			c.spill, err = os.CreateTemp(c.spillDir, "compressed_")
+		}
+		if err != nil {
+			c.noSpill = true
+			return
+		}
+	}
+	offset, err := c.spill.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = c.spill.Write(b.data)
+	}
+	if err != nil {
+		c.noSpill = true
+		return
+	}
+	c.spilled[b.beg/c.blockSize] = spillBlock{offset: offset, size: len(b.data)}
+	c.spilledSize += len(b.data)
+}
+
+func (c *CompressedReader) putBlockWithoutLock(b *compressedBlock) {
+	c.tick++
+	b.used = c.tick
+	c.cache[b.beg/c.blockSize] = b
+	for len(c.cache) > c.cacheSize {
+		// evict the least recently used block
+		lruIndex, lruUsed := -1, uint64(0)
+		for index, block := range c.cache {
+			if lruIndex < 0 || block.used < lruUsed {
+				lruIndex, lruUsed = index, block.used
+			}
+		}
+		delete(c.cache, lruIndex)
+	}
+}
+
+// block - get block from cache or decompress it from the nearest restart point
+func (c *CompressedReader) block(index int) *compressedBlock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.cache[index]; ok {
+		c.tick++
+		b.used = c.tick
+		return b
+	}
+	beg := index * c.blockSize
+	if s, ok := c.spilled[index]; ok {
+		data := make([]byte, s.size)
+		if _, err := c.spill.ReadAt(data, s.offset); err == nil {
+			b := &compressedBlock{beg: beg, data: data}
+			c.putBlockWithoutLock(b)
+			return b
+		}
+	}
+	cursor := c.cursor
+	if cursor == nil || cursor.pos > beg {
+		cursor = nil
+		for i := len(c.restarts) - 1; i >= 0; i-- {
+			if c.restarts[i].pos <= beg {
+				cursor = c.openCursor(c.restarts[i])
+				break
+			}
+		}
+	}
+	if cursor == nil {
+		return &compressedBlock{beg: beg, data: nil}
+	}
+	data, ok := c.readCursor(cursor, beg-cursor.pos, c.blockSize)
+	b := &compressedBlock{beg: beg, data: data}
+	if ok {
+		c.cursor = cursor
+	} else {
+		c.cursor = nil
+	}
+	c.putBlockWithoutLock(b)
+	return b
+}
+
+func (c *CompressedReader) openCursor(p restartPoint) *compressedCursor {
+	r, next, err := c.format.open(c.src, p.offset)
+	if err != nil {
+		return nil
+	}
+	return &compressedCursor{r: r, next: next, pos: p.pos}
+}
+
+// readCursor - skip n bytes then read up to size bytes, crossing segments if needed
+func (c *CompressedReader) readCursor(cursor *compressedCursor, skip int, size int) ([]byte, bool) {
+	if skip > 0 {
+		n, err := io.CopyN(io.Discard, &segmentsReader{c: c, cursor: cursor}, int64(skip))
+		cursor.pos += int(n)
+		if err != nil {
+			return nil, false
+		}
+	}
+	data := make([]byte, size)
+	n, err := io.ReadFull(&segmentsReader{c: c, cursor: cursor}, data)
+	cursor.pos += n
+	return data[:n], err == nil
+}
+
+// segmentsReader - read from cursor, continue with the next segment at the end of the current one
+type segmentsReader struct {
+	c      *CompressedReader
+	cursor *compressedCursor
+}
+
+func (s *segmentsReader) Read(p []byte) (int, error) {
+	for {
+		n, err := s.cursor.r.Read(p)
+		if err == io.EOF {
+			err = nil
+			if n == 0 {
+				offset := s.cursor.next()
+				if offset >= s.c.src.Len() {
+					return 0, io.EOF
+				}
+				r, next, openErr := s.c.format.open(s.c.src, offset)
+				if openErr != nil {
+					return 0, io.EOF
+				}
+				s.cursor.r, s.cursor.next = r, next
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+func (c *CompressedReader) Len() int {
+	return int(c.length.Load())
+}
+
+func (c *CompressedReader) At(i int) byte {
+	b := c.last.Load()
+	if b == nil || i < b.beg || i >= b.beg+len(b.data) {
+		if int64(i) > c.requested.Load() {
+			c.requested.Store(int64(i))
+		}
+		b = c.block(i / c.blockSize)
+		c.last.Store(b)
+	}
+	if i-b.beg >= len(b.data) {
+		return 0
+	}
+	return b.data[i-b.beg]
+}
+
+func (c *CompressedReader) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err - decompression error after Done is closed
+func (c *CompressedReader) Err() error {
+	<-c.done
+	return c.err
+}
+
+// Close - stop the background scan and remove the spill file
+func (c *CompressedReader) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.cache = make(map[int]*compressedBlock)
+	c.spilled = make(map[int]spillBlock)
+	if c.spill == nil {
+		return nil
+	}
+	_ = c.spill.Close()
+	return os.Remove(c.spill.Name())
+}
+
+// compressedFormat - a compressed input is a sequence of segments, each can be decompressed independently
+type compressedFormat interface {
+	// open - decompressor of the segment at offset, next returns the offset of the next segment
+	// once the decompressor reaches EOF
+	open(src Reader, offset int) (r io.Reader, next func() int, err error)
+}
+
+type gzipFormat struct{}
+
+func (gzipFormat) open(src Reader, offset int) (io.Reader, func() int, error) {
+	section := &sectionReader{reader: src, pos: offset, end: src.Len()}
+	// sectionReader is an io.ByteReader so that gzip does not read ahead of the member
+	z, err := gzip.NewReader(section)
+	if err != nil {
+		return nil, nil, err
+	}
+	z.Multistream(false)
+	return z, func() int { return section.pos }, nil
+}
+
+// deflateFormat - a single raw deflate segment spanning the whole input
+type deflateFormat struct{}
+
+func (deflateFormat) open(src Reader, offset int) (io.Reader, func() int, error) {
+	section := &sectionReader{reader: src, pos: offset, end: src.Len()}
+	return flate.NewReader(section), func() int { return src.Len() }, nil
+}
+
+type bzip2Format struct {
+	mu   sync.Mutex
+	ends map[int]int // offset of a stream -> offset of the next stream
+}
+
+var bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+
+func (f *bzip2Format) open(src Reader, offset int) (io.Reader, func() int, error) {
+	if !isBzip2Stream(src, offset) {
+		return nil, nil, errors.New("bzip2: invalid stream header")
+	}
+	f.mu.Lock()
+	end, ok := f.ends[offset]
+	if !ok {
+		// streams are byte aligned and start with a header followed by a block magic
+		end = offset + 1
+		for end < src.Len() && !isBzip2Stream(src, end) {
+			end++
+		}
+		f.ends[offset] = end
+	}
+	f.mu.Unlock()
+	section := &sectionReader{reader: src, pos: offset, end: end}
+	return bzip2.NewReader(section), func() int { return end }, nil
+}
+
+func isBzip2Stream(src Reader, offset int) bool {
+	if !hasPrefix(src, offset, []byte("BZh")) || !hasPrefix(src, offset+4, bzip2BlockMagic) {
+		return false
+	}
+	level := src.At(offset + 3)
+	return '1' <= level && level <= '9'
+}
+
+func hasPrefix(src Reader, offset int, prefix []byte) bool {
+	if offset+len(prefix) > src.Len() {
+		return false
+	}
+	buf := make([]byte, len(prefix))
+	for i := range buf {
+		buf[i] = src.At(offset + i)
+	}
+	return bytes.Equal(buf, prefix)
+}
+
+// sectionReader - io.Reader and io.ByteReader over [pos, end) of a Reader
+// bytes are read ahead into buf but pos is the next byte returned, decompressors see no read ahead
+type sectionReader struct {
+	reader Reader
+	pos    int
+	end    int
+	buf    []byte // bytes from bufPos
+	bufPos int
+}
+
+// sectionBufferSize - bytes read ahead by ReadByte
+const sectionBufferSize = 4096
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if s.pos >= s.end {
+		return 0, io.EOF
+	}
+	n := min(len(p), s.end-s.pos)
+	clear(p[Read(s.reader, p[:n], s.pos):n]) // bytes after the end of a truncated reader read as zeros
+	s.pos += n
+	return n, nil
+}
+
+func (s *sectionReader) ReadByte() (byte, error) {
+	if s.pos >= s.end {
+		return 0, io.EOF
+	}
+	if s.pos < s.bufPos || s.pos >= s.bufPos+len(s.buf) {
+		if s.buf == nil {
+			s.buf = make([]byte, sectionBufferSize)
+		}
+		s.buf = s.buf[:cap(s.buf)]
+		n := min(len(s.buf), s.end-s.pos)
+		clear(s.buf[Read(s.reader, s.buf[:n], s.pos):n])
+		s.buf, s.bufPos = s.buf[:n], s.pos
+	}
+	b := s.buf[s.pos-s.bufPos]
+	s.pos++
+	return b, nil
+}
@@ -0,0 +1,64 @@
+package buffer
+
+import (
+	"io"
+	"sort"
+)
+
+// ConcatReader - readers stitched together into one
+type ConcatReader struct {
+	readers []Reader
+	offsets []int // offsets[i] is the offset of readers[i]
+	length  int
+}
+
+func Concat(readers ...Reader) *ConcatReader {
+	c := &ConcatReader{
+		readers: readers,
+		offsets: make([]int, len(readers)),
+		length:  0,
+	}
+	for i, reader := range readers {
+		c.offsets[i] = c.length
+		c.length += reader.Len()
+	}
+	return c
+}
+
+// Offset - offset of the i-th reader
+func (c *ConcatReader) Offset(i int) int {
+	return c.offsets[i]
+}
+
+// Locate - index of the reader containing byte i and the offset of byte i in that reader
+func (c *ConcatReader) Locate(i int) (index int, local int) {
+	index = sort.Search(len(c.offsets), func(j int) bool {
+		return c.offsets[j] > i
+	}) - 1 // the last reader starting at or before i, empty readers before it share its offset
+	return index, i - c.offsets[index]
+}
+
+func (c *ConcatReader) Len() int {
+	return c.length
+}
+
+func (c *ConcatReader) At(i int) byte {
+	index, local := c.Locate(i)
+	return c.readers[index].At(local)
+}
+
+func (c *ConcatReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && int(off)+n < c.length {
+		index, local := c.Locate(int(off) + n)
+		m := Read(c.readers[index], p[n:min(len(p), n+c.readers[index].Len()-local)], local)
+		if m == 0 {
+			break
+		}
+		n += m
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,32 @@
+package buffer
+
+import "sync/atomic"
+
+// GrowReader - reader over a growing source, e.g. a log file that is still being written
+// the current reader can be replaced by a longer one starting with the same content
+type GrowReader struct {
+	reader atomic.Pointer[Reader]
+}
+
+func NewGrowReader(reader Reader) *GrowReader {
+	g := &GrowReader{}
+	g.reader.Store(&reader)
+	return g
+}
+
+// Grow - replace the current reader, the previous reader is returned
+func (g *GrowReader) Grow(reader Reader) Reader {
+	return *g.reader.Swap(&reader)
+}
+
+func (g *GrowReader) Len() int {
+	return (*g.reader.Load()).Len()
+}
+
+func (g *GrowReader) At(i int) byte {
+	return (*g.reader.Load()).At(i)
+}
+
+func (g *GrowReader) ReadAt(p []byte, off int64) (int, error) {
+	return readAt(*g.reader.Load(), p, off)
+}
@@ -0,0 +1,325 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+HTTPReader - random access reader over HTTP range requests
+
+the file is fetched in blocks with Range GETs, recently used blocks are kept in an LRU cache and the
+blocks following a cache miss are prefetched in the background since readers mostly scan forward.
+every request times out so that At never blocks for long, a block that cannot be fetched is not cached so
+that it is fetched again later, At reads it as zeros and ReadAt stops short before it, the last error is
+kept in Err and the number of failed reads in Failures.
+*/
+type HTTPReader struct {
+	client    *http.Client
+	url       string
+	timeout   time.Duration
+	length    int
+	blockSize int
+	cacheSize int
+	prefetch  int
+
+	ctx    context.Context
+	cancel func()
+	last   atomic.Pointer[httpBlock]
+
+	mu       sync.Mutex // the fields below are protected by mu
+	cache    map[int]*httpBlock
+	pending  map[int]chan struct{} // blocks being fetched, closed when the fetch finishes
+	tick     uint64
+	err      error
+	failures int
+}
+
+type httpBlock struct {
+	beg  int
+	data []byte
+	used uint64
+}
+
+const httpRetries = 3
+
+// IsHTTPURL - name is an http or https url
+func IsHTTPURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+func NewHTTPReader(url string, blockSize int, cacheSize int, prefetch int, timeout time.Duration) (*HTTPReader, error) {
+	if blockSize <= 0 || cacheSize <= prefetch || timeout <= 0 {
+		return nil, errors.New("invalid block, cache or prefetch size or timeout")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &HTTPReader{
+		client:    &http.Client{Timeout: timeout},
+		url:       url,
+		timeout:   timeout,
+		blockSize: blockSize,
+		cacheSize: cacheSize,
+		prefetch:  prefetch,
+		ctx:       ctx,
+		cancel:    cancel,
+		cache:     make(map[int]*httpBlock),
+		pending:   make(map[int]chan struct{}),
+	}
+	length, err := h.fetchLength()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	h.length = length
+	return h, nil
+}
+
+// fetchLength - request the first byte, the total length is in Content-Range
+func (h *HTTPReader) fetchLength() (int, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, h.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		// 416 - empty file, Content-Range is "bytes */0"
+	case http.StatusOK:
+		if resp.ContentLength == 0 {
+			return 0, nil // range is ignored for empty files
+		}
+		return 0, errors.New("server does not support range requests")
+	default:
+		return 0, fmt.Errorf("http status %s", resp.Status)
+	}
+	contentRange := resp.Header.Get("Content-Range")
+	i := strings.LastIndexByte(contentRange, '/')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	length, err := strconv.Atoi(contentRange[i+1:])
+	if err != nil {
+		return 0, fmt.Errorf("unknown length in Content-Range %q", contentRange)
+	}
+	return length, nil
+}
+
+// fetch - get bytes [beg, end) with retries
+func (h *HTTPReader) fetch(beg int, end int) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt < httpRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-h.ctx.Done():
+				return nil, h.ctx.Err()
+			case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+			}
+		}
+		var data []byte
+		data, err = h.fetchOnce(beg, end)
+		if err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// fetchOnce - a single request, it is cancelled when the reader is closed or after the timeout
+func (h *HTTPReader) fetchOnce(beg int, end int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, h.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", beg, end-1))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("http status %s", resp.Status)
+	}
+	data := make([]byte, end-beg)
+	_, err = io.ReadFull(resp.Body, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (h *HTTPReader) putBlockWithoutLock(index int, b *httpBlock) {
+	h.tick++
+	b.used = h.tick
+	h.cache[index] = b
+	for len(h.cache) > h.cacheSize {
+		// evict the least recently used block
+		lruIndex, lruUsed := -1, uint64(0)
+		for index, block := range h.cache {
+			if lruIndex < 0 || block.used < lruUsed {
+				lruIndex, lruUsed = index, block.used
+			}
+		}
+		delete(h.cache, lruIndex)
+	}
+}
+
+// block - get block from cache, wait for it if it is being fetched, fetch it otherwise
+func (h *HTTPReader) block(index int) (*httpBlock, error) {
+	h.mu.Lock()
+	for {
+		if b, ok := h.cache[index]; ok {
+			h.tick++
+			b.used = h.tick
+			h.prefetchWithoutLock(index)
+			h.mu.Unlock()
+			return b, nil
+		}
+		wait, ok := h.pending[index]
+		if !ok {
+			break
+		}
+		h.mu.Unlock()
+		<-wait
+		h.mu.Lock()
+		if _, ok := h.cache[index]; !ok {
+			// prefetch failed, fetch it here
+			break
+		}
+	}
+	h.startFetchWithoutLock(index)
+	h.prefetchWithoutLock(index)
+	h.mu.Unlock()
+	b, err := h.load(index)
+	if err != nil {
+		h.mu.Lock()
+		h.failures++
+		h.mu.Unlock()
+	}
+	return b, err
+}
+
+// prefetchWithoutLock - fetch the blocks after index in the background
+func (h *HTTPReader) prefetchWithoutLock(index int) {
+	for next := index + 1; next <= index+h.prefetch && next*h.blockSize < h.length; next++ {
+		if _, ok := h.cache[next]; ok {
+			continue
+		}
+		if _, ok := h.pending[next]; ok {
+			continue
+		}
+		h.startFetchWithoutLock(next)
+		go h.load(next)
+	}
+}
+
+func (h *HTTPReader) startFetchWithoutLock(index int) {
+	if _, ok := h.pending[index]; !ok {
+		h.pending[index] = make(chan struct{})
+	}
+}
+
+// load - fetch a block started by startFetchWithoutLock, failed blocks are not cached
+func (h *HTTPReader) load(index int) (*httpBlock, error) {
+	beg := index * h.blockSize
+	end := min(beg+h.blockSize, h.length)
+	data, err := h.fetch(beg, end)
+	b := &httpBlock{beg: beg, data: data}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.err = err
+		b = nil
+	} else {
+		h.putBlockWithoutLock(index, b)
+	}
+	if wait, ok := h.pending[index]; ok {
+		close(wait)
+		delete(h.pending, index)
+	}
+	return b, err
+}
+
+func (h *HTTPReader) Len() int {
+	return h.length
+}
+
+// blockAt - block of byte i, only fetched blocks are kept in last
+func (h *HTTPReader) blockAt(i int) (*httpBlock, error) {
+	if b := h.last.Load(); b != nil && b.beg <= i && i < b.beg+len(b.data) {
+		return b, nil
+	}
+	b, err := h.block(i / h.blockSize)
+	if err != nil {
+		return nil, err
+	}
+	h.last.Store(b)
+	return b, nil
+}
+
+func (h *HTTPReader) At(i int) byte {
+	if i < 0 || i >= h.length {
+		return 0
+	}
+	b, err := h.blockAt(i)
+	if err != nil {
+		return 0
+	}
+	return b.data[i-b.beg]
+}
+
+// ReadAt - bytes from off, it stops short before a block that cannot be fetched
+func (h *HTTPReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && int(off)+n < h.length {
+		i := int(off) + n
+		b, err := h.blockAt(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], b.data[i-b.beg:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Err - the last fetch error since the previous call, nil if every fetch succeeded
+func (h *HTTPReader) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	err := h.err
+	h.err = nil
+	return err
+}
+
+// Failures - number of reads that got no data since the reader was opened
+func (h *HTTPReader) Failures() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failures
+}
+
+// Close - cancel pending requests
+func (h *HTTPReader) Close() error {
+	h.cancel()
+	return nil
+}
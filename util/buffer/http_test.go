@@ -0,0 +1,231 @@
+package buffer
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeServer - serves content with range requests, counts the requests and fails them while failing is set
+type rangeServer struct {
+	*httptest.Server
+	content  []byte
+	requests atomic.Int64
+	failing  atomic.Bool
+	delay    atomic.Int64 // nanoseconds before answering
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newRangeServer(t *testing.T, content []byte) *rangeServer {
+	s := &rangeServer{content: content}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+		if d := time.Duration(s.delay.Load()); d > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(d):
+			}
+		}
+		if s.failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.content))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func readAll(r Reader) []byte {
+	data := make([]byte, r.Len())
+	for i := range data {
+		data[i] = r.At(i)
+	}
+	return data
+}
+
+func testContent(n int) []byte {
+	content := make([]byte, n)
+	for i := range content {
+		content[i] = byte('a' + i%26)
+	}
+	return content
+}
+
+func TestHTTPReaderRange(t *testing.T) {
+	content := testContent(10000)
+	s := newRangeServer(t, content)
+	h, err := NewHTTPReader(s.URL, 1024, 4, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if h.Len() != len(content) {
+		t.Fatalf("length %d, expected %d", h.Len(), len(content))
+	}
+	if h.At(5000) != content[5000] || h.At(9999) != content[9999] || h.At(0) != content[0] {
+		t.Fatal("wrong byte")
+	}
+	s.mu.Lock()
+	ranges := strings.Join(s.ranges, " ")
+	s.mu.Unlock()
+	expected := "bytes=0-0 bytes=4096-5119 bytes=9216-9999 bytes=0-1023"
+	if ranges != expected {
+		t.Fatalf("ranges %q, expected %q", ranges, expected)
+	}
+	if !bytes.Equal(readAll(h), content) {
+		t.Fatal("wrong content")
+	}
+	if err := h.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPReaderCache(t *testing.T) {
+	content := testContent(4096)
+	s := newRangeServer(t, content)
+	h, err := NewHTTPReader(s.URL, 1024, 4, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if !bytes.Equal(readAll(h), content) {
+		t.Fatal("wrong content")
+	}
+	requests := s.requests.Load()
+	if requests > 5 {
+		t.Fatalf("%d requests for 4 blocks", requests)
+	}
+	// every block is cached, reading again sends no request
+	for i := len(content) - 1; i >= 0; i-- {
+		if h.At(i) != content[i] {
+			t.Fatalf("wrong byte at %d", i)
+		}
+	}
+	if s.requests.Load() != requests {
+		t.Fatalf("%d requests after reading cached blocks, expected %d", s.requests.Load(), requests)
+	}
+}
+
+func TestHTTPReaderEmpty(t *testing.T) {
+	s := newRangeServer(t, nil)
+	h, err := NewHTTPReader(s.URL, 1024, 4, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if h.Len() != 0 {
+		t.Fatalf("length %d, expected 0", h.Len())
+	}
+}
+
+func TestHTTPReaderNoRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("no range support"))
+	}))
+	defer server.Close()
+	if _, err := NewHTTPReader(server.URL, 1024, 4, 2, time.Second); err == nil {
+		t.Fatal("expected error for a server without range requests")
+	}
+}
+
+func TestHTTPReaderStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if _, err := NewHTTPReader(server.URL, 1024, 4, 2, time.Second); err == nil {
+		t.Fatal("expected error for a missing url")
+	}
+}
+
+func TestHTTPReaderFetchError(t *testing.T) {
+	content := testContent(4096)
+	s := newRangeServer(t, content)
+	h, err := NewHTTPReader(s.URL, 1024, 4, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	s.failing.Store(true)
+	if h.At(100) != 0 {
+		t.Fatal("failed block should read as zeros")
+	}
+	if err := h.Err(); err == nil {
+		t.Fatal("expected fetch error")
+	}
+	if err := h.Err(); err != nil {
+		t.Fatalf("error reported twice: %v", err)
+	}
+	// the failed block is not cached, it is fetched again once the server is back
+	s.failing.Store(false)
+	if h.At(2000) != content[2000] || h.At(100) != content[100] || h.At(101) != content[101] {
+		t.Fatal("block not fetched again")
+	}
+	if err := h.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPReaderTimeout(t *testing.T) {
+	content := testContent(4096)
+	s := newRangeServer(t, content)
+	h, err := NewHTTPReader(s.URL, 1024, 4, 0, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	s.delay.Store(int64(time.Second))
+	start := time.Now()
+	if h.At(2000) != 0 {
+		t.Fatal("block that timed out should read as zeros")
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Fatalf("read blocked for %v", elapsed)
+	}
+	if err := h.Err(); err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestHTTPReaderReadAtFailure(t *testing.T) {
+	content := testContent(4096)
+	s := newRangeServer(t, content)
+	h, err := NewHTTPReader(s.URL, 1024, 4, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	p := make([]byte, 2048)
+	if n, err := h.ReadAt(p[:1024], 0); n != 1024 || err != nil {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	s.failing.Store(true)
+	// the first block is cached, the read stops short before the second one
+	if n, err := h.ReadAt(p, 512); n != 512 || err == nil || !bytes.Equal(p[:n], content[512:1024]) {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	if h.Failures() != 1 {
+		t.Fatalf("%d failures", h.Failures())
+	}
+	// the failed block is not kept as the last block read
+	s.failing.Store(false)
+	if h.At(1500) != content[1500] || h.At(1501) != content[1501] {
+		t.Fatal("failed block read again as zeros")
+	}
+	if n, err := h.ReadAt(p, 3000); n != 1096 || err == nil || !bytes.Equal(p[:n], content[3000:]) {
+		t.Fatalf("read %d bytes at the end: %v", n, err)
+	}
+	if h.Failures() != 1 {
+		t.Fatalf("%d failures", h.Failures())
+	}
+}
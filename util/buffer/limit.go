@@ -0,0 +1,93 @@
+package buffer
+
+import (
+	"io"
+	"math"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// LimitReader - reader whose length can be lowered at any time, e.g. when the underlying file is truncated
+// bytes beyond the limit are never read from the underlying reader
+// a memory fault reading a mapping past the end of its truncated file lowers the limit to the faulting byte
+// instead of crashing, the bytes from there on read as zeros
+type LimitReader struct {
+	reader Reader
+	limit  atomic.Int64
+}
+
+func NewLimitReader(reader Reader) *LimitReader {
+	l := &LimitReader{
+		reader: reader,
+	}
+	l.limit.Store(math.MaxInt64)
+	return l
+}
+
+func (l *LimitReader) Limit(n int) {
+	for {
+		limit := l.limit.Load()
+		if int64(n) >= limit || l.limit.CompareAndSwap(limit, int64(n)) {
+			return
+		}
+	}
+}
+
+func (l *LimitReader) Len() int {
+	return int(min(l.limit.Load(), int64(l.reader.Len())))
+}
+
+func (l *LimitReader) At(i int) (b byte) {
+	if int64(i) >= l.limit.Load() {
+		return 0
+	}
+	defer l.recoverFault(i)
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	return l.reader.At(i)
+}
+
+func (l *LimitReader) ReadAt(p []byte, off int64) (int, error) {
+	n, faulted := l.readAt(p[:max(0, min(len(p), l.Len()-int(off)))], off)
+	if faulted {
+		// read again byte by byte, the limit is lowered to the first byte that faults
+		for n = 0; n < len(p) && int(off)+n < l.Len(); n++ {
+			p[n] = l.At(int(off) + n)
+		}
+		n = min(n, max(0, l.Len()-int(off)))
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readAt - read p from the underlying reader, false if reading faulted
+func (l *LimitReader) readAt(p []byte, off int64) (n int, faulted bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if !isFault(r) {
+				panic(r)
+			}
+			faulted = true
+		}
+	}()
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	n, _ = readAt(l.reader, p, off)
+	return n, false
+}
+
+// recoverFault - lower the limit to i after a memory fault reading byte i, other panics go on
+func (l *LimitReader) recoverFault(i int) {
+	if r := recover(); r != nil {
+		if !isFault(r) {
+			panic(r)
+		}
+		l.Limit(i)
+	}
+}
+
+// isFault - r is the panic of a memory fault, see debug.SetPanicOnFault
+func isFault(r any) bool {
+	_, ok := r.(interface{ Addr() uintptr })
+	return ok
+}
@@ -1,10 +1,35 @@
 package buffer
 
+import "io"
+
 type Reader interface {
 	Len() int
 	At(i int) byte
 }
 
+// Read - copy bytes from offset off of reader into p, readers implementing io.ReaderAt are read at once
+// the number of bytes copied is returned, it is less than len(p) at the end of reader
+func Read(reader Reader, p []byte, off int) int {
+	n := max(0, min(len(p), reader.Len()-off))
+	if r, ok := reader.(io.ReaderAt); ok {
+		m, _ := r.ReadAt(p[:n], int64(off))
+		return m
+	}
+	for i := 0; i < n; i++ {
+		p[i] = reader.At(off + i)
+	}
+	return n
+}
+
+// readAt - io.ReaderAt of readers wrapping another reader
+func readAt(reader Reader, p []byte, off int64) (int, error) {
+	n := Read(reader, p, int(off))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
 type SliceReader struct {
 	reader Reader
 	beg    int
@@ -19,6 +44,11 @@ func (s SliceReader) At(i int) byte {
 	return s.reader.At(i + s.beg)
 }
 
+func (s SliceReader) ReadAt(p []byte, off int64) (int, error) {
+	p = p[:max(0, min(len(p), s.len-int(off)))]
+	return readAt(s.reader, p, off+int64(s.beg))
+}
+
 func Slice(reader Reader, beg int, end int) Reader {
 	if r, ok := reader.(SliceReader); ok {
 		return SliceReader{
@@ -50,3 +80,20 @@ func (m *memBuffer) Len() int {
 func (m *memBuffer) At(i int) byte {
 	return m.b[i]
 }
+
+func (m *memBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Stream - reader that keeps growing until Done is closed
+type Stream interface {
+	Reader
+	Done() <-chan struct{}
+}
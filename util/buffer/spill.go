@@ -0,0 +1,144 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/mmap"
+)
+
+// SpillReader - reader over an input that cannot be mapped (stdin, pipe, special file)
+// the input is copied in the background into spill files of blockSize bytes in dir, every full block is
+// mapped and the last block is kept in memory, the reader can be used while the input is still arriving
+type SpillReader struct {
+	dir       string
+	blockSize int
+
+	state  atomic.Pointer[spillState]
+	length atomic.Int64
+	done   chan struct{}
+	err    error // read error, valid after done is closed
+
+	mu     sync.Mutex // protect closed
+	closed bool
+}
+
+type spillState struct {
+	blocks []*mmap.ReaderAt // mapped full blocks
+	tail   []byte           // last block, bytes beyond length are being written
+}
+
+func NewSpillReader(r io.Reader, dir string, blockSize int) (*SpillReader, error) {
+	if blockSize <= 0 {
+		return nil, errors.New("invalid block size")
+	}
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	s := &SpillReader{
+		dir:       dir,
+		blockSize: blockSize,
+		done:      make(chan struct{}),
+	}
+	s.state.Store(&spillState{
+		blocks: nil,
+		tail:   make([]byte, blockSize),
+	})
+	go s.spill(r)
+	return s, nil
+}
+
+func (s *SpillReader) spill(r io.Reader) {
+	defer close(s.done)
+	n := 0 // number of bytes in tail
+	for {
+		st := s.state.Load()
+		k, err := r.Read(st.tail[n:])
+		if k > 0 {
+			n += k
+			s.length.Add(int64(k))
+		}
+		if n == s.blockSize {
+			if flushErr := s.flush(st); flushErr != nil {
+				s.err = flushErr
+				return
+			}
+			n = 0
+		}
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+			return
+		}
+	}
+}
+
+// flush - write the full tail into a spill file and map it
+func (s *SpillReader) flush(st *spillState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("spill reader closed")
+	}
+	filename := filepath.Join(s.dir, fmt.Sprintf("block_%08d", len(st.blocks)))
+	err := os.WriteFile(filename, st.tail, 0o600)
+	if err != nil {
+		return err
+	}
+	block, err := mmap.Open(filename)
+	if err != nil {
+		return err
+	}
+	blocks := make([]*mmap.ReaderAt, 0, len(st.blocks)+1)
+	blocks = append(blocks, st.blocks...)
+	blocks = append(blocks, block)
+	s.state.Store(&spillState{
+		blocks: blocks,
+		tail:   make([]byte, s.blockSize),
+	})
+	return nil
+}
+
+func (s *SpillReader) Len() int {
+	return int(s.length.Load())
+}
+
+func (s *SpillReader) At(i int) byte {
+	st := s.state.Load()
+	b := i / s.blockSize
+	if b < len(st.blocks) {
+		return st.blocks[b].At(i % s.blockSize)
+	}
+	return st.tail[i%s.blockSize]
+}
+
+func (s *SpillReader) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err - read error after Done is closed
+func (s *SpillReader) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close - unmap and remove spill files, the input might still be blocked on reading
+func (s *SpillReader) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for _, block := range s.state.Load().blocks {
+		_ = block.Close()
+	}
+	return os.RemoveAll(s.dir)
+}
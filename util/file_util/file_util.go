@@ -2,10 +2,12 @@ package file_util
 
 import (
 	"bufio"
+	"compress/gzip"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"telescope/config"
 	"telescope/util/side_channel"
 )
@@ -21,7 +23,19 @@ func NonEmpty(filename string) bool {
 	return info.Size() > 0
 }
 
-func writeFile(filename string, iter func(f func(i int, val []rune) bool)) error {
+func Exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// WriteOptions - format of the written file
+type WriteOptions struct {
+	Gzip           bool         // the output is gzip compressed
+	NoFinalNewline bool         // no line break is written after the last line
+	Check          func() error // called once every line is written, the output file is left as is if it fails
+}
+
+func writeFile(filename string, iter func(f func(i int, val []byte) bool), opts WriteOptions) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -29,9 +43,37 @@ func writeFile(filename string, iter func(f func(i int, val []rune) bool)) error
 	defer file.Close()
 	writer := bufio.NewWriter(file)
 	defer writer.Flush()
+	var w io.Writer = writer
+	var gzipWriter *gzip.Writer = nil
+	if opts.Gzip {
+		// recompress
+		gzipWriter = gzip.NewWriter(writer)
+		w = gzipWriter
+	}
 
+	// line breaks are written before every line but the first so that the last one can be left out
+	n := 0
 	for _, line := range iter {
-		_, err = writer.WriteString(string(line) + "\n")
+		if n > 0 {
+			_, err = w.Write([]byte{'\n'})
+			if err != nil {
+				return err
+			}
+		}
+		_, err = w.Write(line)
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if n > 0 && !opts.NoFinalNewline {
+		_, err = w.Write([]byte{'\n'})
+		if err != nil {
+			return err
+		}
+	}
+	if gzipWriter != nil {
+		err = gzipWriter.Close()
 		if err != nil {
 			return err
 		}
@@ -101,7 +143,13 @@ func moveFile(dst string, src string) error {
 	return nil
 }
 
-func SafeWriteFile(filename string, iter func(f func(i int, val []rune) bool)) error {
+// SafeWriteFile - write lines into a tmp file then move it into filename, lines are written byte-exact
+func SafeWriteFile(filename string, iter func(f func(i int, val []byte) bool)) error {
+	return SafeWriteFileWith(filename, iter, WriteOptions{})
+}
+
+// SafeWriteFileWith - SafeWriteFile in the format given by opts
+func SafeWriteFileWith(filename string, iter func(f func(i int, val []byte) bool), opts WriteOptions) error {
 	absPath, _ := filepath.Abs(filename)
 	tmpFilename := filepath.Join(config.Load().TMP_DIR, absPath)
 
@@ -113,11 +161,17 @@ func SafeWriteFile(filename string, iter func(f func(i int, val []rune) bool)) e
 	}
 
 	// write into tmp file
-	err = writeFile(tmpFilename, iter)
+	err = writeFile(tmpFilename, iter, opts)
 	if err != nil {
 		side_channel.WriteLn(err)
 		return err
 	}
+	if opts.Check != nil {
+		if err = opts.Check(); err != nil {
+			_ = os.Remove(tmpFilename)
+			return err
+		}
+	}
 
 	// move tmp file into output file
 	err = moveFile(filename, tmpFilename)
@@ -127,3 +181,17 @@ func SafeWriteFile(filename string, iter func(f func(i int, val []rune) bool)) e
 	}
 	return nil
 }
+
+// SplitArchivePath - split "archive:member" when the whole name is not a file but archive is
+func SplitArchivePath(name string) (archive string, member string, ok bool) {
+	if Exists(name) {
+		return "", "", false
+	}
+	for i := strings.LastIndexByte(name, ':'); i > 0; i = strings.LastIndexByte(name[:i], ':') {
+		info, err := os.Stat(name[:i])
+		if err == nil && info.Mode().IsRegular() {
+			return name[:i], name[i+1:], true
+		}
+	}
+	return "", "", false
+}
@@ -63,6 +63,19 @@ func Merge[T any](ss ...Seq[T]) Seq[T] {
 	return s
 }
 
+// FromSlice - balanced sequence of vals in O(n)
+func FromSlice[T any](vals []T) Seq[T] {
+	var build func(vals []T) *node[T]
+	build = func(vals []T) *node[T] {
+		if len(vals) == 0 {
+			return nil
+		}
+		mid := len(vals) / 2
+		return makeNode(vals[mid], build(vals[:mid]), build(vals[mid+1:]))
+	}
+	return Seq[T]{node: build(vals)}
+}
+
 // monad
 
 func Fmap[T any, T1 any](xs Seq[T], f func(T) T1) Seq[T1] {